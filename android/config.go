@@ -1063,6 +1063,14 @@ func (c *deviceConfig) RecoverySnapshotVersion() string {
 	return String(c.config.productVariables.RecoverySnapshotVersion)
 }
 
+func (c *deviceConfig) RamdiskSnapshotVersion() string {
+	return String(c.config.productVariables.RamdiskSnapshotVersion)
+}
+
+func (c *deviceConfig) VendorRamdiskSnapshotVersion() string {
+	return String(c.config.productVariables.VendorRamdiskSnapshotVersion)
+}
+
 func (c *deviceConfig) CurrentApiLevelForVendorModules() string {
 	return StringDefault(c.config.productVariables.DeviceCurrentApiLevelForVendorModules, "current")
 }
@@ -1098,6 +1106,20 @@ func (c *deviceConfig) OdmPath() string {
 	return "odm"
 }
 
+func (c *deviceConfig) VendorDlkmPath() string {
+	if c.config.productVariables.VendorDlkmPath != nil {
+		return *c.config.productVariables.VendorDlkmPath
+	}
+	return "vendor_dlkm"
+}
+
+func (c *deviceConfig) OdmDlkmPath() string {
+	if c.config.productVariables.OdmDlkmPath != nil {
+		return *c.config.productVariables.OdmDlkmPath
+	}
+	return "odm_dlkm"
+}
+
 func (c *deviceConfig) ProductPath() string {
 	if c.config.productVariables.ProductPath != nil {
 		return *c.config.productVariables.ProductPath
@@ -1422,6 +1444,178 @@ func (c *deviceConfig) RecoverySnapshotModules() map[string]bool {
 	return c.config.productVariables.RecoverySnapshotModules
 }
 
+func (c *deviceConfig) StrictSnapshotVersions() bool {
+	return c.config.productVariables.StrictSnapshotVersions
+}
+
+func (c *deviceConfig) KeepEmptySnapshotArchDirs() bool {
+	return c.config.productVariables.KeepEmptySnapshotArchDirs
+}
+
+func (c *deviceConfig) BuildSnapshotSymbols() bool {
+	return c.config.productVariables.BuildSnapshotSymbols
+}
+
+func (c *deviceConfig) BuildSnapshotProfileData() bool {
+	return c.config.productVariables.BuildSnapshotProfileData
+}
+
+func (c *deviceConfig) BuildSnapshotAfdoProfiles() bool {
+	return c.config.productVariables.BuildSnapshotAfdoProfiles
+}
+
+func (c *deviceConfig) BuildSnapshotTocs() bool {
+	return c.config.productVariables.BuildSnapshotTocs
+}
+
+func (c *deviceConfig) BuildSnapshotKernelModules() bool {
+	return c.config.productVariables.BuildSnapshotKernelModules
+}
+
+func (c *deviceConfig) SortSnapshotJsonKeys() bool {
+	return c.config.productVariables.SortSnapshotJsonKeys
+}
+
+func (c *deviceConfig) SnapshotZipRootArchDir() bool {
+	return c.config.productVariables.SnapshotZipRootArchDir
+}
+
+func (c *deviceConfig) SplitSnapshotZips() bool {
+	return c.config.productVariables.SplitSnapshotZips
+}
+
+func (c *deviceConfig) SnapshotZipPrefix() string {
+	return String(c.config.productVariables.SnapshotZipPrefix)
+}
+
+func (c *deviceConfig) InlineSnapshotUnstripped() bool {
+	return c.config.productVariables.InlineSnapshotUnstripped
+}
+
+func (c *deviceConfig) BuildSnapshotProvenance() bool {
+	return c.config.productVariables.BuildSnapshotProvenance
+}
+
+func (c *deviceConfig) BuildSnapshotArchiveMembers() bool {
+	return c.config.productVariables.BuildSnapshotArchiveMembers
+}
+
+func (c *deviceConfig) BoardVendorSnapshotHeadersOnly() bool {
+	return c.config.productVariables.BoardVendorSnapshotHeadersOnly
+}
+
+func (c *deviceConfig) BoardVendorSnapshotDirFilter() []string {
+	return c.config.productVariables.BoardVendorSnapshotDirFilter
+}
+
+func (c *deviceConfig) BoardVendorSnapshotLinkerConfigs() []string {
+	return c.config.productVariables.BoardVendorSnapshotLinkerConfigs
+}
+
+func (c *deviceConfig) BoardVendorSnapshotArches() []string {
+	return c.config.productVariables.BoardVendorSnapshotArches
+}
+
+func (c *deviceConfig) BoardVendorSnapshotAllowlist() string {
+	return String(c.config.productVariables.BoardVendorSnapshotAllowlist)
+}
+
+func (c *deviceConfig) SnapshotPrerelease() bool {
+	return c.config.productVariables.SnapshotPrerelease
+}
+
+func (c *deviceConfig) BuildSnapshotLlndk() bool {
+	return c.config.productVariables.BuildSnapshotLlndk
+}
+
+func (c *deviceConfig) SnapshotNonInstallableLibs() bool {
+	return c.config.productVariables.SnapshotNonInstallableLibs
+}
+
+func (c *deviceConfig) ValidateSnapshotJson() bool {
+	return c.config.productVariables.ValidateSnapshotJson
+}
+
+func (c *deviceConfig) ValidateSnapshotElfMachine() bool {
+	return c.config.productVariables.ValidateSnapshotElfMachine
+}
+
+func (c *deviceConfig) BuildSnapshotBlueprints() bool {
+	return c.config.productVariables.BuildSnapshotBlueprints
+}
+
+func (c *deviceConfig) BuildSnapshotSbom() bool {
+	return c.config.productVariables.BuildSnapshotSbom
+}
+
+func (c *deviceConfig) PerImageSnapshotIncludeDirs() bool {
+	return c.config.productVariables.PerImageSnapshotIncludeDirs
+}
+
+func (c *deviceConfig) PerImageSnapshotNoticeDirs() bool {
+	return c.config.productVariables.PerImageSnapshotNoticeDirs
+}
+
+func (c *deviceConfig) StrictSnapshotExportedDirs() bool {
+	return c.config.productVariables.StrictSnapshotExportedDirs
+}
+
+func (c *deviceConfig) StrictSnapshotDeps() bool {
+	return c.config.productVariables.StrictSnapshotDeps
+}
+
+func (c *deviceConfig) StrictSnapshotCfiDiag() bool {
+	return c.config.productVariables.StrictSnapshotCfiDiag
+}
+
+func (c *deviceConfig) StrictSnapshotAllowlist() bool {
+	return c.config.productVariables.StrictSnapshotAllowlist
+}
+
+func (c *deviceConfig) BoardSnapshotProfiles() []string {
+	return c.config.productVariables.BoardSnapshotProfiles
+}
+
+func (c *deviceConfig) BoardVendorSnapshotForceGenerate() bool {
+	return c.config.productVariables.BoardVendorSnapshotForceGenerate
+}
+
+func (c *deviceConfig) BoardVendorSnapshotForcedVersion() string {
+	return String(c.config.productVariables.BoardVendorSnapshotForcedVersion)
+}
+
+func (c *deviceConfig) VendorSnapshotMaxFileSizeMB() int {
+	return c.config.productVariables.BoardVendorSnapshotMaxFileSizeMB
+}
+
+func (c *deviceConfig) StrictSnapshotMaxFileSize() bool {
+	return c.config.productVariables.StrictSnapshotMaxFileSize
+}
+
+func (c *deviceConfig) VendorSnapshotDiffBase() string {
+	return String(c.config.productVariables.VendorSnapshotDiffBase)
+}
+
+func (c *deviceConfig) VendorSnapshotDiffTarget() string {
+	return String(c.config.productVariables.VendorSnapshotDiffTarget)
+}
+
+func (c *deviceConfig) SnapshotManifestRevision() string {
+	return String(c.config.productVariables.SnapshotManifestRevision)
+}
+
+func (c *deviceConfig) SnapshotPinnedManifestRevision() string {
+	return String(c.config.productVariables.SnapshotPinnedManifestRevision)
+}
+
+func (c *deviceConfig) SnapshotProductVariant() string {
+	return String(c.config.productVariables.SnapshotProductVariant)
+}
+
+func (c *deviceConfig) SnapshotProductVariantModules() []string {
+	return c.config.productVariables.SnapshotProductVariantModules
+}
+
 func createDirsMap(previous map[string]bool, dirs []string) (map[string]bool, error) {
 	var ret = make(map[string]bool)
 	for _, dir := range dirs {
@@ -1478,6 +1672,21 @@ func (c *deviceConfig) RecoverySnapshotDirsIncludedMap() map[string]bool {
 		c.config.productVariables.RecoverySnapshotDirsIncluded)
 }
 
+var productSnapshotDirsExcludedKey = NewOnceKey("ProductSnapshotDirsExcludedMap")
+
+func (c *deviceConfig) ProductSnapshotDirsExcludedMap() map[string]bool {
+	return c.createDirsMapOnce(productSnapshotDirsExcludedKey, nil,
+		c.config.productVariables.ProductSnapshotDirsExcluded)
+}
+
+var productSnapshotDirsIncludedKey = NewOnceKey("ProductSnapshotDirsIncludedMap")
+
+func (c *deviceConfig) ProductSnapshotDirsIncludedMap() map[string]bool {
+	excludedMap := c.ProductSnapshotDirsExcludedMap()
+	return c.createDirsMapOnce(productSnapshotDirsIncludedKey, excludedMap,
+		c.config.productVariables.ProductSnapshotDirsIncluded)
+}
+
 func (c *deviceConfig) ShippingApiLevel() ApiLevel {
 	if c.config.productVariables.ShippingApiLevel == nil {
 		return NoneApiLevel
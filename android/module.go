@@ -401,6 +401,7 @@ type ModuleContext interface {
 	InstallInRamdisk() bool
 	InstallInVendorRamdisk() bool
 	InstallInDebugRamdisk() bool
+	InstallInVendorDlkm() bool
 	InstallInRecovery() bool
 	InstallInRoot() bool
 	InstallBypassMake() bool
@@ -459,6 +460,7 @@ type Module interface {
 	InstallInRamdisk() bool
 	InstallInVendorRamdisk() bool
 	InstallInDebugRamdisk() bool
+	InstallInVendorDlkm() bool
 	InstallInRecovery() bool
 	InstallInRoot() bool
 	InstallBypassMake() bool
@@ -473,6 +475,7 @@ type Module interface {
 	InitRc() Paths
 	VintfFragments() Paths
 	NoticeFiles() Paths
+	EffectiveLicenseKinds() []string
 
 	AddProperties(props ...interface{})
 	GetProperties() []interface{}
@@ -1556,6 +1559,10 @@ func (m *ModuleBase) InstallInDebugRamdisk() bool {
 	return Bool(m.commonProperties.Debug_ramdisk)
 }
 
+func (m *ModuleBase) InstallInVendorDlkm() bool {
+	return false
+}
+
 func (m *ModuleBase) InstallInRecovery() bool {
 	return Bool(m.commonProperties.Recovery)
 }
@@ -1580,6 +1587,11 @@ func (m *ModuleBase) NoticeFiles() Paths {
 	return m.noticeFiles
 }
 
+// EffectiveLicenseKinds returns the license kinds of the licenses applying to this module.
+func (m *ModuleBase) EffectiveLicenseKinds() []string {
+	return m.commonProperties.Effective_license_kinds
+}
+
 func (m *ModuleBase) setImageVariation(variant string) {
 	m.commonProperties.ImageVariation = variant
 }
@@ -2610,6 +2622,10 @@ func (m *moduleContext) InstallInDebugRamdisk() bool {
 	return m.module.InstallInDebugRamdisk()
 }
 
+func (m *moduleContext) InstallInVendorDlkm() bool {
+	return m.module.InstallInVendorDlkm()
+}
+
 func (m *moduleContext) InstallInRecovery() bool {
 	return m.module.InstallInRecovery()
 }
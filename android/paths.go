@@ -108,6 +108,7 @@ type ModuleInstallPathContext interface {
 	InstallInRamdisk() bool
 	InstallInVendorRamdisk() bool
 	InstallInDebugRamdisk() bool
+	InstallInVendorDlkm() bool
 	InstallInRecovery() bool
 	InstallInRoot() bool
 	InstallBypassMake() bool
@@ -1660,6 +1661,16 @@ func PathForModuleInstall(ctx ModuleInstallPathContext, pathComponents ...string
 	return ret
 }
 
+// ModuleInstallPartition returns the partition directory the module is installed to, relative to
+// the product out directory, e.g. "vendor" or "odm".
+func ModuleInstallPartition(ctx ModuleInstallPathContext) string {
+	os := ctx.Os()
+	if forceOS, _ := ctx.InstallForceOS(); forceOS != nil {
+		os = *forceOS
+	}
+	return modulePartition(ctx, os)
+}
+
 func pathForInstall(ctx PathContext, os OsType, arch ArchType, partition string, debug bool,
 	pathComponents ...string) InstallPath {
 
@@ -1766,6 +1777,13 @@ func modulePartition(ctx ModuleInstallPathContext, os OsType) string {
 				// the layout of recovery partion is the same as that of system partition
 				partition = "recovery/root/system"
 			}
+		} else if ctx.InstallInVendorDlkm() {
+			// vendor_dlkm modules of an odm module install to odm_dlkm.
+			if ctx.DeviceSpecific() {
+				partition = ctx.DeviceConfig().OdmDlkmPath()
+			} else {
+				partition = ctx.DeviceConfig().VendorDlkmPath()
+			}
 		} else if ctx.SocSpecific() {
 			partition = ctx.DeviceConfig().VendorPath()
 		} else if ctx.DeviceSpecific() {
@@ -1930,6 +1948,7 @@ type testModuleInstallPathContext struct {
 	inRamdisk       bool
 	inVendorRamdisk bool
 	inDebugRamdisk  bool
+	inVendorDlkm    bool
 	inRecovery      bool
 	inRoot          bool
 	forceOS         *OsType
@@ -1966,6 +1985,10 @@ func (m testModuleInstallPathContext) InstallInDebugRamdisk() bool {
 	return m.inDebugRamdisk
 }
 
+func (m testModuleInstallPathContext) InstallInVendorDlkm() bool {
+	return m.inVendorDlkm
+}
+
 func (m testModuleInstallPathContext) InstallInRecovery() bool {
 	return m.inRecovery
 }
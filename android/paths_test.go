@@ -291,6 +291,38 @@ func TestPathForModuleInstall(t *testing.T) {
 			out:          "target/product/test_device/odm/bin/my_test",
 			partitionDir: "target/product/test_device/odm",
 		},
+		{
+			name: "vendor_dlkm binary",
+			ctx: &testModuleInstallPathContext{
+				baseModuleContext: baseModuleContext{
+					os:     deviceTarget.Os,
+					target: deviceTarget,
+					earlyModuleContext: earlyModuleContext{
+						kind: socSpecificModule,
+					},
+				},
+				inVendorDlkm: true,
+			},
+			in:           []string{"lib", "my_test"},
+			out:          "target/product/test_device/vendor_dlkm/lib/my_test",
+			partitionDir: "target/product/test_device/vendor_dlkm",
+		},
+		{
+			name: "odm_dlkm binary",
+			ctx: &testModuleInstallPathContext{
+				baseModuleContext: baseModuleContext{
+					os:     deviceTarget.Os,
+					target: deviceTarget,
+					earlyModuleContext: earlyModuleContext{
+						kind: deviceSpecificModule,
+					},
+				},
+				inVendorDlkm: true,
+			},
+			in:           []string{"lib", "my_test"},
+			out:          "target/product/test_device/odm_dlkm/lib/my_test",
+			partitionDir: "target/product/test_device/odm_dlkm",
+		},
 		{
 			name: "product binary",
 			ctx: &testModuleInstallPathContext{
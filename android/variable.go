@@ -182,7 +182,9 @@ type productVariables struct {
 	DeviceCurrentApiLevelForVendorModules *string  `json:",omitempty"`
 	DeviceSystemSdkVersions               []string `json:",omitempty"`
 
-	RecoverySnapshotVersion *string `json:",omitempty"`
+	RecoverySnapshotVersion      *string `json:",omitempty"`
+	RamdiskSnapshotVersion       *string `json:",omitempty"`
+	VendorRamdiskSnapshotVersion *string `json:",omitempty"`
 
 	DeviceSecondaryArch        *string  `json:",omitempty"`
 	DeviceSecondaryArchVariant *string  `json:",omitempty"`
@@ -266,10 +268,12 @@ type productVariables struct {
 	MemtagHeapAsyncIncludePaths []string `json:",omitempty"`
 	MemtagHeapSyncIncludePaths  []string `json:",omitempty"`
 
-	VendorPath    *string `json:",omitempty"`
-	OdmPath       *string `json:",omitempty"`
-	ProductPath   *string `json:",omitempty"`
-	SystemExtPath *string `json:",omitempty"`
+	VendorPath     *string `json:",omitempty"`
+	OdmPath        *string `json:",omitempty"`
+	VendorDlkmPath *string `json:",omitempty"`
+	OdmDlkmPath    *string `json:",omitempty"`
+	ProductPath    *string `json:",omitempty"`
+	SystemExtPath  *string `json:",omitempty"`
 
 	ClangTidy  *bool   `json:",omitempty"`
 	TidyChecks *string `json:",omitempty"`
@@ -321,6 +325,62 @@ type productVariables struct {
 	VendorSnapshotDirsExcluded   []string `json:",omitempty"`
 	RecoverySnapshotDirsExcluded []string `json:",omitempty"`
 	RecoverySnapshotDirsIncluded []string `json:",omitempty"`
+	ProductSnapshotDirsExcluded  []string `json:",omitempty"`
+	ProductSnapshotDirsIncluded  []string `json:",omitempty"`
+
+	StrictSnapshotVersions      bool `json:",omitempty"`
+	KeepEmptySnapshotArchDirs   bool `json:",omitempty"`
+	BuildSnapshotSymbols        bool `json:",omitempty"`
+	BuildSnapshotProfileData    bool `json:",omitempty"`
+	SortSnapshotJsonKeys        bool `json:",omitempty"`
+	BuildSnapshotKernelModules  bool `json:",omitempty"`
+	PerImageSnapshotNoticeDirs  bool `json:",omitempty"`
+	PerImageSnapshotIncludeDirs bool `json:",omitempty"`
+	BuildSnapshotSbom           bool `json:",omitempty"`
+	InlineSnapshotUnstripped    bool `json:",omitempty"`
+	SnapshotZipRootArchDir      bool `json:",omitempty"`
+	BuildSnapshotProvenance     bool `json:",omitempty"`
+	BuildSnapshotArchiveMembers bool `json:",omitempty"`
+	SnapshotPrerelease          bool `json:",omitempty"`
+	BuildSnapshotLlndk          bool `json:",omitempty"`
+	SnapshotNonInstallableLibs  bool `json:",omitempty"`
+	ValidateSnapshotJson        bool `json:",omitempty"`
+	SplitSnapshotZips           bool `json:",omitempty"`
+	ValidateSnapshotElfMachine  bool `json:",omitempty"`
+	BuildSnapshotBlueprints     bool `json:",omitempty"`
+	BuildSnapshotAfdoProfiles   bool `json:",omitempty"`
+	BuildSnapshotTocs           bool `json:",omitempty"`
+
+	StrictSnapshotExportedDirs bool `json:",omitempty"`
+	StrictSnapshotDeps         bool `json:",omitempty"`
+	StrictSnapshotCfiDiag      bool `json:",omitempty"`
+	StrictSnapshotAllowlist    bool `json:",omitempty"`
+
+	BoardVendorSnapshotHeadersOnly   bool     `json:",omitempty"`
+	BoardVendorSnapshotDirFilter     []string `json:",omitempty"`
+	BoardVendorSnapshotLinkerConfigs []string `json:",omitempty"`
+	BoardVendorSnapshotArches        []string `json:",omitempty"`
+	BoardVendorSnapshotAllowlist     *string  `json:",omitempty"`
+
+	SnapshotZipPrefix *string `json:",omitempty"`
+
+	BoardSnapshotProfiles []string `json:",omitempty"`
+
+	// For testing only: generate the vendor snapshot even if BOARD_VNDK_VERSION isn't current.
+	BoardVendorSnapshotForceGenerate bool    `json:",omitempty"`
+	BoardVendorSnapshotForcedVersion *string `json:",omitempty"`
+
+	BoardVendorSnapshotMaxFileSizeMB int  `json:",omitempty"`
+	StrictSnapshotMaxFileSize        bool `json:",omitempty"`
+
+	VendorSnapshotDiffBase   *string `json:",omitempty"`
+	VendorSnapshotDiffTarget *string `json:",omitempty"`
+
+	SnapshotManifestRevision       *string `json:",omitempty"`
+	SnapshotPinnedManifestRevision *string `json:",omitempty"`
+
+	SnapshotProductVariant        *string  `json:",omitempty"`
+	SnapshotProductVariantModules []string `json:",omitempty"`
 
 	BoardVendorSepolicyDirs      []string `json:",omitempty"`
 	BoardOdmSepolicyDirs         []string `json:",omitempty"`
@@ -54,6 +54,10 @@ type BinaryLinkerProperties struct {
 
 	// Inject boringssl hash into the shared library.  This is only intended for use by external/boringssl.
 	Inject_bssl_hash *bool `android:"arch_variant"`
+
+	// List of data files the binary reads at runtime, e.g. from filegroups. They are captured to
+	// the binary/data/ subtree of snapshots along with the binary.
+	Snapshot_data []string `android:"path,arch_variant"`
 }
 
 func init() {
@@ -110,6 +114,9 @@ type binaryDecorator struct {
 	// Action command lines to run directly after the binary is installed. For example,
 	// may be used to symlink runtime dependencies (such as bionic) alongside installation.
 	postInstallCmds []string
+
+	// Data files captured to snapshots along with the binary, from snapshot_data
+	snapshotData android.Paths
 }
 
 var _ linker = (*binaryDecorator)(nil)
@@ -343,6 +350,8 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 	outputFile := android.PathForModuleOut(ctx, fileName)
 	ret := outputFile
 
+	binary.snapshotData = android.PathsForModuleSrc(ctx, binary.Properties.Snapshot_data)
+
 	var linkerDeps android.Paths
 
 	// Add flags from linker flags file.
@@ -332,8 +332,15 @@ type BaseProperties struct {
 
 	// Used by vendor snapshot to record dependencies from snapshot modules.
 	SnapshotSharedLibs  []string `blueprint:"mutated"`
+	SnapshotStaticLibs  []string `blueprint:"mutated"`
+	SnapshotHeaderLibs  []string `blueprint:"mutated"`
+	SnapshotNeededLibs  []string `blueprint:"mutated"`
 	SnapshotRuntimeLibs []string `blueprint:"mutated"`
 
+	// Used by vendor snapshot to record the sdk_version, which sdkMutator clears for the variants
+	// that can't use the SDK, including the vendor variants.
+	SnapshotSdkVersion string `blueprint:"mutated"`
+
 	Installable *bool
 
 	// Set by factories of module types that can only be referenced from variants compiled against
@@ -360,6 +367,44 @@ type BaseProperties struct {
 	// framework module from the recovery snapshot.
 	Exclude_from_recovery_snapshot *bool
 
+	// Normally Soong uses the directory structure to decide which modules
+	// should be included (framework) or excluded (non-framework) from the
+	// different snapshots (vendor, recovery, etc.), but this property
+	// allows a partner to exclude a module normally thought of as a
+	// framework module from the product snapshot.
+	Exclude_from_product_snapshot *bool
+
+	// Whether this module is excluded from the snapshots of the arch it is set for, e.g.
+	// arch: { arm: { exclude_from_snapshot: true } } drops the arm variant of this module from
+	// snapshots while other arches are still captured.
+	Exclude_from_snapshot *bool `android:"arch_variant"`
+
+	// Suffix, including the extension, replacing the extension of the library file name when
+	// this module is captured to a snapshot, e.g. "_vendor.a" captures libfoo.a as
+	// libfoo_vendor.a. This is for consumers of the snapshot expecting non-standard names.
+	Snapshot_suffix *string
+
+	// Whether this module is experimental. Experimental modules are captured to the
+	// experimental/ subtree of snapshots, so that consumers can opt in to them separately.
+	Snapshot_experimental *bool
+
+	// List of "key=value" pairs copied verbatim to the metadata of this module in snapshots, e.g.
+	// tracking IDs or review status. The values aren't interpreted by the build system.
+	Snapshot_metadata []string
+
+	// Whether the exported headers of this library are captured to snapshots. Defaults to true.
+	// If false, only the library itself is captured, with no exported include directories, e.g.
+	// for libraries consumers only load at runtime.
+	Snapshot_export_headers *bool
+
+	// List of prebuilt kernel modules (.ko files) paired with this module. They are captured to
+	// the kernel-modules/ subtree of snapshots along with this module, if enabled.
+	Snapshot_kernel_modules []string `android:"path"`
+
+	// List of libraries this module loads at runtime with dlopen, e.g. plugins, which aren't listed
+	// in shared_libs. They are recorded in snapshots, and are expected to be captured as well.
+	Snapshot_dlopen_libs []string `android:"arch_variant"`
+
 	// List of APEXes that this module has private access to for testing purpose. The module
 	// can depend on libraries that are not exported by the APEXes and use private symbols
 	// from the exported libraries.
@@ -397,6 +442,13 @@ type VendorProperties struct {
 	// It may not be used for VNDK modules.
 	Odm_available *bool
 
+	// Whether the vendor variant of this module is for the vendor_dlkm partition, which holds
+	// the userspace libraries and binaries of vendor kernel modules. Such vendor variants are
+	// captured to the vendor_dlkm snapshot, instead of the vendor snapshot.
+	//
+	// It may not be used for VNDK modules.
+	Vendor_dlkm_available *bool
+
 	// whether this module should be allowed to be directly depended by other
 	// modules with `product_specific: true` or `product_available: true`.
 	// If set to true, an additional product variant will be built separately
@@ -825,6 +877,12 @@ type Module struct {
 	apexSdkVersion android.ApiLevel
 
 	hideApexVariantFromMake bool
+
+	// Paths of snapshot_kernel_modules
+	snapshotKernelModules android.Paths
+
+	// The partition this module is installed to, e.g. "vendor" or "odm".
+	installPartition string
 }
 
 func (c *Module) SetPreventInstall() {
@@ -1282,6 +1340,38 @@ func (c *Module) ExcludeFromRecoverySnapshot() bool {
 	return Bool(c.Properties.Exclude_from_recovery_snapshot)
 }
 
+func (c *Module) ExcludeFromProductSnapshot() bool {
+	return Bool(c.Properties.Exclude_from_product_snapshot)
+}
+
+func (c *Module) ExcludeFromArchSnapshot() bool {
+	return Bool(c.Properties.Exclude_from_snapshot)
+}
+
+func (c *Module) SnapshotSuffix() string {
+	return String(c.Properties.Snapshot_suffix)
+}
+
+func (c *Module) SnapshotExperimental() bool {
+	return Bool(c.Properties.Snapshot_experimental)
+}
+
+func (c *Module) SnapshotSdkVersion() string {
+	return c.Properties.SnapshotSdkVersion
+}
+
+func (c *Module) SnapshotMetadata() []string {
+	return c.Properties.Snapshot_metadata
+}
+
+func (c *Module) SnapshotExportHeaders() bool {
+	return proptools.BoolDefault(c.Properties.Snapshot_export_headers, true)
+}
+
+func (c *Module) SnapshotDlopenLibs() []string {
+	return c.Properties.Snapshot_dlopen_libs
+}
+
 func isBionic(name string) bool {
 	switch name {
 	case "libc", "libm", "libdl", "libdl_android", "linker", "linkerconfig":
@@ -1686,6 +1776,8 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	}
 	ctx.ctx = ctx
 
+	c.installPartition = android.ModuleInstallPartition(ctx)
+
 	deps := c.depsToPaths(ctx)
 	if ctx.Failed() {
 		return
@@ -1787,6 +1879,8 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 			// Note: this is still non-installable
 		}
 
+		c.snapshotKernelModules = android.PathsForModuleSrc(ctx, c.Properties.Snapshot_kernel_modules)
+
 		// glob exported headers for snapshot, if BOARD_VNDK_VERSION is current or
 		// RECOVERY_SNAPSHOT_VERSION is current.
 		if i, ok := c.linker.(snapshotLibraryInterface); ok {
@@ -2032,6 +2126,12 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 				snapshotModule = ctx.AddVariationDependencies(nil, nil, "vendor_snapshot")
 			} else if recoverySnapshotVersion := actx.DeviceConfig().RecoverySnapshotVersion(); recoverySnapshotVersion != "current" && recoverySnapshotVersion != "" && c.InRecovery() {
 				snapshotModule = ctx.AddVariationDependencies(nil, nil, "recovery_snapshot")
+			} else if c.InProduct() && c.VndkVersion() == actx.DeviceConfig().ProductVndkVersion() && actx.OtherModuleExists("product_snapshot") {
+				snapshotModule = ctx.AddVariationDependencies(nil, nil, "product_snapshot")
+			} else if ramdiskSnapshotVersion := actx.DeviceConfig().RamdiskSnapshotVersion(); ramdiskSnapshotVersion != "current" && ramdiskSnapshotVersion != "" && c.InRamdisk() {
+				snapshotModule = ctx.AddVariationDependencies(nil, nil, "ramdisk_snapshot")
+			} else if vendorRamdiskSnapshotVersion := actx.DeviceConfig().VendorRamdiskSnapshotVersion(); vendorRamdiskSnapshotVersion != "current" && vendorRamdiskSnapshotVersion != "" && c.InVendorRamdisk() {
+				snapshotModule = ctx.AddVariationDependencies(nil, nil, "vendor_ramdisk_snapshot")
 			}
 			if len(snapshotModule) > 0 {
 				snapshot := ctx.OtherModuleProvider(snapshotModule[0], SnapshotInfoProvider).(SnapshotInfo)
@@ -2072,13 +2172,18 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 		// The caller can then know to add the variantLibs dependencies differently from the
 		// nonvariantLibs
 
+		// Ramdisk and vendor_ramdisk variants only link against their snapshots, as recovery
+		// variants do, if the snapshot of their image is used.
+		usesRamdiskSnapshot := (c.InRamdisk() && ramdiskSnapshotImageSingleton.isUsingSnapshot(ctx.DeviceConfig())) ||
+			(c.InVendorRamdisk() && vendorRamdiskSnapshotImageSingleton.isUsingSnapshot(ctx.DeviceConfig()))
+
 		rewriteLibs := func(list []string) (nonvariantLibs []string, variantLibs []string) {
 			variantLibs = []string{}
 			nonvariantLibs = []string{}
 			for _, entry := range list {
 				// strip #version suffix out
 				name, _ := StubsLibNameAndVersion(entry)
-				if c.InRecovery() {
+				if c.InRecovery() || usesRamdiskSnapshot {
 					nonvariantLibs = append(nonvariantLibs, rewriteSnapshotLib(entry, getSnapshot().SharedLibs))
 				} else if ctx.useSdk() && inList(name, *getNDKKnownLibs(ctx.Config())) {
 					variantLibs = append(variantLibs, name+ndkLibrarySuffix)
@@ -2099,6 +2204,14 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 		for idx, lib := range deps.RuntimeLibs {
 			deps.RuntimeLibs[idx] = rewriteSnapshotLib(lib, getSnapshot().SharedLibs)
 		}
+
+		for idx, lib := range deps.DataLibs {
+			deps.DataLibs[idx] = rewriteSnapshotLib(lib, getSnapshot().SharedLibs)
+		}
+
+		for idx, obj := range deps.ObjFiles {
+			deps.ObjFiles[idx] = rewriteSnapshotLib(obj, getSnapshot().Objects)
+		}
 	}
 
 	for _, lib := range deps.HeaderLibs {
@@ -2692,6 +2805,8 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 
 				linkFile = android.OptionalPathForPath(sharedLibraryInfo.SharedLibrary)
 				depFile = sharedLibraryInfo.TableOfContents
+				// Record the file name, which becomes a DT_NEEDED entry, for snapshots.
+				c.Properties.SnapshotNeededLibs = append(c.Properties.SnapshotNeededLibs, sharedLibraryInfo.SharedLibrary.Base())
 
 				ptr = &depPaths.SharedLibs
 				switch libDepTag.Order {
@@ -2823,6 +2938,8 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 			case libDepTag.header():
 				c.Properties.AndroidMkHeaderLibs = append(
 					c.Properties.AndroidMkHeaderLibs, makeLibName)
+				// Record BaseLibName for snapshots.
+				c.Properties.SnapshotHeaderLibs = append(c.Properties.SnapshotHeaderLibs, BaseLibName(depName))
 			case libDepTag.shared():
 				if lib := moduleLibraryInterface(dep); lib != nil {
 					if lib.buildStubs() && dep.(android.ApexModule).InAnyApex() {
@@ -2840,8 +2957,8 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				// they merely serve as Make dependencies and do not affect this lib itself.
 				c.Properties.AndroidMkSharedLibs = append(
 					c.Properties.AndroidMkSharedLibs, makeLibName)
-				// Record baseLibName for snapshots.
-				c.Properties.SnapshotSharedLibs = append(c.Properties.SnapshotSharedLibs, baseLibName(depName))
+				// Record BaseLibName for snapshots.
+				c.Properties.SnapshotSharedLibs = append(c.Properties.SnapshotSharedLibs, BaseLibName(depName))
 			case libDepTag.static():
 				if libDepTag.wholeStatic {
 					c.Properties.AndroidMkWholeStaticLibs = append(
@@ -2849,6 +2966,8 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				} else {
 					c.Properties.AndroidMkStaticLibs = append(
 						c.Properties.AndroidMkStaticLibs, makeLibName)
+					// Record BaseLibName for snapshots.
+					c.Properties.SnapshotStaticLibs = append(c.Properties.SnapshotStaticLibs, BaseLibName(depName))
 				}
 			}
 		} else if !c.IsStubs() {
@@ -2858,8 +2977,8 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 			case runtimeDepTag:
 				c.Properties.AndroidMkRuntimeLibs = append(
 					c.Properties.AndroidMkRuntimeLibs, MakeLibName(ctx, c, ccDep, depName)+libDepTag.makeSuffix)
-				// Record baseLibName for snapshots.
-				c.Properties.SnapshotRuntimeLibs = append(c.Properties.SnapshotRuntimeLibs, baseLibName(depName))
+				// Record BaseLibName for snapshots.
+				c.Properties.SnapshotRuntimeLibs = append(c.Properties.SnapshotRuntimeLibs, BaseLibName(depName))
 			case objDepTag:
 				depPaths.Objs.objFiles = append(depPaths.Objs.objFiles, linkFile.Path())
 			case CrtBeginDepTag:
@@ -2927,8 +3046,8 @@ func orderStaticModuleDeps(staticDeps []StaticLibraryInfo, sharedDeps []SharedLi
 	return orderedStaticPaths, transitiveStaticLibs
 }
 
-// baseLibName trims known prefixes and suffixes
-func baseLibName(depName string) string {
+// BaseLibName trims known prefixes and suffixes
+func BaseLibName(depName string) string {
 	libName := strings.TrimSuffix(depName, llndkLibrarySuffix)
 	libName = strings.TrimSuffix(libName, vendorPublicLibrarySuffix)
 	libName = android.RemoveOptionalPrebuiltPrefix(libName)
@@ -2936,7 +3055,7 @@ func baseLibName(depName string) string {
 }
 
 func MakeLibName(ctx android.ModuleContext, c LinkableInterface, ccDep LinkableInterface, depName string) string {
-	libName := baseLibName(depName)
+	libName := BaseLibName(depName)
 	ccDepModule, _ := ccDep.(*Module)
 	isLLndk := ccDepModule != nil && ccDepModule.IsLlndk()
 	nonSystemVariantsExist := ccDep.HasNonSystemVariants() || isLLndk
@@ -2998,6 +3117,14 @@ func (c *Module) InstallInVendorRamdisk() bool {
 	return c.InVendorRamdisk()
 }
 
+func (c *Module) InstallPartition() string {
+	return c.installPartition
+}
+
+func (c *Module) InstallInVendorDlkm() bool {
+	return c.InVendor() && c.VendorVariantToVendorDlkm()
+}
+
 func (c *Module) InstallInRecovery() bool {
 	return c.InRecovery()
 }
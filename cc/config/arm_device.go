@@ -300,6 +300,16 @@ func (t *toolchainArm) ClangLldflags() string {
 	return t.lldflags // TODO: handle V8 cases
 }
 
+// ArmFloatAbi returns the float ABI used for the given arm arch variant, e.g. "softfp".
+func ArmFloatAbi(archVariant string) string {
+	for _, flag := range armClangArchVariantCflags[archVariant] {
+		if strings.HasPrefix(flag, "-mfloat-abi=") {
+			return strings.TrimPrefix(flag, "-mfloat-abi=")
+		}
+	}
+	return ""
+}
+
 func (t *toolchainArm) ClangInstructionSetFlags(isa string) (string, error) {
 	switch isa {
 	case "arm":
@@ -109,6 +109,11 @@ func (c *Module) VendorVariantToOdm() bool {
 	return Bool(c.VendorProperties.Odm_available)
 }
 
+// Returns true when the vendor variant of this module is for the vendor_dlkm partition.
+func (c *Module) VendorVariantToVendorDlkm() bool {
+	return Bool(c.VendorProperties.Vendor_dlkm_available)
+}
+
 // Returns true when this module is configured to have core and product variants.
 func (c *Module) HasProductVariant() bool {
 	return Bool(c.VendorProperties.Product_available)
@@ -213,6 +218,10 @@ type ImageMutatableModule interface {
 	// OdmAvailable returns true if this module is available on the odm image.
 	OdmAvailable() bool
 
+	// VendorDlkmAvailable returns true if the vendor variant of this module is for the
+	// vendor_dlkm image.
+	VendorDlkmAvailable() bool
+
 	// ProductAvailable returns true if this module is available on the product image.
 	ProductAvailable() bool
 
@@ -320,6 +329,10 @@ func (m *Module) OdmAvailable() bool {
 	return Bool(m.VendorProperties.Odm_available)
 }
 
+func (m *Module) VendorDlkmAvailable() bool {
+	return Bool(m.VendorProperties.Vendor_dlkm_available)
+}
+
 func (m *Module) ProductAvailable() bool {
 	return Bool(m.VendorProperties.Product_available)
 }
@@ -405,6 +418,17 @@ func MutateImage(mctx android.BaseModuleContext, m ImageMutatableModule) {
 		}
 	}
 
+	if m.VendorDlkmAvailable() {
+		if !vendorSpecific && !m.VendorAvailable() && !m.OdmAvailable() {
+			mctx.PropertyErrorf("vendor_dlkm_available",
+				"requires a vendor variant. Please use `vendor: true`, `device_specific: true`, `vendor_available: true`, or `odm_available: true`")
+		}
+		if m.IsVndk() {
+			mctx.PropertyErrorf("vendor_dlkm_available",
+				"may not be used for VNDK modules")
+		}
+	}
+
 	if m.ProductAvailable() {
 		if productSpecific {
 			mctx.PropertyErrorf("product_available",
@@ -484,6 +508,12 @@ func MutateImage(mctx android.BaseModuleContext, m ImageMutatableModule) {
 		// PRODUCT_EXTRA_VNDK_VERSIONS.
 		if m.InstallInRecovery() {
 			recoveryVariantNeeded = true
+		} else if m.AndroidModuleBase().InstallInRamdisk() {
+			ramdiskVariantNeeded = true
+		} else if m.AndroidModuleBase().InstallInVendorRamdisk() {
+			vendorRamdiskVariantNeeded = true
+		} else if productSpecific {
+			productVariants = append(productVariants, m.SnapshotVersion(mctx))
 		} else {
 			vendorVariants = append(vendorVariants, m.SnapshotVersion(mctx))
 		}
@@ -123,6 +123,11 @@ type LibraryProperties struct {
 
 	// If this is a vendor public library, properties to describe the vendor public library stubs.
 	Vendor_public_library vendorPublicLibraryProperties
+
+	// List of glob patterns of exported headers which aren't captured to snapshots, e.g.
+	// "**/internal/*.h". Patterns containing a "/" are relative to the directory of this module,
+	// and other patterns match the file names of headers in any directory, e.g. "*_internal.h".
+	Snapshot_exclude_headers []string
 }
 
 // StaticProperties is a properties stanza to affect only attributes of the "static" variants of a
@@ -514,6 +519,14 @@ type libraryDecorator struct {
 
 	versionScriptPath android.OptionalPath
 
+	// Location of stubs.symbol_file for stubs and implementation variants
+	stubsSymbolFile android.OptionalPath
+
+	// Location of llndk.symbol_file, and the API level the LLNDK stubs are generated for, for
+	// the vendor variants of LLNDK libraries
+	llndkSymbolFile android.OptionalPath
+	llndkVersion    string
+
 	postInstallCmds []string
 
 	// If useCoreVariant is true, the vendor variant of a VNDK library is
@@ -594,20 +607,17 @@ func (handler *staticLibraryBazelHandler) generateBazelBuildActions(ctx android.
 	return ok
 }
 
-// collectHeadersForSnapshot collects all exported headers from library.
-// It globs header files in the source tree for exported include directories,
-// and tracks generated header files separately.
-//
-// This is to be called from GenerateAndroidBuildActions, and then collected
-// header files can be retrieved by snapshotHeaders().
-func (l *libraryDecorator) collectHeadersForSnapshot(ctx android.ModuleContext) {
+// GlobHeadersForSnapshot globs header files in the source tree under the given exported include
+// directories. Directories of generated headers are skipped, as generated headers can't be globbed
+// and have to be collected separately.
+func GlobHeadersForSnapshot(ctx android.ModuleContext, dirs android.Paths) android.Paths {
 	ret := android.Paths{}
 
 	// Headers in the source tree should be globbed. On the contrast, generated headers
 	// can't be globbed, and they should be manually collected.
 	// So, we first filter out intermediate directories (which contains generated headers)
 	// from exported directories, and then glob headers under remaining directories.
-	for _, path := range append(android.CopyOfPaths(l.flagExporter.dirs), l.flagExporter.systemDirs...) {
+	for _, path := range dirs {
 		dir := path.String()
 		// Skip if dir is for generated headers
 		if strings.HasPrefix(dir, android.PathForOutput(ctx).String()) {
@@ -623,7 +633,7 @@ func (l *libraryDecorator) collectHeadersForSnapshot(ctx android.ModuleContext)
 				glob, err := ctx.GlobWithDeps("external/eigen/"+subdir+"/**/*", nil)
 				if err != nil {
 					ctx.ModuleErrorf("glob failed: %#v", err)
-					return
+					return nil
 				}
 				for _, header := range glob {
 					if strings.HasSuffix(header, "/") {
@@ -641,7 +651,7 @@ func (l *libraryDecorator) collectHeadersForSnapshot(ctx android.ModuleContext)
 		glob, err := ctx.GlobWithDeps(dir+"/**/*", nil)
 		if err != nil {
 			ctx.ModuleErrorf("glob failed: %#v", err)
-			return
+			return nil
 		}
 		isLibcxx := strings.HasPrefix(dir, "external/libcxx/include")
 		for _, header := range glob {
@@ -668,6 +678,21 @@ func (l *libraryDecorator) collectHeadersForSnapshot(ctx android.ModuleContext)
 		}
 	}
 
+	return ret
+}
+
+// collectHeadersForSnapshot collects all exported headers from library.
+// It globs header files in the source tree for exported include directories,
+// and tracks generated header files separately.
+//
+// This is to be called from GenerateAndroidBuildActions, and then collected
+// header files can be retrieved by snapshotHeaders().
+func (l *libraryDecorator) collectHeadersForSnapshot(ctx android.ModuleContext) {
+	ret := GlobHeadersForSnapshot(ctx, append(android.CopyOfPaths(l.flagExporter.dirs), l.flagExporter.systemDirs...))
+	if ctx.Failed() {
+		return
+	}
+
 	// Collect generated headers
 	for _, header := range append(android.CopyOfPaths(l.flagExporter.headers), l.flagExporter.deps...) {
 		// TODO(b/148123511): remove exportedDeps after cleaning up genrule
@@ -677,9 +702,45 @@ func (l *libraryDecorator) collectHeadersForSnapshot(ctx android.ModuleContext)
 		ret = append(ret, header)
 	}
 
+	if excludes := l.Properties.Snapshot_exclude_headers; len(excludes) > 0 {
+		filtered := android.Paths{}
+		for _, header := range ret {
+			excluded, err := snapshotHeaderExcluded(header, ctx.ModuleDir(), excludes)
+			if err != nil {
+				ctx.PropertyErrorf("snapshot_exclude_headers", "%s", err)
+				return
+			}
+			if !excluded {
+				filtered = append(filtered, header)
+			}
+		}
+		ret = filtered
+	}
+
 	l.collectedSnapshotHeaders = ret
 }
 
+// snapshotHeaderExcluded returns true if header matches any of the snapshot_exclude_headers
+// patterns of a module in moduleDir.
+func snapshotHeaderExcluded(header android.Path, moduleDir string, patterns []string) (bool, error) {
+	for _, pattern := range patterns {
+		var match bool
+		var err error
+		if strings.Contains(pattern, "/") {
+			match, err = pathtools.Match(filepath.Join(moduleDir, pattern), header.String())
+		} else {
+			match, err = filepath.Match(pattern, header.Base())
+		}
+		if err != nil {
+			return false, err
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // This returns all exported header files, both generated ones and headers from source tree.
 // collectHeadersForSnapshot() must be called before calling this.
 func (l *libraryDecorator) snapshotHeaders() android.Paths {
@@ -828,6 +889,9 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 		if !Bool(library.Properties.Llndk.Unversioned) {
 			library.versionScriptPath = android.OptionalPathForPath(versionScript)
 		}
+		library.llndkSymbolFile = android.OptionalPathForPath(
+			android.PathForModuleSrc(ctx, String(library.Properties.Llndk.Symbol_file)))
+		library.llndkVersion = vndkVer
 		return objs
 	}
 	if ctx.IsVendorPublicLibrary() {
@@ -845,9 +909,19 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 		}
 		objs, versionScript := compileStubLibrary(ctx, flags, String(library.Properties.Stubs.Symbol_file), library.MutatedProperties.StubsVersion, "--apex")
 		library.versionScriptPath = android.OptionalPathForPath(versionScript)
+		if symbolFile != "" {
+			library.stubsSymbolFile = android.OptionalPathForPath(android.PathForModuleSrc(ctx, symbolFile))
+		}
 		return objs
 	}
 
+	// The implementation variants keep stubs.symbol_file as well, as it defines the stable API of
+	// the library.
+	if library.hasStubsVariants() && library.Properties.Stubs.Symbol_file != nil {
+		library.stubsSymbolFile = android.OptionalPathForPath(
+			android.PathForModuleSrc(ctx, String(library.Properties.Stubs.Symbol_file)))
+	}
+
 	if !library.buildShared() && !library.buildStatic() {
 		if len(library.baseCompiler.Properties.Srcs) > 0 {
 			ctx.PropertyErrorf("srcs", "cc_library_headers must not have any srcs")
@@ -85,15 +85,174 @@ type Snapshottable interface {
 	// ExcludeFromRecoverySnapshot returns true if this module should be otherwise excluded from the recovery snapshot.
 	ExcludeFromRecoverySnapshot() bool
 
+	// ExcludeFromProductSnapshot returns true if this module should be otherwise excluded from the product snapshot.
+	ExcludeFromProductSnapshot() bool
+
+	// ExcludeFromArchSnapshot returns true if this arch variant of the module should be excluded from
+	// all snapshots.
+	ExcludeFromArchSnapshot() bool
+
 	// SnapshotLibrary returns true if this module is a snapshot library.
 	IsSnapshotLibrary() bool
 
+	// SnapshotRust returns true if this module is built from Rust, e.g. a rust_ffi library
+	// exposing a C ABI.
+	SnapshotRust() bool
+
 	// SnapshotRuntimeLibs returns a list of libraries needed by this module at runtime but which aren't build dependencies.
 	SnapshotRuntimeLibs() []string
 
 	// SnapshotSharedLibs returns the list of shared library dependencies for this module.
 	SnapshotSharedLibs() []string
 
+	// SnapshotStaticLibs returns the list of static library dependencies for this module, excluding
+	// whole static libraries.
+	SnapshotStaticLibs() []string
+
+	// SnapshotHeaderLibs returns the list of header library dependencies for this module.
+	SnapshotHeaderLibs() []string
+
+	// SnapshotNeededLibs returns the sorted file names of the shared libraries this module is linked
+	// with, which are its DT_NEEDED entries.
+	SnapshotNeededLibs() []string
+
+	// SnapshotSuffix returns the suffix replacing the extension of the file name of this module in
+	// snapshots, or an empty string if the file name is kept as is.
+	SnapshotSuffix() string
+
+	// SnapshotExperimental returns true if this module should be captured to the experimental
+	// subtree of snapshots.
+	SnapshotExperimental() bool
+
+	// SnapshotSdkVersion returns the sdk_version property of this module, which is kept for the
+	// variants captured to snapshots even though they are not built against the SDK.
+	SnapshotSdkVersion() string
+
+	// SnapshotMetadata returns the "key=value" pairs attached to this module in snapshots.
+	SnapshotMetadata() []string
+
+	// SnapshotExportHeaders returns true if the exported headers of this module should be captured
+	// to snapshots.
+	SnapshotExportHeaders() bool
+
+	// SnapshotDlopenLibs returns the libraries this module loads at runtime with dlopen.
+	SnapshotDlopenLibs() []string
+
+	// SnapshotAbiRelevantFlags returns the compiler flags used for this module which affect its ABI,
+	// including all cflags specific to its image variant.
+	SnapshotAbiRelevantFlags() []string
+
+	// SnapshotCppFlags returns the C++ specific compiler flags used for this module which affect
+	// its ABI.
+	SnapshotCppFlags() []string
+
+	// SnapshotConlyFlags returns the C specific compiler flags used for this module which affect
+	// its ABI.
+	SnapshotConlyFlags() []string
+
+	// SnapshotVisibility returns the effective -fvisibility setting of this module, or an empty
+	// string if the compiler default is used.
+	SnapshotVisibility() string
+
+	// SnapshotPic returns true if this module is compiled as position-independent code.
+	SnapshotPic() bool
+
+	// SnapshotPie returns true if this module is linked as a position-independent executable.
+	SnapshotPie() bool
+
+	// SnapshotCfiAssemblySupport returns true if CFI is supported for the assembly sources of this
+	// module, with cfi_assembly_support.
+	SnapshotCfiAssemblySupport() bool
+
+	// SnapshotCfiExportsMap returns the CFI exports map this module was linked with, for cross-DSO
+	// CFI of shared libraries with version scripts.
+	SnapshotCfiExportsMap() android.OptionalPath
+
+	// SnapshotCfiDiag returns true if this module is built with CFI in the diagnostic mode.
+	SnapshotCfiDiag() bool
+
+	// SnapshotSanitizers returns the sanitizers this module is compiled with, as passed to
+	// -fsanitize=, e.g. "cfi".
+	SnapshotSanitizers() []string
+
+	// SnapshotDiagSanitizers returns the sanitizers this module runs in the diagnostic mode.
+	SnapshotDiagSanitizers() []string
+
+	// SnapshotSanitizeBlocklist returns the sanitizer blocklist this module is compiled with, if
+	// any.
+	SnapshotSanitizeBlocklist() android.OptionalPath
+
+	// SnapshotVersionScript returns the version script this shared library was linked with, which
+	// shapes its exported symbol table.
+	SnapshotVersionScript() android.OptionalPath
+
+	// SnapshotLinkFlags returns the linker flags used for this module which affect the runtime
+	// behavior of the linked library.
+	SnapshotLinkFlags() []string
+
+	// SnapshotRunpaths returns the runpaths set with the ldflags of this module.
+	SnapshotRunpaths() []string
+
+	// SnapshotData returns the data files of this binary captured to snapshots along with it.
+	SnapshotData() android.Paths
+
+	// SnapshotAvailableImages returns the images this module has variants for, e.g. "core",
+	// "vendor" or "recovery".
+	SnapshotAvailableImages() []string
+
+	// SnapshotNocrt returns true if this module is linked without crtbegin and crtend, with nocrt.
+	SnapshotNocrt() bool
+
+	// SnapshotNoLibcrt returns true if this module is linked without libclang_rt.builtins, with
+	// no_libcrt.
+	SnapshotNoLibcrt() bool
+
+	// SnapshotVndkExtends returns the name of the VNDK library this module extends, if this module
+	// is a VNDK extension.
+	SnapshotVndkExtends() string
+
+	// SnapshotKernelModules returns the prebuilt kernel modules paired with this module.
+	SnapshotKernelModules() android.Paths
+
+	// SnapshotCrt returns true if this module is a CRT object, e.g. crtbegin_so.
+	SnapshotCrt() bool
+
+	// SnapshotVendorPublic returns true if this module is a vendor public library, which apps
+	// can dlopen.
+	SnapshotVendorPublic() bool
+
+	// SnapshotStubsVersion returns the version of this module if it is a stubs variant of a
+	// library with stubs, or an empty string otherwise.
+	SnapshotStubsVersion() string
+
+	// SnapshotStubsSymbolFile returns the symbol file the stubs variants of this library are
+	// generated from.
+	SnapshotStubsSymbolFile() android.OptionalPath
+
+	// SnapshotLlndkSymbolFile returns the symbol file the LLNDK stubs of the vendor variant of an
+	// LLNDK library are generated from.
+	SnapshotLlndkSymbolFile() android.OptionalPath
+
+	// SnapshotLlndkVersion returns the API level the LLNDK stubs of the vendor variant of an LLNDK
+	// library are generated for, e.g. "30" or "current".
+	SnapshotLlndkVersion() string
+
+	// SnapshotFloatAbi returns the float ABI of this module, e.g. "softfp", or an empty string
+	// if it isn't for arm.
+	SnapshotFloatAbi() string
+
+	// SnapshotInstructionSet returns the instruction set of this module, e.g. "thumb", or an
+	// empty string if it isn't for arm.
+	SnapshotInstructionSet() string
+
+	// SnapshotProfileData returns the instrumentation PGO profile this module is compiled with, if
+	// any. Sampling profiles are returned by SnapshotAfdoProfile.
+	SnapshotProfileData() android.OptionalPath
+
+	// SnapshotAfdoProfile returns the AFDO (sampling PGO) profile this module is compiled with, if
+	// any.
+	SnapshotAfdoProfile() android.OptionalPath
+
 	// IsSnapshotPrebuilt returns true if this module is a snapshot prebuilt.
 	IsSnapshotPrebuilt() bool
 }
@@ -111,6 +270,7 @@ type LinkableInterface interface {
 	BaseModuleName() string
 
 	OutputFile() android.OptionalPath
+	UnstrippedOutputFile() android.Path
 	CoverageFiles() android.Paths
 
 	NonCcVariants() bool
@@ -126,6 +286,10 @@ type LinkableInterface interface {
 
 	Host() bool
 
+	// IsNativeBridgeSupported returns true if native_bridge_supported is set, i.e. this module is
+	// expected to be translated with native bridge on devices using it.
+	IsNativeBridgeSupported() bool
+
 	InRamdisk() bool
 	OnlyInRamdisk() bool
 
@@ -173,7 +337,14 @@ type LinkableInterface interface {
 	IsVndkExt() bool
 	IsVndkPrivate() bool
 	HasVendorVariant() bool
+	VendorVariantToOdm() bool
+	VendorVariantToVendorDlkm() bool
 	HasProductVariant() bool
+
+	// InstallPartition returns the partition this module is installed to, e.g. "vendor" or
+	// "odm", relative to the product out directory.
+	InstallPartition() string
+
 	HasNonSystemVariants() bool
 	InProduct() bool
 
@@ -228,6 +228,9 @@ type baseLinker struct {
 	}
 
 	sanitize *sanitize
+
+	// The version script the module is linked with, if any
+	versionScript android.OptionalPath
 }
 
 func (linker *baseLinker) appendLdflags(flags []string) {
@@ -551,6 +554,7 @@ func (linker *baseLinker) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 				flags.Local.LdFlags = append(flags.Local.LdFlags,
 					"-Wl,--version-script,"+versionScript.String())
 				flags.LdFlagsDeps = append(flags.LdFlagsDeps, versionScript.Path())
+				linker.versionScript = versionScript
 
 				if linker.sanitize.isSanitizerEnabled(cfi) {
 					cfiExportsMap := android.PathForSource(ctx, cfiExportsMapPath)
@@ -98,6 +98,8 @@ func makeVarsProvider(ctx android.MakeVarsContext) {
 
 	ctx.Strict("BOARD_VNDK_VERSION", ctx.DeviceConfig().VndkVersion())
 	ctx.Strict("RECOVERY_SNAPSHOT_VERSION", ctx.DeviceConfig().RecoverySnapshotVersion())
+	ctx.Strict("RAMDISK_SNAPSHOT_VERSION", ctx.DeviceConfig().RamdiskSnapshotVersion())
+	ctx.Strict("VENDOR_RAMDISK_SNAPSHOT_VERSION", ctx.DeviceConfig().VendorRamdiskSnapshotVersion())
 
 	// Filter vendor_public_library that are exported to make
 	exportedVendorPublicLibraries := []string{}
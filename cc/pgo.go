@@ -73,6 +73,9 @@ type PgoProperties struct {
 
 type pgo struct {
 	Properties PgoProperties
+
+	// The profile file used to compile this module, if any.
+	profileFile android.OptionalPath
 }
 
 func (props *PgoProperties) isInstrumentation() bool {
@@ -321,6 +324,9 @@ func (pgo *pgo) flags(ctx ModuleContext, flags Flags) Flags {
 
 	if !ctx.Config().IsEnvTrue("ANDROID_PGO_NO_PROFILE_USE") {
 		flags = props.addProfileUseFlags(ctx, flags)
+		if props.PgoPresent && props.PgoCompile {
+			pgo.profileFile = props.getPgoProfileFile(ctx)
+		}
 	}
 
 	return flags
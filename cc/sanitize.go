@@ -231,6 +231,9 @@ type SanitizeProperties struct {
 
 type sanitize struct {
 	Properties SanitizeProperties
+
+	// The sanitizer blocklist used to compile this module, if any.
+	blocklist android.OptionalPath
 }
 
 // Mark this tag with a check to see if apex dependency check should be skipped
@@ -717,6 +720,7 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 	}
 
 	blocklist := android.OptionalPathForModuleSrc(ctx, sanitize.Properties.Sanitize.Blocklist)
+	sanitize.blocklist = blocklist
 	if blocklist.Valid() {
 		flags.Local.CFlags = append(flags.Local.CFlags, "-fsanitize-blacklist="+blocklist.String())
 		flags.CFlagsDeps = append(flags.CFlagsDeps, blocklist.Path())
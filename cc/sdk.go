@@ -60,7 +60,8 @@ func sdkMutator(ctx android.BottomUpMutatorContext) {
 		} else {
 			if m, ok := ctx.Module().(*Module); ok {
 				// Clear the sdk_version property for modules that don't have an SDK variant so
-				// later code doesn't get confused by it.
+				// later code doesn't get confused by it. The snapshots still record it.
+				m.Properties.SnapshotSdkVersion = String(m.Properties.Sdk_version)
 				m.Properties.Sdk_version = nil
 			}
 			ctx.CreateVariations("")
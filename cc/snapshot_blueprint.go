@@ -0,0 +1,258 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+// This file generates the Android.bp file declaring the snapshot prebuilt modules of a captured
+// snapshot, e.g. vendor_snapshot_shared modules, so that the snapshot can be built right after
+// it is unzipped, without converting its json files first.
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"android/soong/android"
+)
+
+// snapshotBlueprintTypes are the types of the captured modules which are declared in the
+// generated Android.bp file, along with the properties of the {IMAGE}_snapshot module listing
+// them.
+var snapshotBlueprintTypes = []struct {
+	snapshotType string
+	listProperty string
+}{
+	{"header", "header_libs"},
+	{"static", "static_libs"},
+	{"shared", "shared_libs"},
+	{"binary", "binaries"},
+	{"object", "objects"},
+}
+
+// snapshotBlueprintModule describes a snapshot prebuilt module declared in the generated
+// Android.bp file.
+type snapshotBlueprintModule struct {
+	// Type of the captured module, one of snapshotBlueprintTypes.
+	snapshotType string
+
+	name                string
+	relativeInstallPath string
+
+	// Whether the captured module is a Rust FFI library, declared as an
+	// {IMAGE}_snapshot_rust_ffi_{shared,static} module.
+	rust bool
+
+	// Multilibs of the captured variants, e.g. "lib64".
+	multilibs map[string]bool
+
+	// Properties of the captured variants, keyed by arch names, e.g. "arm64".
+	arches map[string]*snapshotBlueprintArch
+}
+
+// snapshotBlueprintArch holds the arch specific properties of a snapshot prebuilt module. Paths are
+// relative to the snapshot arch directory, where the Android.bp file is generated.
+type snapshotBlueprintArch struct {
+	src    string
+	cfiSrc string
+	toc    string
+
+	exportIncludeDirs       []string
+	exportSystemIncludeDirs []string
+	exportFlags             []string
+
+	sharedLibs  []string
+	staticLibs  []string
+	headerLibs  []string
+	runtimeLibs []string
+}
+
+// addSnapshotBlueprintVariant adds a captured variant described by prop, whose prebuilt is src, to
+// the modules. src is empty for header libraries.
+func addSnapshotBlueprintVariant(modules map[string]*snapshotBlueprintModule, snapshotType string,
+	name string, target android.Target, prop snapshotJsonFlags, src string) {
+
+	key := snapshotType + "/" + name
+	module := modules[key]
+	if module == nil {
+		module = &snapshotBlueprintModule{
+			snapshotType:        snapshotType,
+			name:                name,
+			relativeInstallPath: prop.RelativeInstallPath,
+			rust:                prop.Rust,
+			multilibs:           make(map[string]bool),
+			arches:              make(map[string]*snapshotBlueprintArch),
+		}
+		modules[key] = module
+	}
+	module.multilibs[target.Arch.ArchType.Multilib] = true
+
+	arch := module.arches[target.Arch.ArchType.String()]
+	if arch == nil {
+		arch = &snapshotBlueprintArch{}
+		module.arches[target.Arch.ArchType.String()] = arch
+	}
+
+	// CFI variants of static libraries are nested in the arch properties of the non-CFI ones.
+	if len(prop.Sanitize) > 0 {
+		if len(prop.Sanitize) == 1 && prop.Sanitize[0] == "cfi" {
+			arch.cfiSrc = src
+		}
+		return
+	}
+
+	arch.src = src
+	if prop.Toc != "" {
+		arch.toc = filepath.Join(filepath.Dir(src), prop.Toc)
+	}
+	arch.exportIncludeDirs = prop.ExportedDirs
+	arch.exportSystemIncludeDirs = prop.ExportedSystemDirs
+	arch.exportFlags = prop.ExportedFlags
+	arch.sharedLibs = prop.SharedLibs
+	arch.staticLibs = prop.StaticLibs
+	arch.headerLibs = prop.HeaderLibs
+	arch.runtimeLibs = prop.RuntimeLibs
+}
+
+// snapshotBlueprint returns the content of the Android.bp file declaring the modules of the image
+// snapshot of the version, for the device arch targetArch.
+func snapshotBlueprint(image, version, targetArch string, modules map[string]*snapshotBlueprintModule) string {
+	w := &snapshotBlueprintWriter{}
+
+	// The vendor_dlkm snapshot modules create vendor variants, so they are listed in the
+	// vendor_snapshot module and declared as vendor modules.
+	variantImage := image
+	if image == "vendor_dlkm" {
+		variantImage = "vendor"
+	}
+
+	// The {IMAGE}_snapshot module lists the captured modules for each arch.
+	lists := make(map[string]map[string][]string)
+	for _, module := range modules {
+		for arch := range module.arches {
+			if lists[arch] == nil {
+				lists[arch] = make(map[string][]string)
+			}
+			lists[arch][module.snapshotType] = append(lists[arch][module.snapshotType], module.name)
+		}
+	}
+	w.line(0, "%s_snapshot {", variantImage)
+	w.string(1, "name", variantImage+"_snapshot")
+	w.string(1, "version", version)
+	w.line(1, "arch: {")
+	for _, arch := range android.SortedStringKeys(lists) {
+		w.line(2, "%s: {", arch)
+		for _, t := range snapshotBlueprintTypes {
+			w.list(3, t.listProperty, android.SortedUniqueStrings(lists[arch][t.snapshotType]))
+		}
+		w.line(2, "},")
+	}
+	w.line(1, "},")
+	w.line(0, "}")
+
+	keys := make([]string, 0, len(modules))
+	for key := range modules {
+		keys = append(keys, key)
+	}
+	typeOrder := make(map[string]int)
+	for i, t := range snapshotBlueprintTypes {
+		typeOrder[t.snapshotType] = i
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := modules[keys[i]], modules[keys[j]]
+		if a.snapshotType != b.snapshotType {
+			return typeOrder[a.snapshotType] < typeOrder[b.snapshotType]
+		}
+		return a.name < b.name
+	})
+
+	for _, key := range keys {
+		module := modules[key]
+		w.line(0, "")
+		if module.rust {
+			w.line(0, "%s_snapshot_rust_ffi_%s {", image, module.snapshotType)
+		} else {
+			w.line(0, "%s_snapshot_%s {", image, module.snapshotType)
+		}
+		w.string(1, "name", module.name)
+		w.string(1, "version", version)
+		w.string(1, "target_arch", targetArch)
+		w.line(1, "%s: true,", variantImage)
+		switch {
+		case module.multilibs["lib32"] && module.multilibs["lib64"]:
+			w.string(1, "compile_multilib", "both")
+		case module.multilibs["lib64"]:
+			w.string(1, "compile_multilib", "64")
+		default:
+			w.string(1, "compile_multilib", "32")
+		}
+		w.string(1, "relative_install_path", module.relativeInstallPath)
+		w.line(1, "arch: {")
+		for _, archName := range android.SortedStringKeys(module.arches) {
+			arch := module.arches[archName]
+			w.line(2, "%s: {", archName)
+			w.string(3, "src", arch.src)
+			w.string(3, "toc", arch.toc)
+			if arch.cfiSrc != "" {
+				w.line(3, "cfi: {")
+				w.string(4, "src", arch.cfiSrc)
+				w.line(3, "},")
+			}
+			w.list(3, "export_include_dirs", arch.exportIncludeDirs)
+			w.list(3, "export_system_include_dirs", arch.exportSystemIncludeDirs)
+			w.list(3, "export_flags", arch.exportFlags)
+			w.list(3, "shared_libs", arch.sharedLibs)
+			w.list(3, "static_libs", arch.staticLibs)
+			w.list(3, "header_libs", arch.headerLibs)
+			w.list(3, "runtime_libs", arch.runtimeLibs)
+			w.line(2, "},")
+		}
+		w.line(1, "},")
+		w.line(0, "}")
+	}
+
+	return w.String()
+}
+
+// snapshotBlueprintWriter writes Android.bp files in the format of bpfmt.
+type snapshotBlueprintWriter struct {
+	strings.Builder
+}
+
+func (w *snapshotBlueprintWriter) line(indent int, format string, args ...interface{}) {
+	if format != "" {
+		w.WriteString(strings.Repeat("    ", indent))
+		fmt.Fprintf(w, format, args...)
+	}
+	w.WriteString("\n")
+}
+
+// string writes a string property, unless value is empty.
+func (w *snapshotBlueprintWriter) string(indent int, name, value string) {
+	if value != "" {
+		w.line(indent, "%s: %q,", name, value)
+	}
+}
+
+// list writes a list of strings property, unless values is empty.
+func (w *snapshotBlueprintWriter) list(indent int, name string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	w.line(indent, "%s: [", name)
+	for _, value := range values {
+		w.line(indent+1, "%q,", value)
+	}
+	w.line(indent, "],")
+}
@@ -19,6 +19,7 @@ package cc
 
 import (
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"android/soong/android"
@@ -78,10 +79,31 @@ type snapshotImage interface {
 	// The variant suffix for snapshot modules. For example, vendor snapshot modules will have
 	// ".vendor" as their suffix.
 	moduleNameSuffix() string
+
+	// Returns the partition the module is installed to, if it differs from the default partition
+	// of this image. For example, vendor snapshot image will return "odm" for modules installed to
+	// /odm, or "vendor_dlkm" for ones installed to /vendor_dlkm. Such modules are captured under a
+	// subtree named after the partition.
+	partition(cfg android.DeviceConfig, m LinkableInterface) string
+
+	// Returns the synthetic version to label the snapshot with, if the snapshot is forced to be
+	// generated without the version of this image being "current". This is for testing only.
+	forcedSnapshotVersion(cfg android.DeviceConfig) string
 }
 
 type vendorSnapshotImage struct{}
 type recoverySnapshotImage struct{}
+type productSnapshotImage struct{}
+type ramdiskSnapshotImage struct{}
+type vendorRamdiskSnapshotImage struct{}
+
+// vendor_dlkm snapshot is the same as vendor snapshot, except that it captures vendor variants
+// for the vendor_dlkm partition, which vendor snapshot skips. Its module types create the same
+// vendor variants as the vendor snapshot module types, so they are listed in the vendor_snapshot
+// module, but they are installed to the vendor_dlkm or odm_dlkm partition.
+type vendorDlkmSnapshotImage struct {
+	vendorSnapshotImage
+}
 
 type directoryMap map[string]bool
 
@@ -106,8 +128,47 @@ var (
 		"hardware/libhardware_legacy": true,
 		"hardware/ril":                true,
 	}
+
+	// Rule to copy a snapshot prebuilt after checking that it is built for the given ELF machine.
+	// Static libraries have an ELF header for each of their members, all of which are checked.
+	snapshotElfMachineCheck = pctx.AndroidStaticRule("snapshotElfMachineCheck",
+		blueprint.RuleParams{
+			Command: `if ${config.ClangBin}/llvm-readelf -h ${in} | grep "Machine:" | grep -qv "Machine: *${machine}$$"; then ` +
+				`echo "error: ${in} is not built for ${arch}" >&2; exit 1; fi && cp -f ${in} ${out}`,
+			CommandDeps: []string{"${config.ClangBin}/llvm-readelf"},
+		}, "machine", "arch")
+
+	// ELF machines of the arches, as printed by llvm-readelf.
+	snapshotElfMachines = map[android.ArchType]string{
+		android.Arm:    "ARM",
+		android.Arm64:  "AArch64",
+		android.X86:    "Intel 80386",
+		android.X86_64: "Advanced Micro Devices X86-64",
+	}
 )
 
+// checkedSnapshotSrc returns the prebuilt in, copied after checking that it is built for the arch
+// of the variant if ValidateSnapshotElfMachine is set, so that prebuilts mislabeled with a wrong
+// arch fail the build instead of failing to link on the consumer.
+func checkedSnapshotSrc(ctx ModuleContext, in android.Path) android.Path {
+	machine, ok := snapshotElfMachines[ctx.Arch().ArchType]
+	if !ctx.DeviceConfig().ValidateSnapshotElfMachine() || !ok {
+		return in
+	}
+	out := android.PathForModuleOut(ctx, "checked", in.Base())
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        snapshotElfMachineCheck,
+		Description: "check ELF machine " + in.Base(),
+		Input:       in,
+		Output:      out,
+		Args: map[string]string{
+			"machine": machine,
+			"arch":    ctx.Arch().ArchType.String(),
+		},
+	})
+	return out
+}
+
 func (vendorSnapshotImage) init(ctx android.RegistrationContext) {
 	ctx.RegisterSingletonType("vendor-snapshot", VendorSnapshotSingleton)
 	ctx.RegisterModuleType("vendor_snapshot", vendorSnapshotFactory)
@@ -116,17 +177,23 @@ func (vendorSnapshotImage) init(ctx android.RegistrationContext) {
 	ctx.RegisterModuleType("vendor_snapshot_header", VendorSnapshotHeaderFactory)
 	ctx.RegisterModuleType("vendor_snapshot_binary", VendorSnapshotBinaryFactory)
 	ctx.RegisterModuleType("vendor_snapshot_object", VendorSnapshotObjectFactory)
+	// Rust FFI libraries expose a C ABI, so they are consumed just like cc libraries.
+	ctx.RegisterModuleType("vendor_snapshot_rust_ffi_shared", VendorSnapshotSharedFactory)
+	ctx.RegisterModuleType("vendor_snapshot_rust_ffi_static", VendorSnapshotStaticFactory)
 
 	ctx.RegisterSingletonType("vendor-fake-snapshot", VendorFakeSnapshotSingleton)
 }
 
-func (vendorSnapshotImage) shouldGenerateSnapshot(ctx android.SingletonContext) bool {
-	// BOARD_VNDK_VERSION must be set to 'current' in order to generate a snapshot.
-	return ctx.DeviceConfig().VndkVersion() == "current"
+func (v vendorSnapshotImage) shouldGenerateSnapshot(ctx android.SingletonContext) bool {
+	// BOARD_VNDK_VERSION must be set to 'current' in order to generate a snapshot, unless it is
+	// forced for testing.
+	return ctx.DeviceConfig().VndkVersion() == "current" || v.forcedSnapshotVersion(ctx.DeviceConfig()) != ""
 }
 
 func (vendorSnapshotImage) inImage(m LinkableInterface) func() bool {
-	return m.InVendor
+	return func() bool {
+		return m.InVendor() && !m.VendorVariantToVendorDlkm()
+	}
 }
 
 func (vendorSnapshotImage) private(m LinkableInterface) bool {
@@ -190,6 +257,23 @@ func (vendorSnapshotImage) moduleNameSuffix() string {
 	return VendorSuffix
 }
 
+func (vendorSnapshotImage) partition(cfg android.DeviceConfig, m LinkableInterface) string {
+	if partition := m.InstallPartition(); partition != cfg.VendorPath() {
+		return partition
+	}
+	return ""
+}
+
+func (vendorSnapshotImage) forcedSnapshotVersion(cfg android.DeviceConfig) string {
+	if cfg.VndkVersion() == "current" || !cfg.BoardVendorSnapshotForceGenerate() {
+		return ""
+	}
+	if version := cfg.BoardVendorSnapshotForcedVersion(); version != "" {
+		return version
+	}
+	return "forced"
+}
+
 func (recoverySnapshotImage) init(ctx android.RegistrationContext) {
 	ctx.RegisterSingletonType("recovery-snapshot", RecoverySnapshotSingleton)
 	ctx.RegisterModuleType("recovery_snapshot", recoverySnapshotFactory)
@@ -198,6 +282,9 @@ func (recoverySnapshotImage) init(ctx android.RegistrationContext) {
 	ctx.RegisterModuleType("recovery_snapshot_header", RecoverySnapshotHeaderFactory)
 	ctx.RegisterModuleType("recovery_snapshot_binary", RecoverySnapshotBinaryFactory)
 	ctx.RegisterModuleType("recovery_snapshot_object", RecoverySnapshotObjectFactory)
+	// Rust FFI libraries expose a C ABI, so they are consumed just like cc libraries.
+	ctx.RegisterModuleType("recovery_snapshot_rust_ffi_shared", RecoverySnapshotSharedFactory)
+	ctx.RegisterModuleType("recovery_snapshot_rust_ffi_static", RecoverySnapshotStaticFactory)
 }
 
 func (recoverySnapshotImage) shouldGenerateSnapshot(ctx android.SingletonContext) bool {
@@ -254,12 +341,285 @@ func (recoverySnapshotImage) moduleNameSuffix() string {
 	return recoverySuffix
 }
 
+// recovery snapshot modules are all installed to the recovery image.
+func (recoverySnapshotImage) partition(cfg android.DeviceConfig, m LinkableInterface) string {
+	return ""
+}
+
+func (recoverySnapshotImage) forcedSnapshotVersion(cfg android.DeviceConfig) string {
+	return ""
+}
+
+func (productSnapshotImage) init(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("product-snapshot", ProductSnapshotSingleton)
+	ctx.RegisterModuleType("product_snapshot", productSnapshotFactory)
+	ctx.RegisterModuleType("product_snapshot_shared", ProductSnapshotSharedFactory)
+	ctx.RegisterModuleType("product_snapshot_static", ProductSnapshotStaticFactory)
+	ctx.RegisterModuleType("product_snapshot_header", ProductSnapshotHeaderFactory)
+	ctx.RegisterModuleType("product_snapshot_binary", ProductSnapshotBinaryFactory)
+	ctx.RegisterModuleType("product_snapshot_object", ProductSnapshotObjectFactory)
+	ctx.RegisterModuleType("product_snapshot_rust_ffi_shared", ProductSnapshotSharedFactory)
+	ctx.RegisterModuleType("product_snapshot_rust_ffi_static", ProductSnapshotStaticFactory)
+}
+
+func (productSnapshotImage) shouldGenerateSnapshot(ctx android.SingletonContext) bool {
+	// PRODUCT_PRODUCT_VNDK_VERSION must be set to 'current' in order to generate a snapshot.
+	return ctx.DeviceConfig().ProductVndkVersion() == "current"
+}
+
+func (productSnapshotImage) inImage(m LinkableInterface) func() bool {
+	return m.InProduct
+}
+
+// VNDK-private libraries aren't available to the product image either.
+func (productSnapshotImage) private(m LinkableInterface) bool {
+	return m.IsVndkPrivate()
+}
+
+func (productSnapshotImage) isProprietaryPath(dir string, deviceConfig android.DeviceConfig) bool {
+	return isDirectoryExcluded(dir, deviceConfig.ProductSnapshotDirsExcludedMap(), deviceConfig.ProductSnapshotDirsIncludedMap())
+}
+
+// product snapshot includes static/header libraries with vndk: {enabled: true}, as vendor
+// snapshot does. Product variants link against the VNDK like vendor variants if
+// PRODUCT_PRODUCT_VNDK_VERSION is set, so the static VNDK libraries they link are captured,
+// while VNDK shared libraries are provided by the system image and aren't duplicated in the
+// snapshot.
+func (productSnapshotImage) includeVndk() bool {
+	return true
+}
+
+func (productSnapshotImage) excludeFromSnapshot(m LinkableInterface) bool {
+	return m.ExcludeFromProductSnapshot()
+}
+
+func (productSnapshotImage) isUsingSnapshot(cfg android.DeviceConfig) bool {
+	productVndkVersion := cfg.ProductVndkVersion()
+	return productVndkVersion != "current" && productVndkVersion != ""
+}
+
+func (productSnapshotImage) targetSnapshotVersion(cfg android.DeviceConfig) string {
+	return cfg.ProductVndkVersion()
+}
+
+// product snapshot doesn't support directed snapshot.
+func (productSnapshotImage) excludeFromDirectedSnapshot(cfg android.DeviceConfig, name string) bool {
+	return false
+}
+
+func (productSnapshotImage) imageVariantName(cfg android.DeviceConfig) string {
+	return ProductVariationPrefix + cfg.ProductVndkVersion()
+}
+
+func (productSnapshotImage) moduleNameSuffix() string {
+	return productSuffix
+}
+
+// product snapshot modules are all installed to the product image.
+func (productSnapshotImage) partition(cfg android.DeviceConfig, m LinkableInterface) string {
+	return ""
+}
+
+func (productSnapshotImage) forcedSnapshotVersion(cfg android.DeviceConfig) string {
+	return ""
+}
+
+func (ramdiskSnapshotImage) init(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("ramdisk-snapshot", RamdiskSnapshotSingleton)
+	ctx.RegisterModuleType("ramdisk_snapshot", ramdiskSnapshotFactory)
+	ctx.RegisterModuleType("ramdisk_snapshot_shared", RamdiskSnapshotSharedFactory)
+	ctx.RegisterModuleType("ramdisk_snapshot_static", RamdiskSnapshotStaticFactory)
+	ctx.RegisterModuleType("ramdisk_snapshot_header", RamdiskSnapshotHeaderFactory)
+	ctx.RegisterModuleType("ramdisk_snapshot_binary", RamdiskSnapshotBinaryFactory)
+	ctx.RegisterModuleType("ramdisk_snapshot_object", RamdiskSnapshotObjectFactory)
+	// Rust FFI libraries expose a C ABI, so they are consumed just like cc libraries.
+	ctx.RegisterModuleType("ramdisk_snapshot_rust_ffi_shared", RamdiskSnapshotSharedFactory)
+	ctx.RegisterModuleType("ramdisk_snapshot_rust_ffi_static", RamdiskSnapshotStaticFactory)
+}
+
+func (ramdiskSnapshotImage) shouldGenerateSnapshot(ctx android.SingletonContext) bool {
+	// RAMDISK_SNAPSHOT_VERSION must be set to 'current' in order to generate a
+	// snapshot.
+	return ctx.DeviceConfig().RamdiskSnapshotVersion() == "current"
+}
+
+func (ramdiskSnapshotImage) inImage(m LinkableInterface) func() bool {
+	return m.InRamdisk
+}
+
+// ramdisk snapshot does not have private libraries.
+func (ramdiskSnapshotImage) private(m LinkableInterface) bool {
+	return false
+}
+
+// ramdisk snapshot has no directories of its own to configure, and uses the default directories.
+func (ramdiskSnapshotImage) isProprietaryPath(dir string, deviceConfig android.DeviceConfig) bool {
+	return isDirectoryExcluded(dir, nil, nil)
+}
+
+// ramdisk snapshot does NOT treat vndk specially.
+func (ramdiskSnapshotImage) includeVndk() bool {
+	return false
+}
+
+// ramdisk snapshot has no exclusion property.
+func (ramdiskSnapshotImage) excludeFromSnapshot(m LinkableInterface) bool {
+	return false
+}
+
+func (ramdiskSnapshotImage) isUsingSnapshot(cfg android.DeviceConfig) bool {
+	snapshotVersion := cfg.RamdiskSnapshotVersion()
+	return snapshotVersion != "current" && snapshotVersion != ""
+}
+
+func (ramdiskSnapshotImage) targetSnapshotVersion(cfg android.DeviceConfig) string {
+	return cfg.RamdiskSnapshotVersion()
+}
+
+// ramdisk snapshot doesn't support directed snapshot.
+func (ramdiskSnapshotImage) excludeFromDirectedSnapshot(cfg android.DeviceConfig, name string) bool {
+	return false
+}
+
+func (ramdiskSnapshotImage) imageVariantName(cfg android.DeviceConfig) string {
+	return android.RamdiskVariation
+}
+
+func (ramdiskSnapshotImage) moduleNameSuffix() string {
+	return ramdiskSuffix
+}
+
+// ramdisk snapshot modules are all installed to the ramdisk image.
+func (ramdiskSnapshotImage) partition(cfg android.DeviceConfig, m LinkableInterface) string {
+	return ""
+}
+
+func (ramdiskSnapshotImage) forcedSnapshotVersion(cfg android.DeviceConfig) string {
+	return ""
+}
+
+func (vendorRamdiskSnapshotImage) init(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("vendor_ramdisk-snapshot", VendorRamdiskSnapshotSingleton)
+	ctx.RegisterModuleType("vendor_ramdisk_snapshot", vendorRamdiskSnapshotFactory)
+	ctx.RegisterModuleType("vendor_ramdisk_snapshot_shared", VendorRamdiskSnapshotSharedFactory)
+	ctx.RegisterModuleType("vendor_ramdisk_snapshot_static", VendorRamdiskSnapshotStaticFactory)
+	ctx.RegisterModuleType("vendor_ramdisk_snapshot_header", VendorRamdiskSnapshotHeaderFactory)
+	ctx.RegisterModuleType("vendor_ramdisk_snapshot_binary", VendorRamdiskSnapshotBinaryFactory)
+	ctx.RegisterModuleType("vendor_ramdisk_snapshot_object", VendorRamdiskSnapshotObjectFactory)
+	// Rust FFI libraries expose a C ABI, so they are consumed just like cc libraries.
+	ctx.RegisterModuleType("vendor_ramdisk_snapshot_rust_ffi_shared", VendorRamdiskSnapshotSharedFactory)
+	ctx.RegisterModuleType("vendor_ramdisk_snapshot_rust_ffi_static", VendorRamdiskSnapshotStaticFactory)
+}
+
+func (vendorRamdiskSnapshotImage) shouldGenerateSnapshot(ctx android.SingletonContext) bool {
+	// VENDOR_RAMDISK_SNAPSHOT_VERSION must be set to 'current' in order to generate a
+	// snapshot.
+	return ctx.DeviceConfig().VendorRamdiskSnapshotVersion() == "current"
+}
+
+func (vendorRamdiskSnapshotImage) inImage(m LinkableInterface) func() bool {
+	return m.InVendorRamdisk
+}
+
+// vendor_ramdisk snapshot does not have private libraries.
+func (vendorRamdiskSnapshotImage) private(m LinkableInterface) bool {
+	return false
+}
+
+// vendor_ramdisk snapshot has no directories of its own to configure, and uses the default directories.
+func (vendorRamdiskSnapshotImage) isProprietaryPath(dir string, deviceConfig android.DeviceConfig) bool {
+	return isDirectoryExcluded(dir, nil, nil)
+}
+
+// vendor_ramdisk snapshot does NOT treat vndk specially.
+func (vendorRamdiskSnapshotImage) includeVndk() bool {
+	return false
+}
+
+// vendor_ramdisk snapshot has no exclusion property.
+func (vendorRamdiskSnapshotImage) excludeFromSnapshot(m LinkableInterface) bool {
+	return false
+}
+
+func (vendorRamdiskSnapshotImage) isUsingSnapshot(cfg android.DeviceConfig) bool {
+	snapshotVersion := cfg.VendorRamdiskSnapshotVersion()
+	return snapshotVersion != "current" && snapshotVersion != ""
+}
+
+func (vendorRamdiskSnapshotImage) targetSnapshotVersion(cfg android.DeviceConfig) string {
+	return cfg.VendorRamdiskSnapshotVersion()
+}
+
+// vendor_ramdisk snapshot doesn't support directed snapshot.
+func (vendorRamdiskSnapshotImage) excludeFromDirectedSnapshot(cfg android.DeviceConfig, name string) bool {
+	return false
+}
+
+func (vendorRamdiskSnapshotImage) imageVariantName(cfg android.DeviceConfig) string {
+	return android.VendorRamdiskVariation
+}
+
+func (vendorRamdiskSnapshotImage) moduleNameSuffix() string {
+	return VendorRamdiskSuffix
+}
+
+// vendor_ramdisk snapshot modules are all installed to the vendor_ramdisk image.
+func (vendorRamdiskSnapshotImage) partition(cfg android.DeviceConfig, m LinkableInterface) string {
+	return ""
+}
+
+func (vendorRamdiskSnapshotImage) forcedSnapshotVersion(cfg android.DeviceConfig) string {
+	return ""
+}
+
+func (vendorDlkmSnapshotImage) init(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("vendor_dlkm-snapshot", VendorDlkmSnapshotSingleton)
+	ctx.RegisterModuleType("vendor_dlkm_snapshot_shared", VendorDlkmSnapshotSharedFactory)
+	ctx.RegisterModuleType("vendor_dlkm_snapshot_static", VendorDlkmSnapshotStaticFactory)
+	ctx.RegisterModuleType("vendor_dlkm_snapshot_header", VendorDlkmSnapshotHeaderFactory)
+	ctx.RegisterModuleType("vendor_dlkm_snapshot_binary", VendorDlkmSnapshotBinaryFactory)
+	ctx.RegisterModuleType("vendor_dlkm_snapshot_object", VendorDlkmSnapshotObjectFactory)
+	ctx.RegisterModuleType("vendor_dlkm_snapshot_rust_ffi_shared", VendorDlkmSnapshotSharedFactory)
+	ctx.RegisterModuleType("vendor_dlkm_snapshot_rust_ffi_static", VendorDlkmSnapshotStaticFactory)
+}
+
+func (vendorDlkmSnapshotImage) inImage(m LinkableInterface) func() bool {
+	return func() bool {
+		return m.InVendor() && m.VendorVariantToVendorDlkm()
+	}
+}
+
+// vendor_dlkm snapshot doesn't include VNDK, which is in the vendor snapshot.
+func (vendorDlkmSnapshotImage) includeVndk() bool {
+	return false
+}
+
+// vendor_dlkm snapshot modules are installed to a dlkm partition instead of the vendor partition,
+// so the partition is recorded for every module unlike the vendor snapshot.
+func (vendorDlkmSnapshotImage) partition(cfg android.DeviceConfig, m LinkableInterface) string {
+	return m.InstallPartition()
+}
+
+// vendor_dlkm snapshot is generated along with the vendor snapshot, so it is forced to be
+// generated with the same version.
+func (vendorDlkmSnapshotImage) forcedSnapshotVersion(cfg android.DeviceConfig) string {
+	return vendorSnapshotImageSingleton.forcedSnapshotVersion(cfg)
+}
+
 var vendorSnapshotImageSingleton vendorSnapshotImage
+var vendorDlkmSnapshotImageSingleton vendorDlkmSnapshotImage
 var recoverySnapshotImageSingleton recoverySnapshotImage
+var productSnapshotImageSingleton productSnapshotImage
+var ramdiskSnapshotImageSingleton ramdiskSnapshotImage
+var vendorRamdiskSnapshotImageSingleton vendorRamdiskSnapshotImage
 
 func init() {
 	vendorSnapshotImageSingleton.init(android.InitRegistrationContext)
+	vendorDlkmSnapshotImageSingleton.init(android.InitRegistrationContext)
 	recoverySnapshotImageSingleton.init(android.InitRegistrationContext)
+	productSnapshotImageSingleton.init(android.InitRegistrationContext)
+	ramdiskSnapshotImageSingleton.init(android.InitRegistrationContext)
+	vendorRamdiskSnapshotImageSingleton.init(android.InitRegistrationContext)
 }
 
 const (
@@ -383,6 +743,18 @@ func recoverySnapshotFactory() android.Module {
 	return snapshotFactory(recoverySnapshotImageSingleton)
 }
 
+func productSnapshotFactory() android.Module {
+	return snapshotFactory(productSnapshotImageSingleton)
+}
+
+func ramdiskSnapshotFactory() android.Module {
+	return snapshotFactory(ramdiskSnapshotImageSingleton)
+}
+
+func vendorRamdiskSnapshotFactory() android.Module {
+	return snapshotFactory(vendorRamdiskSnapshotImageSingleton)
+}
+
 func snapshotFactory(image snapshotImage) android.Module {
 	snapshot := &snapshot{}
 	snapshot.image = image
@@ -486,6 +858,10 @@ func (p *baseSnapshotDecorator) init(m *Module, image snapshotImage, moduleSuffi
 	p.image = image
 	p.baseProperties.ModuleSuffix = image.moduleNameSuffix() + moduleSuffix
 	m.AddProperties(&p.baseProperties)
+	// vendor_dlkm snapshot modules are vendor variants installed to the vendor_dlkm partition.
+	if _, ok := image.(vendorDlkmSnapshotImage); ok {
+		m.VendorProperties.Vendor_dlkm_available = BoolPtr(true)
+	}
 	android.AddLoadHook(m, func(ctx android.LoadHookContext) {
 		vendorSnapshotLoadHook(ctx, p)
 	})
@@ -494,12 +870,34 @@ func (p *baseSnapshotDecorator) init(m *Module, image snapshotImage, moduleSuffi
 // vendorSnapshotLoadHook disables snapshots if it's not BOARD_VNDK_VERSION.
 // As vendor snapshot is only for vendor, such modules won't be used at all.
 func vendorSnapshotLoadHook(ctx android.LoadHookContext, p *baseSnapshotDecorator) {
-	if p.version() != ctx.DeviceConfig().VndkVersion() {
+	vndkVersion := ctx.DeviceConfig().VndkVersion()
+	// Product and ramdisk snapshot modules are used with the snapshot versions of their images
+	// instead.
+	switch p.image.(type) {
+	case productSnapshotImage, ramdiskSnapshotImage, vendorRamdiskSnapshotImage:
+		vndkVersion = p.image.targetSnapshotVersion(ctx.DeviceConfig())
+	}
+	if p.version() != vndkVersion {
+		// Stale snapshot modules are reported by snapshotVersionCheckSingleton instead.
 		ctx.Module().Disable()
 		return
 	}
 }
 
+// isOlderSnapshotVersion returns true if both versions are numeric and version is older than
+// targetVersion. Non-numeric versions, e.g. "current", are never considered older.
+func isOlderSnapshotVersion(version, targetVersion string) bool {
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return false
+	}
+	target, err := strconv.Atoi(targetVersion)
+	if err != nil {
+		return false
+	}
+	return v < target
+}
+
 //
 // Module definitions for snapshots of libraries (shared, static, header).
 //
@@ -513,6 +911,9 @@ type snapshotLibraryProperties struct {
 	// Prebuilt file for each arch.
 	Src *string `android:"arch_variant"`
 
+	// Prebuilt table of contents of the shared library, generated from src if absent.
+	Toc *string `android:"arch_variant"`
+
 	// list of directories that will be added to the include path (using -I).
 	Export_include_dirs []string `android:"arch_variant"`
 
@@ -569,6 +970,9 @@ func (p *snapshotLibraryDecorator) link(ctx ModuleContext, flags Flags, deps Pat
 	p.setSnapshotAndroidMkSuffix(ctx)
 
 	if p.header() {
+		// Include directories are exported by libraryDecorator.link, but export_flags is
+		// specific to snapshots and has to be exported here.
+		p.libraryDecorator.reexportFlags(p.properties.Export_flags...)
 		return p.libraryDecorator.link(ctx, flags, deps, objs)
 	}
 
@@ -592,7 +996,7 @@ func (p *snapshotLibraryDecorator) link(ctx ModuleContext, flags Flags, deps Pat
 	p.libraryDecorator.reexportDeps(deps.ReexportedDeps...)
 	p.libraryDecorator.addExportedGeneratedHeaders(deps.ReexportedGeneratedHeaders...)
 
-	in := android.PathForModuleSrc(ctx, *p.properties.Src)
+	in := checkedSnapshotSrc(ctx, android.PathForModuleSrc(ctx, *p.properties.Src))
 	p.unstrippedOutputFile = in
 
 	if p.shared() {
@@ -600,10 +1004,15 @@ func (p *snapshotLibraryDecorator) link(ctx ModuleContext, flags Flags, deps Pat
 		builderFlags := flagsToBuilderFlags(flags)
 
 		// Optimize out relinking against shared libraries whose interface hasn't changed by
-		// depending on a table of contents file instead of the library itself.
-		tocFile := android.PathForModuleOut(ctx, libName+".toc")
-		p.tocFile = android.OptionalPathForPath(tocFile)
-		transformSharedObjectToToc(ctx, in, tocFile, builderFlags)
+		// depending on a table of contents file instead of the library itself. The snapshot may
+		// come with the table of contents to save generating it.
+		if p.properties.Toc != nil {
+			p.tocFile = android.OptionalPathForPath(android.PathForModuleSrc(ctx, *p.properties.Toc))
+		} else {
+			tocFile := android.PathForModuleOut(ctx, libName+".toc")
+			p.tocFile = android.OptionalPathForPath(tocFile)
+			transformSharedObjectToToc(ctx, in, tocFile, builderFlags)
+		}
 
 		ctx.SetProvider(SharedLibraryInfoProvider, SharedLibraryInfo{
 			SharedLibrary:           in,
@@ -701,6 +1110,16 @@ func VendorSnapshotSharedFactory() android.Module {
 	return module.Init()
 }
 
+// vendor_dlkm_snapshot_shared is a special prebuilt shared library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of vendor_dlkm snapshot,
+// vendor_dlkm_snapshot_shared overrides the vendor_dlkm variant of the cc shared library with the
+// same name, if BOARD_VNDK_VERSION is set.
+func VendorDlkmSnapshotSharedFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(vendorDlkmSnapshotImageSingleton, snapshotSharedSuffix)
+	prebuilt.libraryDecorator.BuildOnlyShared()
+	return module.Init()
+}
+
 // recovery_snapshot_shared is a special prebuilt shared library which is auto-generated by
 // development/vendor_snapshot/update.py. As a part of recovery snapshot, recovery_snapshot_shared
 // overrides the recovery variant of the cc shared library with the same name, if BOARD_VNDK_VERSION
@@ -721,6 +1140,16 @@ func VendorSnapshotStaticFactory() android.Module {
 	return module.Init()
 }
 
+// vendor_dlkm_snapshot_static is a special prebuilt static library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of vendor_dlkm snapshot,
+// vendor_dlkm_snapshot_static overrides the vendor_dlkm variant of the cc static library with the
+// same name, if BOARD_VNDK_VERSION is set.
+func VendorDlkmSnapshotStaticFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(vendorDlkmSnapshotImageSingleton, snapshotStaticSuffix)
+	prebuilt.libraryDecorator.BuildOnlyStatic()
+	return module.Init()
+}
+
 // recovery_snapshot_static is a special prebuilt static library which is auto-generated by
 // development/vendor_snapshot/update.py. As a part of recovery snapshot, recovery_snapshot_static
 // overrides the recovery variant of the cc static library with the same name, if BOARD_VNDK_VERSION
@@ -741,6 +1170,16 @@ func VendorSnapshotHeaderFactory() android.Module {
 	return module.Init()
 }
 
+// vendor_dlkm_snapshot_header is a special header library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of vendor_dlkm snapshot,
+// vendor_dlkm_snapshot_header overrides the vendor_dlkm variant of the cc header library with the
+// same name, if BOARD_VNDK_VERSION is set.
+func VendorDlkmSnapshotHeaderFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(vendorDlkmSnapshotImageSingleton, snapshotHeaderSuffix)
+	prebuilt.libraryDecorator.HeaderOnly()
+	return module.Init()
+}
+
 // recovery_snapshot_header is a special header library which is auto-generated by
 // development/vendor_snapshot/update.py. As a part of recovery snapshot, recovery_snapshot_header
 // overrides the recovery variant of the cc header library with the same name, if BOARD_VNDK_VERSION
@@ -751,6 +1190,96 @@ func RecoverySnapshotHeaderFactory() android.Module {
 	return module.Init()
 }
 
+// product_snapshot_shared is a special prebuilt shared library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of product snapshot, product_snapshot_shared
+// overrides the product variant of the cc shared library with the same name, if
+// PRODUCT_PRODUCT_VNDK_VERSION is set.
+func ProductSnapshotSharedFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(productSnapshotImageSingleton, snapshotSharedSuffix)
+	prebuilt.libraryDecorator.BuildOnlyShared()
+	return module.Init()
+}
+
+// product_snapshot_static is a special prebuilt static library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of product snapshot, product_snapshot_static
+// overrides the product variant of the cc static library with the same name, if
+// PRODUCT_PRODUCT_VNDK_VERSION is set.
+func ProductSnapshotStaticFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(productSnapshotImageSingleton, snapshotStaticSuffix)
+	prebuilt.libraryDecorator.BuildOnlyStatic()
+	return module.Init()
+}
+
+// product_snapshot_header is a special header library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of product snapshot, product_snapshot_header
+// overrides the product variant of the cc header library with the same name, if
+// PRODUCT_PRODUCT_VNDK_VERSION is set.
+func ProductSnapshotHeaderFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(productSnapshotImageSingleton, snapshotHeaderSuffix)
+	prebuilt.libraryDecorator.HeaderOnly()
+	return module.Init()
+}
+
+// ramdisk_snapshot_shared is a special prebuilt shared library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of ramdisk snapshot, ramdisk_snapshot_shared
+// overrides the ramdisk variant of the cc shared library with the same name, if
+// RAMDISK_SNAPSHOT_VERSION is set.
+func RamdiskSnapshotSharedFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(ramdiskSnapshotImageSingleton, snapshotSharedSuffix)
+	prebuilt.libraryDecorator.BuildOnlyShared()
+	return module.Init()
+}
+
+// ramdisk_snapshot_static is a special prebuilt static library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of ramdisk snapshot, ramdisk_snapshot_static
+// overrides the ramdisk variant of the cc static library with the same name, if
+// RAMDISK_SNAPSHOT_VERSION is set.
+func RamdiskSnapshotStaticFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(ramdiskSnapshotImageSingleton, snapshotStaticSuffix)
+	prebuilt.libraryDecorator.BuildOnlyStatic()
+	return module.Init()
+}
+
+// ramdisk_snapshot_header is a special header library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of ramdisk snapshot, ramdisk_snapshot_header
+// overrides the ramdisk variant of the cc header library with the same name, if
+// RAMDISK_SNAPSHOT_VERSION is set.
+func RamdiskSnapshotHeaderFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(ramdiskSnapshotImageSingleton, snapshotHeaderSuffix)
+	prebuilt.libraryDecorator.HeaderOnly()
+	return module.Init()
+}
+
+// vendor_ramdisk_snapshot_shared is a special prebuilt shared library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of vendor ramdisk snapshot,
+// vendor_ramdisk_snapshot_shared overrides the vendor ramdisk variant of the cc shared library with
+// the same name, if VENDOR_RAMDISK_SNAPSHOT_VERSION is set.
+func VendorRamdiskSnapshotSharedFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(vendorRamdiskSnapshotImageSingleton, snapshotSharedSuffix)
+	prebuilt.libraryDecorator.BuildOnlyShared()
+	return module.Init()
+}
+
+// vendor_ramdisk_snapshot_static is a special prebuilt static library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of vendor ramdisk snapshot,
+// vendor_ramdisk_snapshot_static overrides the vendor ramdisk variant of the cc static library with
+// the same name, if VENDOR_RAMDISK_SNAPSHOT_VERSION is set.
+func VendorRamdiskSnapshotStaticFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(vendorRamdiskSnapshotImageSingleton, snapshotStaticSuffix)
+	prebuilt.libraryDecorator.BuildOnlyStatic()
+	return module.Init()
+}
+
+// vendor_ramdisk_snapshot_header is a special header library which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of vendor ramdisk snapshot,
+// vendor_ramdisk_snapshot_header overrides the vendor ramdisk variant of the cc header library with
+// the same name, if VENDOR_RAMDISK_SNAPSHOT_VERSION is set.
+func VendorRamdiskSnapshotHeaderFactory() android.Module {
+	module, prebuilt := snapshotLibraryFactory(vendorRamdiskSnapshotImageSingleton, snapshotHeaderSuffix)
+	prebuilt.libraryDecorator.HeaderOnly()
+	return module.Init()
+}
+
 var _ snapshotSanitizer = (*snapshotLibraryDecorator)(nil)
 
 //
@@ -790,7 +1319,7 @@ func (p *snapshotBinaryDecorator) link(ctx ModuleContext, flags Flags, deps Path
 		return nil
 	}
 
-	in := android.PathForModuleSrc(ctx, *p.properties.Src)
+	in := checkedSnapshotSrc(ctx, android.PathForModuleSrc(ctx, *p.properties.Src))
 	p.unstrippedOutputFile = in
 	binName := in.Base()
 
@@ -817,6 +1346,14 @@ func VendorSnapshotBinaryFactory() android.Module {
 	return snapshotBinaryFactory(vendorSnapshotImageSingleton, snapshotBinarySuffix)
 }
 
+// vendor_dlkm_snapshot_binary is a special prebuilt executable binary which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of vendor_dlkm snapshot,
+// vendor_dlkm_snapshot_binary overrides the vendor_dlkm variant of the cc binary with the same
+// name, if BOARD_VNDK_VERSION is set.
+func VendorDlkmSnapshotBinaryFactory() android.Module {
+	return snapshotBinaryFactory(vendorDlkmSnapshotImageSingleton, snapshotBinarySuffix)
+}
+
 // recovery_snapshot_binary is a special prebuilt executable binary which is auto-generated by
 // development/vendor_snapshot/update.py. As a part of recovery snapshot, recovery_snapshot_binary
 // overrides the recovery variant of the cc binary with the same name, if BOARD_VNDK_VERSION is set.
@@ -824,6 +1361,30 @@ func RecoverySnapshotBinaryFactory() android.Module {
 	return snapshotBinaryFactory(recoverySnapshotImageSingleton, snapshotBinarySuffix)
 }
 
+// product_snapshot_binary is a special prebuilt executable binary which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of product snapshot, product_snapshot_binary
+// overrides the product variant of the cc binary with the same name, if
+// PRODUCT_PRODUCT_VNDK_VERSION is set.
+func ProductSnapshotBinaryFactory() android.Module {
+	return snapshotBinaryFactory(productSnapshotImageSingleton, snapshotBinarySuffix)
+}
+
+// ramdisk_snapshot_binary is a special prebuilt executable binary which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of ramdisk snapshot, ramdisk_snapshot_binary
+// overrides the ramdisk variant of the cc binary with the same name, if
+// RAMDISK_SNAPSHOT_VERSION is set.
+func RamdiskSnapshotBinaryFactory() android.Module {
+	return snapshotBinaryFactory(ramdiskSnapshotImageSingleton, snapshotBinarySuffix)
+}
+
+// vendor_ramdisk_snapshot_binary is a special prebuilt executable binary which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of vendor ramdisk snapshot,
+// vendor_ramdisk_snapshot_binary overrides the vendor ramdisk variant of the cc binary with the
+// same name, if VENDOR_RAMDISK_SNAPSHOT_VERSION is set.
+func VendorRamdiskSnapshotBinaryFactory() android.Module {
+	return snapshotBinaryFactory(vendorRamdiskSnapshotImageSingleton, snapshotBinarySuffix)
+}
+
 func snapshotBinaryFactory(image snapshotImage, moduleSuffix string) android.Module {
 	module, binary := NewBinary(android.DeviceSupported)
 	binary.baseLinker.Properties.No_libcrt = BoolPtr(true)
@@ -885,7 +1446,7 @@ func (p *snapshotObjectLinker) link(ctx ModuleContext, flags Flags, deps PathDep
 		return nil
 	}
 
-	return android.PathForModuleSrc(ctx, *p.properties.Src)
+	return checkedSnapshotSrc(ctx, android.PathForModuleSrc(ctx, *p.properties.Src))
 }
 
 func (p *snapshotObjectLinker) nativeCoverage() bool {
@@ -910,6 +1471,25 @@ func VendorSnapshotObjectFactory() android.Module {
 	return module.Init()
 }
 
+// vendor_dlkm_snapshot_object is a special prebuilt compiled object file which is auto-generated
+// by development/vendor_snapshot/update.py. As a part of vendor_dlkm snapshot,
+// vendor_dlkm_snapshot_object overrides the vendor_dlkm variant of the cc object with the same
+// name, if BOARD_VNDK_VERSION is set.
+func VendorDlkmSnapshotObjectFactory() android.Module {
+	module := newObject()
+
+	prebuilt := &snapshotObjectLinker{
+		objectLinker: objectLinker{
+			baseLinker: NewBaseLinker(nil),
+		},
+	}
+	module.linker = prebuilt
+
+	prebuilt.init(module, vendorDlkmSnapshotImageSingleton, snapshotObjectSuffix)
+	module.AddProperties(&prebuilt.properties)
+	return module.Init()
+}
+
 // recovery_snapshot_object is a special prebuilt compiled object file which is auto-generated by
 // development/vendor_snapshot/update.py. As a part of recovery snapshot, recovery_snapshot_object
 // overrides the recovery variant of the cc object with the same name, if BOARD_VNDK_VERSION is set.
@@ -928,6 +1508,63 @@ func RecoverySnapshotObjectFactory() android.Module {
 	return module.Init()
 }
 
+// product_snapshot_object is a special prebuilt compiled object file which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of product snapshot, product_snapshot_object
+// overrides the product variant of the cc object with the same name, if
+// PRODUCT_PRODUCT_VNDK_VERSION is set.
+func ProductSnapshotObjectFactory() android.Module {
+	module := newObject()
+
+	prebuilt := &snapshotObjectLinker{
+		objectLinker: objectLinker{
+			baseLinker: NewBaseLinker(nil),
+		},
+	}
+	module.linker = prebuilt
+
+	prebuilt.init(module, productSnapshotImageSingleton, snapshotObjectSuffix)
+	module.AddProperties(&prebuilt.properties)
+	return module.Init()
+}
+
+// ramdisk_snapshot_object is a special prebuilt compiled object file which is auto-generated by
+// development/vendor_snapshot/update.py. As a part of ramdisk snapshot, ramdisk_snapshot_object
+// overrides the ramdisk variant of the cc object with the same name, if
+// RAMDISK_SNAPSHOT_VERSION is set.
+func RamdiskSnapshotObjectFactory() android.Module {
+	module := newObject()
+
+	prebuilt := &snapshotObjectLinker{
+		objectLinker: objectLinker{
+			baseLinker: NewBaseLinker(nil),
+		},
+	}
+	module.linker = prebuilt
+
+	prebuilt.init(module, ramdiskSnapshotImageSingleton, snapshotObjectSuffix)
+	module.AddProperties(&prebuilt.properties)
+	return module.Init()
+}
+
+// vendor_ramdisk_snapshot_object is a special prebuilt compiled object file which is auto-generated
+// by development/vendor_snapshot/update.py. As a part of vendor ramdisk snapshot,
+// vendor_ramdisk_snapshot_object overrides the vendor ramdisk variant of the cc object with the
+// same name, if VENDOR_RAMDISK_SNAPSHOT_VERSION is set.
+func VendorRamdiskSnapshotObjectFactory() android.Module {
+	module := newObject()
+
+	prebuilt := &snapshotObjectLinker{
+		objectLinker: objectLinker{
+			baseLinker: NewBaseLinker(nil),
+		},
+	}
+	module.linker = prebuilt
+
+	prebuilt.init(module, vendorRamdiskSnapshotImageSingleton, snapshotObjectSuffix)
+	module.AddProperties(&prebuilt.properties)
+	return module.Init()
+}
+
 type snapshotInterface interface {
 	matchesWithDevice(config android.DeviceConfig) bool
 	isSnapshotPrebuilt() bool
@@ -16,13 +16,102 @@ package cc
 // This file contains utility types and functions for VNDK / vendor snapshot.
 
 import (
+	"strings"
+
 	"android/soong/android"
+	"android/soong/cc/config"
 )
 
 var (
 	headerExts = []string{".h", ".hh", ".hpp", ".hxx", ".h++", ".inl", ".inc", ".ipp", ".h.generic"}
+
+	// Compiler flags which change the ABI of the generated code. Static libraries captured to the
+	// snapshot record which of these they were compiled with, so that consumers can link against
+	// them compatibly. Entries ending with "=" match any flag with that prefix.
+	abiRelevantCflags = []string{
+		"-std=",
+		"-fexceptions",
+		"-fno-exceptions",
+		"-frtti",
+		"-fno-rtti",
+		"-fpack-struct",
+		"-fpack-struct=",
+		"-fshort-enums",
+		"-fno-short-enums",
+		"-fshort-wchar",
+		"-fno-short-wchar",
+		"-fsigned-char",
+		"-funsigned-char",
+		"-mfloat-abi=",
+		"-D_FILE_OFFSET_BITS=",
+	}
+
+	// Linker flags which change the runtime behavior of the linked shared library, e.g. its
+	// alignment or the symbols it exports. Shared libraries captured to the snapshot record which
+	// of these they were linked with. Entries ending with "=" match any flag with that prefix, other
+	// entries also match the flag followed by arguments, e.g. "-Wl,--exclude-libs,libfoo.a".
+	abiRelevantLdflags = []string{
+		"-Wl,-z,max-page-size=",
+		"-Wl,-z,common-page-size=",
+		"-Wl,-z,execstack",
+		"-Wl,-z,noexecstack",
+		"-Wl,-z,now",
+		"-Wl,-z,lazy",
+		"-Wl,-z,relro",
+		"-Wl,-z,norelro",
+		"-Wl,-z,nodelete",
+		"-Wl,-z,global",
+		"-Wl,--exclude-libs",
+		"-Wl,--hash-style=",
+		"-Wl,--pack-dyn-relocs=",
+		"-Wl,--version-script",
+		"-Wl,-Bsymbolic",
+		"-Wl,-Bsymbolic-functions",
+	}
+
+	// Preprocessor macros which change the layout of structs or classes in headers when defined.
+	// Consumers of a library exporting one of these must define it identically.
+	abiGatingDefines = []string{
+		"BIG_ENDIAN",
+		"LITTLE_ENDIAN",
+		"_FILE_OFFSET_BITS",
+		"_LARGEFILE64_SOURCE",
+		"_TIME_BITS",
+		"_GLIBCXX_DEBUG",
+		"_LIBCPP_DEBUG",
+		"_LIBCPP_ABI_UNSTABLE",
+		"_LIBCPP_ABI_VERSION",
+	}
 )
 
+// isAbiRelevantCflag returns true if the flag is one of abiRelevantCflags.
+func isAbiRelevantCflag(flag string) bool {
+	for _, f := range abiRelevantCflags {
+		if strings.HasSuffix(f, "=") {
+			if strings.HasPrefix(flag, f) {
+				return true
+			}
+		} else if flag == f {
+			return true
+		}
+	}
+	return false
+}
+
+// isAbiRelevantLdflag returns true if the flag is one of abiRelevantLdflags.
+func isAbiRelevantLdflag(flag string) bool {
+	for _, f := range abiRelevantLdflags {
+		if strings.HasSuffix(f, "=") {
+			if strings.HasPrefix(flag, f) {
+				return true
+			}
+		} else if flag == f || strings.HasPrefix(flag, f+",") || strings.HasPrefix(flag, f+"=") {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Module) IsSnapshotLibrary() bool {
 	if _, ok := m.linker.(snapshotLibraryInterface); ok {
 		return true
@@ -30,8 +119,12 @@ func (m *Module) IsSnapshotLibrary() bool {
 	return false
 }
 
+func (m *Module) SnapshotRust() bool {
+	return false
+}
+
 func (m *Module) SnapshotHeaders() android.Paths {
-	if m.IsSnapshotLibrary() {
+	if m.IsSnapshotLibrary() && m.SnapshotExportHeaders() {
 		return m.linker.(snapshotLibraryInterface).snapshotHeaders()
 	}
 	return android.Paths{}
@@ -53,6 +146,405 @@ func (m *Module) SnapshotSharedLibs() []string {
 	return m.Properties.SnapshotSharedLibs
 }
 
+func (m *Module) SnapshotStaticLibs() []string {
+	return m.Properties.SnapshotStaticLibs
+}
+
+func (m *Module) SnapshotHeaderLibs() []string {
+	return m.Properties.SnapshotHeaderLibs
+}
+
+func (m *Module) SnapshotNeededLibs() []string {
+	return android.SortedUniqueStrings(m.Properties.SnapshotNeededLibs)
+}
+
+// filterAbiGatingDefines returns the -D flags in flags defining one of abiGatingDefines.
+func filterAbiGatingDefines(flags []string) []string {
+	var ret []string
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, "-D") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(flag, "-D"), "=", 2)[0]
+		if android.InList(name, abiGatingDefines) {
+			ret = append(ret, flag)
+		}
+	}
+	return ret
+}
+
+func (m *Module) SnapshotAbiRelevantFlags() []string {
+	var ret []string
+	for _, flags := range []LocalOrGlobalFlags{m.flags.Global, m.flags.Local} {
+		for _, list := range [][]string{flags.CommonFlags, flags.CFlags, flags.ConlyFlags, flags.CppFlags} {
+			for _, flag := range list {
+				if isAbiRelevantCflag(flag) {
+					ret = append(ret, flag)
+				}
+			}
+		}
+	}
+	// The cflags specific to the image variant build it differently from the core variant. Like
+	// the other cflags, only the ones affecting the ABI are recorded, along with the defines gating
+	// the layout of structs.
+	imageCflags := m.imageCflags()
+	for _, flag := range imageCflags {
+		if isAbiRelevantCflag(flag) {
+			ret = append(ret, flag)
+		}
+	}
+	ret = append(ret, filterAbiGatingDefines(imageCflags)...)
+	return android.FirstUniqueStrings(ret)
+}
+
+func (m *Module) SnapshotCppFlags() []string {
+	return m.abiRelevantFlags(func(flags LocalOrGlobalFlags) []string { return flags.CppFlags })
+}
+
+func (m *Module) SnapshotConlyFlags() []string {
+	return m.abiRelevantFlags(func(flags LocalOrGlobalFlags) []string { return flags.ConlyFlags })
+}
+
+// abiRelevantFlags returns the ABI relevant flags of the list selected from the global and local
+// flags of this module.
+func (m *Module) abiRelevantFlags(list func(LocalOrGlobalFlags) []string) []string {
+	var ret []string
+	for _, flags := range []LocalOrGlobalFlags{m.flags.Global, m.flags.Local} {
+		for _, flag := range list(flags) {
+			if isAbiRelevantCflag(flag) {
+				ret = append(ret, flag)
+			}
+		}
+	}
+	return android.FirstUniqueStrings(ret)
+}
+
+// imageCflags returns the cflags specific to the image variant of this module, e.g. the cflags of
+// target: { vendor: { ... } } for vendor variants.
+func (m *Module) imageCflags() []string {
+	if m.compiler == nil {
+		return nil
+	}
+	for _, props := range m.compiler.compilerProps() {
+		if p, ok := props.(*BaseCompilerProperties); ok {
+			var ret []string
+			if m.InVendor() {
+				ret = append(ret, p.Target.Vendor.Cflags...)
+			}
+			if m.InProduct() {
+				ret = append(ret, p.Target.Product.Cflags...)
+			}
+			if m.InRecovery() {
+				ret = append(ret, p.Target.Recovery.Cflags...)
+			}
+			if m.InVendorRamdisk() {
+				ret = append(ret, p.Target.Vendor_ramdisk.Cflags...)
+			}
+			return ret
+		}
+	}
+	return nil
+}
+
+// baseLinkerProperties returns the BaseLinkerProperties of the linker of this module, or nil if it
+// has none.
+func (m *Module) baseLinkerProperties() *BaseLinkerProperties {
+	if m.linker == nil {
+		return nil
+	}
+	for _, props := range m.linker.linkerProps() {
+		if p, ok := props.(*BaseLinkerProperties); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// ldflags returns the ldflags property of this module.
+func (m *Module) ldflags() []string {
+	if p := m.baseLinkerProperties(); p != nil {
+		return p.Ldflags
+	}
+	return nil
+}
+
+func (m *Module) SnapshotCfiAssemblySupport() bool {
+	return m.isCfiAssemblySupportEnabled()
+}
+
+func (m *Module) SnapshotCfiExportsMap() android.OptionalPath {
+	// baseLinker adds the CFI exports map as a linker flags dependency along with the version
+	// script.
+	for _, dep := range m.flags.LdFlagsDeps {
+		if dep.String() == cfiExportsMapPath {
+			return android.OptionalPathForPath(dep)
+		}
+	}
+	return android.OptionalPath{}
+}
+
+func (m *Module) SnapshotCfiDiag() bool {
+	return m.sanitize != nil && m.sanitize.isSanitizerEnabled(cfi) && Bool(m.sanitize.Properties.Sanitize.Diag.Cfi)
+}
+
+func (m *Module) SnapshotSanitizers() []string {
+	if m.sanitize == nil {
+		return nil
+	}
+	return m.sanitize.Properties.Sanitizers
+}
+
+func (m *Module) SnapshotDiagSanitizers() []string {
+	if m.sanitize == nil {
+		return nil
+	}
+	return m.sanitize.Properties.DiagSanitizers
+}
+
+func (m *Module) SnapshotSanitizeBlocklist() android.OptionalPath {
+	if m.sanitize == nil {
+		return android.OptionalPath{}
+	}
+	return m.sanitize.blocklist
+}
+
+func (m *Module) SnapshotVersionScript() android.OptionalPath {
+	if library, ok := m.linker.(*libraryDecorator); ok && library.baseLinker != nil {
+		return library.baseLinker.versionScript
+	}
+	return android.OptionalPath{}
+}
+
+func (m *Module) SnapshotLinkFlags() []string {
+	var ret []string
+	// Toolchain flags in Global are ninja variable references, so only flags set on the command
+	// line, e.g. with the ldflags property, are matched.
+	for _, flags := range []LocalOrGlobalFlags{m.flags.Global, m.flags.Local} {
+		for _, flag := range flags.LdFlags {
+			if isAbiRelevantLdflag(flag) {
+				ret = append(ret, flag)
+			}
+		}
+	}
+	return android.FirstUniqueStrings(ret)
+}
+
+func (m *Module) SnapshotRunpaths() []string {
+	var ret []string
+	for _, flag := range m.ldflags() {
+		if !strings.HasPrefix(flag, "-Wl,") {
+			continue
+		}
+		// e.g. -Wl,-rpath,/vendor/lib64/foo or -Wl,--rpath=/vendor/lib64/foo
+		args := strings.Split(strings.TrimPrefix(flag, "-Wl,"), ",")
+		for i := 0; i < len(args); i++ {
+			arg := strings.TrimPrefix(args[i], "-")
+			if (arg == "-rpath" || arg == "rpath") && i+1 < len(args) {
+				i++
+				ret = append(ret, args[i])
+			} else if strings.HasPrefix(arg, "-rpath=") || strings.HasPrefix(arg, "rpath=") {
+				ret = append(ret, arg[strings.Index(arg, "=")+1:])
+			}
+		}
+	}
+	return ret
+}
+
+func (m *Module) SnapshotVndkExtends() string {
+	if !m.IsVndkExt() {
+		return ""
+	}
+	return m.getVndkExtendsModuleName()
+}
+
+func (m *Module) SnapshotKernelModules() android.Paths {
+	return m.snapshotKernelModules
+}
+
+func (m *Module) SnapshotData() android.Paths {
+	if binary, ok := m.linker.(*binaryDecorator); ok {
+		return binary.snapshotData
+	}
+	return nil
+}
+
+func (m *Module) SnapshotAvailableImages() []string {
+	return SnapshotAvailableImages(m.Properties.CoreVariantNeeded, m.Properties.RamdiskVariantNeeded,
+		m.Properties.VendorRamdiskVariantNeeded, m.Properties.RecoveryVariantNeeded, m.Properties.ExtraVariants)
+}
+
+// SnapshotAvailableImages returns the names of the images a module has variants for, given the
+// image variations the image mutator creates for it.
+func SnapshotAvailableImages(core, ramdisk, vendorRamdisk, recovery bool, extraVariants []string) []string {
+	var vendor, product bool
+	for _, variant := range extraVariants {
+		if strings.HasPrefix(variant, VendorVariationPrefix) {
+			vendor = true
+		} else if strings.HasPrefix(variant, ProductVariationPrefix) {
+			product = true
+		}
+	}
+
+	var ret []string
+	for _, image := range []struct {
+		name   string
+		needed bool
+	}{
+		{"core", core},
+		{"vendor", vendor},
+		{"product", product},
+		{"ramdisk", ramdisk},
+		{"vendor_ramdisk", vendorRamdisk},
+		{"recovery", recovery},
+	} {
+		if image.needed {
+			ret = append(ret, image.name)
+		}
+	}
+	return ret
+}
+
+func (m *Module) SnapshotNocrt() bool {
+	if p := m.baseLinkerProperties(); p != nil {
+		return Bool(p.Nocrt)
+	}
+	return false
+}
+
+func (m *Module) SnapshotNoLibcrt() bool {
+	if p := m.baseLinkerProperties(); p != nil {
+		return Bool(p.No_libcrt)
+	}
+	return false
+}
+
+func (m *Module) SnapshotCrt() bool {
+	if linker, ok := m.linker.(*objectLinker); ok {
+		return linker.isCrt()
+	}
+	return false
+}
+
+func (m *Module) SnapshotVendorPublic() bool {
+	return m.NeedsVendorPublicLibraryVariants()
+}
+
+func (m *Module) SnapshotStubsVersion() string {
+	if !m.IsStubs() {
+		return ""
+	}
+	return m.StubsVersion()
+}
+
+func (m *Module) SnapshotStubsSymbolFile() android.OptionalPath {
+	if library, ok := m.linker.(*libraryDecorator); ok {
+		return library.stubsSymbolFile
+	}
+	return android.OptionalPath{}
+}
+
+func (m *Module) SnapshotLlndkSymbolFile() android.OptionalPath {
+	if library, ok := m.linker.(*libraryDecorator); ok {
+		return library.llndkSymbolFile
+	}
+	return android.OptionalPath{}
+}
+
+func (m *Module) SnapshotLlndkVersion() string {
+	if library, ok := m.linker.(*libraryDecorator); ok {
+		return library.llndkVersion
+	}
+	return ""
+}
+
+func (m *Module) SnapshotFloatAbi() string {
+	if m.Target().Arch.ArchType != android.Arm {
+		return ""
+	}
+	return config.ArmFloatAbi(m.Target().Arch.ArchVariant)
+}
+
+func (m *Module) SnapshotInstructionSet() string {
+	if m.Target().Arch.ArchType != android.Arm || m.compiler == nil {
+		return ""
+	}
+	// Same as baseCompiler.compilerFlags: sanitizers may require an instruction set, and the
+	// arm toolchain defaults to thumb.
+	if m.flags.RequiredInstructionSet != "" {
+		return m.flags.RequiredInstructionSet
+	}
+	for _, props := range m.compiler.compilerProps() {
+		if p, ok := props.(*BaseCompilerProperties); ok && String(p.Instruction_set) != "" {
+			return String(p.Instruction_set)
+		}
+	}
+	return "thumb"
+}
+
+func (m *Module) SnapshotProfileData() android.OptionalPath {
+	// Sampling profiles are captured as AFDO profiles instead.
+	if m.pgo == nil || m.pgo.Properties.isSampling() {
+		return android.OptionalPath{}
+	}
+	return m.pgo.profileFile
+}
+
+func (m *Module) SnapshotAfdoProfile() android.OptionalPath {
+	if m.pgo == nil || !m.pgo.Properties.isSampling() {
+		return android.OptionalPath{}
+	}
+	return m.pgo.profileFile
+}
+
+func (m *Module) SnapshotVisibility() string {
+	// The last -fvisibility flag wins, and local flags come after global flags.
+	visibility := ""
+	for _, flags := range []LocalOrGlobalFlags{m.flags.Global, m.flags.Local} {
+		for _, list := range [][]string{flags.CommonFlags, flags.CFlags, flags.ConlyFlags, flags.CppFlags} {
+			for _, flag := range list {
+				if strings.HasPrefix(flag, "-fvisibility=") {
+					visibility = strings.TrimPrefix(flag, "-fvisibility=")
+				}
+			}
+		}
+	}
+	return visibility
+}
+
+func (m *Module) SnapshotPic() bool {
+	// The last code model flag wins, and local flags come after global flags.
+	pic := false
+	for _, flags := range []LocalOrGlobalFlags{m.flags.Global, m.flags.Local} {
+		for _, list := range [][]string{flags.CommonFlags, flags.CFlags, flags.ConlyFlags, flags.CppFlags} {
+			for _, flag := range list {
+				switch flag {
+				case "-fPIC", "-fpic", "-fPIE", "-fpie":
+					pic = true
+				case "-fno-PIC", "-fno-pic", "-fno-PIE", "-fno-pie":
+					pic = false
+				}
+			}
+		}
+	}
+	return pic
+}
+
+func (m *Module) SnapshotPie() bool {
+	// The last of -pie and -no-pie wins, and local flags come after global flags.
+	pie := false
+	for _, list := range [][]string{m.flags.Global.LdFlags, m.flags.Local.LdFlags} {
+		for _, flag := range list {
+			switch flag {
+			case "-pie":
+				pie = true
+			case "-no-pie":
+				pie = false
+			}
+		}
+	}
+	return pie
+}
+
 // snapshotLibraryInterface is an interface for libraries captured to VNDK / vendor snapshots.
 type snapshotLibraryInterface interface {
 	libraryInterface
@@ -102,14 +594,18 @@ func (s *snapshotMap) get(name string, arch android.ArchType) (snapshot string,
 // If it's true, collectHeadersForSnapshot will be called in GenerateAndroidBuildActions.
 func ShouldCollectHeadersForSnapshot(ctx android.ModuleContext, m LinkableInterface, apexInfo android.ApexInfo) bool {
 	if ctx.DeviceConfig().VndkVersion() != "current" &&
-		ctx.DeviceConfig().RecoverySnapshotVersion() != "current" {
+		ctx.DeviceConfig().RecoverySnapshotVersion() != "current" &&
+		ctx.DeviceConfig().ProductVndkVersion() != "current" &&
+		ctx.DeviceConfig().RamdiskSnapshotVersion() != "current" &&
+		ctx.DeviceConfig().VendorRamdiskSnapshotVersion() != "current" {
 		return false
 	}
 	if _, ok := isVndkSnapshotAware(ctx.DeviceConfig(), m, apexInfo); ok {
 		return ctx.Config().VndkSnapshotBuildArtifacts()
 	}
 
-	for _, image := range []snapshotImage{vendorSnapshotImageSingleton, recoverySnapshotImageSingleton} {
+	for _, image := range []snapshotImage{vendorSnapshotImageSingleton, vendorDlkmSnapshotImageSingleton, recoverySnapshotImageSingleton,
+		productSnapshotImageSingleton, ramdiskSnapshotImageSingleton, vendorRamdiskSnapshotImageSingleton} {
 		if isSnapshotAware(ctx.DeviceConfig(), m, image.isProprietaryPath(ctx.ModuleDir(), ctx.DeviceConfig()), apexInfo, image) {
 			return true
 		}
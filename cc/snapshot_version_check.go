@@ -0,0 +1,105 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("snapshot-version-check", SnapshotVersionCheckSingleton)
+}
+
+// snapshotVersionCheckSingleton warns about stale snapshot modules, if StrictSnapshotVersions is
+// set. A snapshot module is stale if its version is older than the target snapshot version of its
+// image, and there is no {IMAGE}_snapshot module of its version. Such a module is most likely
+// checked in by mistake along with the snapshot of the target version, and it is silently
+// disabled. Modules of older snapshots checked in as a whole are inactive, and they aren't
+// reported. The warnings are printed when the "snapshot-version-check" phony target is built,
+// which droidcore depends on.
+type snapshotVersionCheckSingleton struct{}
+
+func SnapshotVersionCheckSingleton() android.Singleton {
+	return &snapshotVersionCheckSingleton{}
+}
+
+func (s *snapshotVersionCheckSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	cfg := ctx.DeviceConfig()
+	if !cfg.StrictSnapshotVersions() {
+		return
+	}
+
+	// Versions of the {IMAGE}_snapshot modules, keyed by the module name suffix of the image, as
+	// vendor_dlkm snapshot modules are listed in the vendor_snapshot module.
+	snapshotVersions := make(map[string]map[string]bool)
+	var prebuilts []android.Module
+	ctx.VisitAllModules(func(module android.Module) {
+		if snap, ok := module.(*snapshot); ok {
+			suffix := snap.image.moduleNameSuffix()
+			if snapshotVersions[suffix] == nil {
+				snapshotVersions[suffix] = make(map[string]bool)
+			}
+			snapshotVersions[suffix][snap.baseSnapshot.version()] = true
+		} else if snapshotDecoratorOf(module) != nil {
+			prebuilts = append(prebuilts, module)
+		}
+	})
+
+	var warnings snapshotWarnings
+	for _, module := range prebuilts {
+		p := snapshotDecoratorOf(module)
+		if !p.image.isUsingSnapshot(cfg) || snapshotVersions[p.image.moduleNameSuffix()][p.version()] {
+			continue
+		}
+		if targetVersion := p.image.targetSnapshotVersion(cfg); isOlderSnapshotVersion(p.version(), targetVersion) {
+			warnings = append(warnings, fmt.Sprintf("snapshot module %q has version %q which is older than the target snapshot version %q",
+				ctx.ModuleName(module), p.version(), targetVersion))
+		}
+	}
+	warnings = android.FirstUniqueStrings(warnings)
+
+	stamp := android.PathForOutput(ctx, "snapshot-version-check", "check.stamp")
+	if len(warnings) > 0 {
+		stamp = snapshotWarningsRule(ctx, warnings, "snapshot-version-check", "stale")
+	} else {
+		ctx.Build(pctx, android.BuildParams{
+			Rule:   android.Touch,
+			Output: stamp,
+		})
+	}
+
+	ctx.Phony("snapshot-version-check", stamp)
+	ctx.Phony("droidcore", android.PathForPhony(ctx, "snapshot-version-check"))
+}
+
+// snapshotDecoratorOf returns the baseSnapshotDecorator of a snapshot prebuilt module, or nil if
+// module isn't one.
+func snapshotDecoratorOf(module android.Module) *baseSnapshotDecorator {
+	m, ok := module.(*Module)
+	if !ok {
+		return nil
+	}
+	switch p := m.linker.(type) {
+	case *snapshotLibraryDecorator:
+		return &p.baseSnapshotDecorator
+	case *snapshotBinaryDecorator:
+		return &p.baseSnapshotDecorator
+	case *snapshotObjectLinker:
+		return &p.baseSnapshotDecorator
+	}
+	return nil
+}
@@ -626,8 +626,13 @@ var PrepareForTestWithCcIncludeVndk = android.GroupFixturePreparers(
 	PrepareForIntegrationTestWithCc,
 	android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
 		vendorSnapshotImageSingleton.init(ctx)
+		vendorDlkmSnapshotImageSingleton.init(ctx)
 		recoverySnapshotImageSingleton.init(ctx)
+		productSnapshotImageSingleton.init(ctx)
+		ramdiskSnapshotImageSingleton.init(ctx)
+		vendorRamdiskSnapshotImageSingleton.init(ctx)
 		ctx.RegisterSingletonType("vndk-snapshot", VndkSnapshotSingleton)
+		ctx.RegisterSingletonType("snapshot-version-check", SnapshotVersionCheckSingleton)
 	}),
 )
 
@@ -675,8 +680,13 @@ func CreateTestContext(config android.Config) *android.TestContext {
 	ctx.RegisterModuleType("vndk_prebuilt_shared", VndkPrebuiltSharedFactory)
 
 	vendorSnapshotImageSingleton.init(ctx)
+	vendorDlkmSnapshotImageSingleton.init(ctx)
 	recoverySnapshotImageSingleton.init(ctx)
+	productSnapshotImageSingleton.init(ctx)
+	ramdiskSnapshotImageSingleton.init(ctx)
+	vendorRamdiskSnapshotImageSingleton.init(ctx)
 	ctx.RegisterSingletonType("vndk-snapshot", VndkSnapshotSingleton)
+	ctx.RegisterSingletonType("snapshot-version-check", SnapshotVersionCheckSingleton)
 	RegisterVndkLibraryTxtTypes(ctx)
 
 	ctx.PreArchMutators(android.RegisterDefaultsPreArchMutators)
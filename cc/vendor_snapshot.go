@@ -13,56 +13,161 @@
 // limitations under the License.
 package cc
 
-// This file contains singletons to capture vendor and recovery snapshot. They consist of prebuilt
-// modules under AOSP so older vendor and recovery can be built with a newer system in a single
-// source tree.
+// This file contains singletons to capture vendor, vendor_dlkm, product, recovery, ramdisk and
+// vendor_ramdisk snapshots. They consist of prebuilt modules under AOSP so older vendor, product,
+// recovery and ramdisk images can be built with a newer system in a single source tree.
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/google/blueprint"
+
 	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+var (
+	// Rule to list the object files contained in a static library.
+	snapshotArchiveMembers = pctx.AndroidStaticRule("snapshotArchiveMembers",
+		blueprint.RuleParams{
+			Command:     "${config.ClangBin}/llvm-ar t ${in} > ${out}",
+			CommandDeps: []string{"${config.ClangBin}/llvm-ar"},
+		})
 )
 
 var vendorSnapshotSingleton = snapshotSingleton{
 	"vendor",
 	"SOONG_VENDOR_SNAPSHOT_ZIP",
 	android.OptionalPath{},
+	nil,
+	android.OptionalPath{},
+	android.OptionalPath{},
 	true,
 	vendorSnapshotImageSingleton,
 	false, /* fake */
+	nil,
 }
 
 var vendorFakeSnapshotSingleton = snapshotSingleton{
 	"vendor",
 	"SOONG_VENDOR_FAKE_SNAPSHOT_ZIP",
 	android.OptionalPath{},
+	nil,
+	android.OptionalPath{},
+	android.OptionalPath{},
 	true,
 	vendorSnapshotImageSingleton,
 	true, /* fake */
+	nil,
+}
+
+var vendorDlkmSnapshotSingleton = snapshotSingleton{
+	"vendor_dlkm",
+	"SOONG_VENDOR_DLKM_SNAPSHOT_ZIP",
+	android.OptionalPath{},
+	nil,
+	android.OptionalPath{},
+	android.OptionalPath{},
+	false,
+	vendorDlkmSnapshotImageSingleton,
+	false, /* fake */
+	nil,
 }
 
 var recoverySnapshotSingleton = snapshotSingleton{
 	"recovery",
 	"SOONG_RECOVERY_SNAPSHOT_ZIP",
 	android.OptionalPath{},
+	nil,
+	android.OptionalPath{},
+	android.OptionalPath{},
 	false,
 	recoverySnapshotImageSingleton,
 	false, /* fake */
+	nil,
+}
+
+var productSnapshotSingleton = snapshotSingleton{
+	"product",
+	"SOONG_PRODUCT_SNAPSHOT_ZIP",
+	android.OptionalPath{},
+	nil,
+	android.OptionalPath{},
+	android.OptionalPath{},
+	false,
+	productSnapshotImageSingleton,
+	false, /* fake */
+	nil,
+}
+
+var ramdiskSnapshotSingleton = snapshotSingleton{
+	"ramdisk",
+	"SOONG_RAMDISK_SNAPSHOT_ZIP",
+	android.OptionalPath{},
+	nil,
+	android.OptionalPath{},
+	android.OptionalPath{},
+	false,
+	ramdiskSnapshotImageSingleton,
+	false, /* fake */
+	nil,
+}
+
+var vendorRamdiskSnapshotSingleton = snapshotSingleton{
+	"vendor_ramdisk",
+	"SOONG_VENDOR_RAMDISK_SNAPSHOT_ZIP",
+	android.OptionalPath{},
+	nil,
+	android.OptionalPath{},
+	android.OptionalPath{},
+	false,
+	vendorRamdiskSnapshotImageSingleton,
+	false, /* fake */
+	nil,
 }
 
+// The factories return copies of the templates above, so that state set by GenerateBuildActions,
+// e.g. the zip files or the captured modules, isn't shared between images or contexts.
+
 func VendorSnapshotSingleton() android.Singleton {
-	return &vendorSnapshotSingleton
+	s := vendorSnapshotSingleton
+	return &s
 }
 
 func VendorFakeSnapshotSingleton() android.Singleton {
-	return &vendorFakeSnapshotSingleton
+	s := vendorFakeSnapshotSingleton
+	return &s
+}
+
+func VendorDlkmSnapshotSingleton() android.Singleton {
+	s := vendorDlkmSnapshotSingleton
+	return &s
 }
 
 func RecoverySnapshotSingleton() android.Singleton {
-	return &recoverySnapshotSingleton
+	s := recoverySnapshotSingleton
+	return &s
+}
+
+func ProductSnapshotSingleton() android.Singleton {
+	s := productSnapshotSingleton
+	return &s
+}
+
+func RamdiskSnapshotSingleton() android.Singleton {
+	s := ramdiskSnapshotSingleton
+	return &s
+}
+
+func VendorRamdiskSnapshotSingleton() android.Singleton {
+	s := vendorRamdiskSnapshotSingleton
+	return &s
 }
 
 type snapshotSingleton struct {
@@ -76,6 +181,19 @@ type snapshotSingleton struct {
 	// Path to the snapshot zip file.
 	snapshotZipFile android.OptionalPath
 
+	// Paths to the zip files of each type of captured files, keyed by the types in
+	// snapshotZipTypes, which are built instead of the snapshot zip file if SplitSnapshotZips is
+	// set.
+	splitZipFiles map[string]android.Path
+
+	// Path to the zip file of unstripped binaries and shared libraries, which is built alongside
+	// the snapshot zip file if BuildSnapshotSymbols is set.
+	symbolsZipFile android.OptionalPath
+
+	// Path to the file with the total uncompressed size of the captured files in bytes, which is
+	// exported to Make for partition size checks.
+	totalSizeFile android.OptionalPath
+
 	// Whether the image supports VNDK extension modules.
 	supportsVndkExt bool
 
@@ -88,6 +206,27 @@ type snapshotSingleton struct {
 	// Fake snapshot is a snapshot whose prebuilt binaries and headers are empty.
 	// It is much faster to generate, and can be used to inspect dependencies.
 	fake bool
+
+	// Modules captured by the last GenerateBuildActions, for tests.
+	capturedModules []CapturedSnapshotModule
+}
+
+// CapturedSnapshotModule describes a module captured to a snapshot.
+type CapturedSnapshotModule struct {
+	// Name of the module, including the suffix of sanitizer variants, e.g. "libfoo.cfi".
+	Name string
+
+	// Type of the captured module: "shared", "static", "header", "stubs", "binary" or "object".
+	Type string
+
+	// Arch directory the module is captured to, e.g. "arch-arm64-armv8-a".
+	Arch string
+}
+
+// CapturedModules returns the modules captured to the snapshot, sorted by name, type and arch.
+// This is for tests asserting which modules are captured without inspecting the build outputs.
+func (c *snapshotSingleton) CapturedModules() []CapturedSnapshotModule {
+	return c.capturedModules
 }
 
 // Determine if a dir under source tree is an SoC-owned proprietary directory based
@@ -148,7 +287,12 @@ func isRecoveryProprietaryModule(ctx android.BaseModuleContext) bool {
 
 // Determines if the module is a candidate for snapshot.
 func isSnapshotAware(cfg android.DeviceConfig, m LinkableInterface, inProprietaryPath bool, apexInfo android.ApexInfo, image snapshotImage) bool {
-	if !m.Enabled() || m.HiddenFromMake() {
+	if !m.Enabled() {
+		return false
+	}
+	// Stubs variants of libraries with stubs are hidden from make, but they are captured so that
+	// consumers can link against the stable API surface of the libraries.
+	if m.HiddenFromMake() && m.SnapshotStubsVersion() == "" {
 		return false
 	}
 	// When android/prebuilt.go selects between source and prebuilt, it sets
@@ -166,6 +310,10 @@ func isSnapshotAware(cfg android.DeviceConfig, m LinkableInterface, inProprietar
 	if image.excludeFromSnapshot(m) {
 		return false
 	}
+	// Modules may also be excluded for single arches only.
+	if m.ExcludeFromArchSnapshot() {
+		return false
+	}
 	if m.Target().Os.Class != android.Device {
 		return false
 	}
@@ -209,6 +357,19 @@ func isSnapshotAware(cfg android.DeviceConfig, m LinkableInterface, inProprietar
 			if !sanitizable.OutputFile().Valid() {
 				return false
 			}
+			// Stubs variants are never installed.
+			if sanitizable.SnapshotStubsVersion() != "" {
+				return true
+			}
+			// Shared libraries which are built but not installed to the image, e.g. staging
+			// only libraries, aren't used on the device and would only pollute the snapshot.
+			// Libraries with installable: false may still be needed as link-time dependencies,
+			// and are optionally captured.
+			if !installable(sanitizable, apexInfo) || sanitizable.IsSkipInstall() {
+				if !cfg.SnapshotNonInstallableLibs() || !isNonInstallable(sanitizable) {
+					return false
+				}
+			}
 			if image.includeVndk() {
 				if !sanitizable.IsVndk() {
 					return true
@@ -227,26 +388,183 @@ func isSnapshotAware(cfg android.DeviceConfig, m LinkableInterface, inProprietar
 	return false
 }
 
+// snapshotInstallPath returns the path a shared library or a binary described by prop is installed
+// to on the device, e.g. "vendor/lib64/hw/foo.so". The partition defaults to the image. The file
+// name is the stem of the output file, which differs from the module name if stem is set.
+func snapshotInstallPath(image string, prop snapshotJsonFlags, m LinkableInterface) string {
+	partition := prop.Partition
+	if partition == "" {
+		partition = image
+	}
+	dir := "bin"
+	if m.Shared() {
+		dir = "lib"
+		if m.Target().Arch.ArchType.Multilib == "lib64" {
+			dir = "lib64"
+		}
+	}
+	return filepath.Join(partition, dir, prop.RelativeInstallPath, m.OutputFile().Path().Base())
+}
+
+// inSnapshotDirs returns true if dir is one of dirs or under one of them.
+func inSnapshotDirs(dir string, dirs []string) bool {
+	for _, d := range dirs {
+		d = strings.TrimSuffix(d, "/")
+		if dir == d || strings.HasPrefix(dir, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isNonInstallable returns true if m is explicitly marked installable: false.
+func isNonInstallable(m LinkableInterface) bool {
+	return m.Installable() != nil && !*m.Installable()
+}
+
+// snapshotArchDirName returns the name of the directory in which modules for the target are
+// captured, e.g. "arch-arm64-armv8-a".
+func snapshotArchDirName(target android.Target) string {
+	name := "arch-" + target.Arch.ArchType.String()
+	if target.Arch.ArchVariant != "" {
+		name += "-" + target.Arch.ArchVariant
+	}
+	return name
+}
+
+// snapshotSanitizerNames returns the names of the sanitizers of a sanitized variant of a static
+// library, e.g. ["cfi", "intoverflow"], or nil if the variant is not sanitized. Only cfi creates
+// sanitized variants of static libraries captured to the snapshot, but other sanitizers can be
+// combined with it in the same variant.
+func snapshotSanitizerNames(m PlatformSanitizeable) []string {
+	if !m.Static() || !m.SanitizePropDefined() || !m.IsSanitizerEnabled(cfi) {
+		return nil
+	}
+	ret := []string{"cfi"}
+	if m.IsSanitizerEnabled(intOverflow) {
+		ret = append(ret, "intoverflow")
+	}
+	return ret
+}
+
+// snapshotSanitizerRuntimeLibs returns the names of the sanitizer runtime libraries a static
+// library needs to be linked with: the minimal UBSan runtime archive, which sanitize.flags links
+// by path, and the UBSan runtime shared library.
+func snapshotSanitizerRuntimeLibs(target android.Target, minimalDep, ubsanDep bool) []string {
+	var ret []string
+	toolchain := config.FindToolchain(target.Os, target.Arch)
+	if minimalDep {
+		ret = append(ret, config.UndefinedBehaviorSanitizerMinimalRuntimeLibrary(toolchain)+".a")
+	}
+	if ubsanDep {
+		ret = append(ret, config.UndefinedBehaviorSanitizerRuntimeLibrary(toolchain))
+	}
+	return ret
+}
+
 // This is to be saved as .json files, which is for development/vendor_snapshot/update.py.
 // These flags become Android.bp snapshot module properties.
 type snapshotJsonFlags struct {
-	ModuleName          string `json:",omitempty"`
+	ModuleName          string
 	RelativeInstallPath string `json:",omitempty"`
+	VndkExtends         string `json:",omitempty"`
+	Partition           string `json:",omitempty"`
+	ImageVariant        string `json:",omitempty"`
+	Experimental        bool   `json:",omitempty"`
+	ProductVariant      string `json:",omitempty"`
+	NoticeFile          string `json:",omitempty"`
+	FrozenApiLevel      string `json:",omitempty"`
+	Prerelease          bool   `json:",omitempty"`
+	SdkMember           bool   `json:",omitempty"`
+
+	// all the images the module is available to, if more than one, e.g. ["core", "vendor",
+	// "recovery"] for a module which is also vendor_available and recovery_available
+	AvailableImages []string `json:",omitempty"`
+
+	// whether the module supports native bridge translation, even though the native bridge
+	// variants aren't captured
+	NativeBridgeSupported bool `json:",omitempty"`
+
+	// arbitrary metadata from snapshot_metadata
+	Metadata map[string]string `json:",omitempty"`
 
 	// library flags
 	ExportedDirs       []string `json:",omitempty"`
 	ExportedSystemDirs []string `json:",omitempty"`
 	ExportedFlags      []string `json:",omitempty"`
-	Sanitize           string   `json:",omitempty"`
+	AbiGatingDefines   []string `json:",omitempty"`
+	Suffix             string   `json:",omitempty"`
+	Sanitize           []string `json:",omitempty"`
 	SanitizeMinimalDep bool     `json:",omitempty"`
 	SanitizeUbsanDep   bool     `json:",omitempty"`
 
+	// sanitizer runtime libraries to link with static libs, e.g. libclang_rt.ubsan_minimal
+	SanitizeRuntimeLibs []string `json:",omitempty"`
+
+	CfiAssemblySupport bool     `json:",omitempty"`
+	CfiExportsMap      string   `json:",omitempty"`
+	CfiDiag            bool     `json:",omitempty"`
+	VersionScript      string   `json:",omitempty"`
+	AbiRelevantFlags   []string `json:",omitempty"`
+	CppFlags           []string `json:",omitempty"`
+	ConlyFlags         []string `json:",omitempty"`
+	Visibility         string   `json:",omitempty"`
+	SdkVersion         string   `json:",omitempty"`
+	MinSdkVersion      string   `json:",omitempty"`
+	StubsVersion       string   `json:",omitempty"`
+	VendorPublic       bool     `json:",omitempty"`
+	Rust               bool     `json:",omitempty"`
+	Installable        *bool    `json:",omitempty"`
+	SymbolFile         string   `json:",omitempty"`
+	StubsSymbolFile    string   `json:",omitempty"`
+	ProfileData        string   `json:",omitempty"`
+	AfdoProfile        string   `json:",omitempty"`
+	LinkFlags          []string `json:",omitempty"`
+
+	// unstripped shared library next to the stripped one, if enabled
+	UnstrippedSharedLibrary string `json:",omitempty"`
+
+	// table of contents next to the shared library, if enabled
+	Toc string `json:",omitempty"`
+
 	// binary flags
-	Symlinks []string `json:",omitempty"`
+	Symlinks        []string `json:",omitempty"`
+	CompileMultilib string   `json:",omitempty"`
+
+	// arm32 ABI flags of binaries and shared libraries
+	FloatAbi       string `json:",omitempty"`
+	InstructionSet string `json:",omitempty"`
+
+	// position independence of libraries and binaries
+	Pic bool `json:",omitempty"`
+	Pie bool `json:",omitempty"`
+
+	// STL of libraries and binaries, e.g. "libc++" or "libc++_static", and whether the STL is
+	// statically bundled into shared libraries and binaries
+	Stl        string `json:",omitempty"`
+	BundledStl bool   `json:",omitempty"`
+
+	// runpaths of binaries and shared libraries
+	Runpaths []string `json:",omitempty"`
+
+	// whether binaries and shared libraries are linked without crtbegin/crtend (nocrt), or
+	// without libclang_rt.builtins (no_libcrt)
+	Nocrt    bool `json:",omitempty"`
+	NoLibcrt bool `json:",omitempty"`
+
+	// paired kernel modules, relative to the arch directory of the snapshot
+	KernelModules []string `json:",omitempty"`
+
+	// runtime data files of binaries, relative to the binary directory
+	Data []string `json:",omitempty"`
 
 	// dependencies
 	SharedLibs  []string `json:",omitempty"`
+	StaticLibs  []string `json:",omitempty"`
+	HeaderLibs  []string `json:",omitempty"`
+	NeededLibs  []string `json:",omitempty"`
 	RuntimeLibs []string `json:",omitempty"`
+	DlopenLibs  []string `json:",omitempty"`
 	Required    []string `json:",omitempty"`
 
 	// extra config files
@@ -255,6 +573,17 @@ type snapshotJsonFlags struct {
 }
 
 func (c *snapshotSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	c.capturedModules = nil
+	c.snapshotZipFile = android.OptionalPath{}
+	c.symbolsZipFile = android.OptionalPath{}
+	c.splitZipFiles = nil
+	c.totalSizeFile = android.OptionalPath{}
+
+	// BoardSnapshotProfiles, if set, selects which snapshot images are generated.
+	if profiles := ctx.DeviceConfig().BoardSnapshotProfiles(); len(profiles) > 0 && !android.InList(c.name, profiles) {
+		return
+	}
+
 	if !c.image.shouldGenerateSnapshot(ctx) {
 		return
 	}
@@ -271,10 +600,22 @@ func (c *snapshotSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 					(.a static libraries)
 				header/
 					(header only libraries)
+				stubs/
+					(stubs variants of libraries with stubs, e.g. 29/libfoo.so,
+					with their symbol files, e.g. 29/libfoo.map.txt)
 				binary/
-					(executable binaries)
+					(executable binaries, and their runtime data files from
+					snapshot_data under data/)
 				object/
 					(.o object files)
+				version-scripts/
+					(version scripts shared libraries are linked with, under
+					{MODULE}/)
+				sanitize-blocklists/
+					(sanitizer blocklists sanitized modules are compiled with,
+					under {MODULE}/, referenced by {ARTIFACT}.sanitize.json)
+				crt.json
+					(CRT objects keyed by their roles, e.g. crtbegin_so)
 			arch-{TARGET_2ND_ARCH}-{TARGET_2ND_ARCH_VARIANT}/
 				shared/
 					(.so shared libraries)
@@ -286,12 +627,67 @@ func (c *snapshotSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 					(executable binaries)
 				object/
 					(.o object files)
+			{PARTITION}/
+				(modules installed to a partition other than the default one of
+				the image, e.g. odm/, with the same arch-* structure as above)
+			experimental/
+				(modules with snapshot_experimental: true, with the same arch-*
+				and {PARTITION}/ structure as above)
+			product-variants/
+				{PRODUCT_VARIANT}/
+					(modules listed in SnapshotProductVariantModules, whose content
+					depends on the product configuration, with the same arch-* and
+					{PARTITION}/ structure as above)
+			sbom.spdx.json
+				(SPDX SBOM describing all captured files, if enabled)
+			public.libraries.txt
+				(vendor public libraries, which apps can dlopen)
+			FORCED_VERSION
+				(synthetic version of a snapshot forced to be generated for testing)
+			Android.bp
+				(snapshot prebuilt modules of the captured modules, if enabled)
+			manifest.json
+				(image and API level the snapshot is frozen against, whether it is
+				a pre-release snapshot, and the repo manifest revision it is
+				captured from)
 			NOTICE_FILES/
-				(notice files, e.g. libbase.txt)
+				.by-hash/
+					(combined notice files shared by modules, named after the hash of
+					their content, e.g. 0123abcd.txt)
+				(named NOTICE_FILES_{IMAGE}/, e.g. NOTICE_FILES_vendor/, if
+				PerImageSnapshotNoticeDirs is set, so that snapshots of several images
+				can be unpacked into the same directory)
 			configs/
 				(config files, e.g. init.rc files, vintf_fragments.xml files, etc.)
+			linkerconfig/
+				(linker configuration fragments, e.g. ld.config.*.txt or
+				public.libraries-*.txt files, listed in manifest.json, if declared
+				with BoardVendorSnapshotLinkerConfigs)
+			kernel-modules/
+				(prebuilt kernel modules paired with modules, e.g. foo.ko, if enabled)
+			pgo/
+				(PGO profiles of libraries, e.g. libfoo.profdata, if enabled)
+			afdo/
+				(AFDO profiles of libraries, e.g. libfoo.afdo, if enabled)
+			vndk/
+				(vndk.json listing the libraries installed to the VNDK APEX
+				directories)
+			llndk/
+				(symbol files and versions of LLNDK libraries, described by
+				llndk.json, if enabled)
+			symbol-files/
+				(stubs.symbol_file of libraries with stubs, defining their stable
+				API, under {MODULE}/)
+			cfi-exports/
+				(CFI exports maps CFI shared libraries are linked with)
 			include/
 				(header files of same directory structure with source tree)
+				(named include_{IMAGE}/, e.g. include_vendor/, if
+				PerImageSnapshotIncludeDirs is set, so that snapshots of several images
+				can be unpacked into the same directory)
+				generated/
+					(generated headers, e.g. AIDL or proto headers, under
+					{MODULE}/arch-{TARGET_ARCH}-{TARGET_ARCH_VARIANT}/{LIBTYPE}/)
 	*/
 
 	snapshotDir := c.name + "-snapshot"
@@ -302,39 +698,248 @@ func (c *snapshotSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 	}
 	snapshotArchDir := filepath.Join(snapshotDir, ctx.DeviceConfig().DeviceArch())
 
-	includeDir := filepath.Join(snapshotArchDir, "include")
+	// A snapshot forced to be generated for testing is labeled with its synthetic version, so that
+	// it can't be mistaken for a production snapshot.
+	frozenApiLevel := ctx.DeviceConfig().PlatformVndkVersion()
+	if version := c.image.forcedSnapshotVersion(ctx.DeviceConfig()); version != "" {
+		snapshotOutputs = append(snapshotOutputs,
+			writeStringToFileRule(ctx, version, filepath.Join(snapshotArchDir, "FORCED_VERSION")))
+		frozenApiLevel = version
+	}
+
+	// Snapshots of an unfrozen vendor interface, e.g. nightly development snapshots, are marked as
+	// pre-release so that they aren't shipped against by accident.
+	prerelease := ctx.DeviceConfig().SnapshotPrerelease()
+
+	// The repo manifest revision the sources are checked out at ties the snapshot to a precise
+	// source state. A snapshot meant to reproduce a tagged build must be captured from sources at
+	// the pinned revision.
+	manifestRevision := ctx.DeviceConfig().SnapshotManifestRevision()
+	if pinned := ctx.DeviceConfig().SnapshotPinnedManifestRevision(); pinned != "" && pinned != manifestRevision {
+		ctx.Errorf("the %s snapshot is pinned to manifest revision %q, but the sources are at %q",
+			c.name, pinned, manifestRevision)
+		return
+	}
+
+	// The vendor snapshot may be restricted to some of the built arches, e.g. for a delivery which
+	// doesn't support all of them. When unset, all the arches are captured.
+	var capturedArchTypes []string
+	if c.name == "vendor" {
+		capturedArchTypes = ctx.DeviceConfig().BoardVendorSnapshotArches()
+		var builtArchTypes []string
+		for _, arch := range ctx.DeviceConfig().Arches() {
+			builtArchTypes = append(builtArchTypes, arch.ArchType.String())
+		}
+		for _, archType := range capturedArchTypes {
+			if !android.InList(archType, builtArchTypes) {
+				ctx.Errorf("arch %q of the %s snapshot isn't built, built arches are %q",
+					archType, c.name, builtArchTypes)
+				return
+			}
+		}
+	}
+	archCaptured := func(target android.Target) bool {
+		return len(capturedArchTypes) == 0 || android.InList(target.Arch.ArchType.String(), capturedArchTypes)
+	}
+
+	// A headers-only vendor snapshot is for consumers which only compile against the API. It
+	// captures the headers and the json flag files, but none of the prebuilt binaries.
+	headersOnly := c.name == "vendor" && ctx.DeviceConfig().BoardVendorSnapshotHeadersOnly()
+
+	// The Android.bp file declaring the snapshot prebuilt modules is optionally generated, for
+	// consumers without a generator of their own. Only the images whose snapshot prebuilt modules
+	// are marked with an image property, e.g. "recovery: true", have it, and only real snapshots
+	// with prebuilts. vendor_dlkm snapshot modules are marked with "vendor: true".
+	buildBlueprints := ctx.DeviceConfig().BuildSnapshotBlueprints() && !c.fake && !headersOnly &&
+		android.InList(c.name, []string{"vendor", "vendor_dlkm", "recovery", "ramdisk", "vendor_ramdisk"})
+	blueprintModules := make(map[string]*snapshotBlueprintModule)
+
+	// Unstripped binaries and shared libraries are optionally captured to a separate directory
+	// with the same structure, which is zipped separately. Fake snapshots don't have them.
+	buildSymbols := ctx.DeviceConfig().BuildSnapshotSymbols() && !c.fake && !headersOnly
+	symbolsDir := snapshotDir + "-symbols"
+	symbolsArchDir := filepath.Join(symbolsDir, ctx.DeviceConfig().DeviceArch())
+	var symbolsOutputs android.Paths
+
+	// captureSymbols copies the unstripped file of m to the symbols directory, at the same
+	// relative path as out in the snapshot directory.
+	captureSymbols := func(m LinkableInterface, out string, fake bool) {
+		if !buildSymbols || fake || m.UnstrippedOutputFile() == nil {
+			return
+		}
+		rel, err := filepath.Rel(snapshotArchDir, out)
+		if err != nil {
+			ctx.Errorf("%q is not under %q: %s", out, snapshotArchDir, err)
+			return
+		}
+		symbolsOutputs = append(symbolsOutputs,
+			copyFileRule(ctx, m.UnstrippedOutputFile(), filepath.Join(symbolsArchDir, rel)))
+	}
+
+	includeDirName := "include"
+	if ctx.DeviceConfig().PerImageSnapshotIncludeDirs() {
+		includeDirName += "_" + c.name
+	}
+	includeDir := filepath.Join(snapshotArchDir, includeDirName)
 	configsDir := filepath.Join(snapshotArchDir, "configs")
-	noticeDir := filepath.Join(snapshotArchDir, "NOTICE_FILES")
+	pgoDir := filepath.Join(snapshotArchDir, "pgo")
+	afdoDir := filepath.Join(snapshotArchDir, "afdo")
+	kernelModulesDir := filepath.Join(snapshotArchDir, "kernel-modules")
+	noticeDir := "NOTICE_FILES"
+	if ctx.DeviceConfig().PerImageSnapshotNoticeDirs() {
+		noticeDir += "_" + c.name
+	}
 
 	installedNotices := make(map[string]bool)
-	installedConfigs := make(map[string]bool)
+	installedGeneratedHeaders := make(map[string]bool)
+	capturedArches := make(map[string]bool)
+
+	// Arch directories each captured module is captured for, keyed by module names.
+	moduleArches := make(map[string][]string)
+
+	// Captured modules keyed by the paths they are installed to on the device, to detect modules
+	// which would overwrite each other.
+	installPaths := make(map[string]string)
+
+	// Exported include directories of captured libraries, keyed by their json flag files. Each of
+	// them should contain at least one captured header.
+	exportedDirs := make(map[string][]string)
+
+	// Library dependencies of captured modules, and the libraries satisfying them, keyed by
+	// "{NAME}/{ARCH_DIR}". Each dependency should be captured as well, or provided by the consuming
+	// image.
+	type snapshotDeps struct {
+		propOut  string
+		arch     string
+		libs     []string
+		required []string
+	}
+	var capturedDeps []snapshotDeps
+	providedLibs := make(map[string]bool)
+	// Modules other than libraries which remain in the consuming tree, and satisfy the required
+	// modules of captured modules.
+	providedModules := make(map[string]bool)
+
+	// Names of the modules the snapshot outputs are captured from, for diagnostics and the SBOM,
+	// and the license kinds of the modules.
+	outputModules := make(map[string]string)
+	moduleLicenseKinds := make(map[string][]string)
+
+	// Names of the captured modules, without the suffixes of sanitizer variants, e.g. "libfoo" for
+	// both libfoo.so and libfoo.cfi.a. The allowlist lists these names.
+	capturedModuleNames := make(map[string]bool)
+
+	// Generated headers live in the out directory, under a path depending on the variant of the
+	// module, e.g. out/soong/.intermediates/{DIR}/{MODULE}/{VARIANT}/gen/aidl/IFoo.h. Such a path is
+	// mapped to the given directory in the snapshot, e.g. {DIR}/aidl/IFoo.h, so that its location
+	// is stable.
+	outDir := android.PathForOutput(ctx).String() + "/"
+	generatedHeaderPath := func(path android.Path, dir string) (string, bool) {
+		p := path.String()
+		if !strings.HasPrefix(p, outDir) {
+			return "", false
+		}
+		i := strings.Index(p+"/", "/gen/")
+		if i < 0 {
+			return "", false
+		}
+		return filepath.Join(dir, strings.TrimPrefix(p[i:], "/gen")), true
+	}
+
+	// File names of captured vendor public libraries.
+	var publicLibraries []string
+
+	// CRT objects keyed by their roles, e.g. crtbegin_so, for each captured arch directory.
+	crtObjects := make(map[string]map[string]string)
+
+	// Captured libraries installed to the VNDK APEX directories, keyed by name.
+	vndkLibraries := make(map[string]*snapshotVndkLibrary)
+
+	// LLNDK libraries the vendor variants link against, keyed by name, if the LLNDK surface is
+	// captured to the vendor snapshot.
+	buildLlndk := c.image.includeVndk() && ctx.DeviceConfig().BuildSnapshotLlndk()
+	llndkLibraries := make(map[string]*snapshotLlndkLibrary)
+	llndkDir := filepath.Join(snapshotArchDir, "llndk")
+
+	// Captured library files to the modules they are captured from, to detect name collisions
+	// caused by snapshot_suffix.
+	installedLibs := make(map[string]string)
 
 	var headers android.Paths
 
-	copyFile := func(ctx android.SingletonContext, path android.Path, out string, fake bool) android.OutputPath {
+	// Members of SDKs, which are distributed with SDK snapshots. Only the core variants of SDK
+	// members are marked as such, so the members are collected by name.
+	sdkMembers := make(map[string]bool)
+	ctx.VisitAllModules(func(module android.Module) {
+		if s, ok := module.(android.SdkAware); ok && s.IsInAnySdk() && s.ContainingSdk().Unversioned() {
+			sdkMembers[ctx.ModuleName(module)] = true
+		}
+	})
+
+	// Snapshot destinations to the files copied to them. Each destination is copied only once,
+	// e.g. a config file shared by several modules. Different files copied to the same destination
+	// are collected in copyConflicts, and are checked to have the same content when the snapshot is
+	// built.
+	installedFiles := make(map[string]android.Path)
+	copyConflicts := make(map[string]android.Paths)
+
+	// copyFile returns the copied file, or nothing if out has already been copied.
+	copyFile := func(ctx android.SingletonContext, path android.Path, out string, fake bool) android.Paths {
+		if src, exists := installedFiles[out]; exists {
+			if src.String() != path.String() {
+				if len(copyConflicts[out]) == 0 {
+					copyConflicts[out] = android.Paths{src}
+				}
+				copyConflicts[out] = android.FirstUniquePaths(append(copyConflicts[out], path))
+			}
+			return nil
+		}
+		installedFiles[out] = path
+
 		if fake {
 			// All prebuilt binaries and headers are installed by copyFile function. This makes a fake
 			// snapshot just touch prebuilts and headers, rather than installing real files.
-			return writeStringToFileRule(ctx, "", out)
+			return android.Paths{writeStringToFileRule(ctx, "", out)}
 		} else {
-			return copyFileRule(ctx, path, out)
+			return android.Paths{copyFileRule(ctx, path, out)}
 		}
 	}
 
 	// installSnapshot function copies prebuilt file (.so, .a, or executable) and json flag file.
 	// For executables, init_rc and vintf_fragments files are also copied.
 	installSnapshot := func(m LinkableInterface, fake bool) android.Paths {
-		targetArch := "arch-" + m.Target().Arch.ArchType.String()
-		if m.Target().Arch.ArchVariant != "" {
-			targetArch += "-" + m.Target().Arch.ArchVariant
-		}
+		targetArch := snapshotArchDirName(m.Target())
+		capturedArches[targetArch] = true
+		moduleArches[ctx.ModuleName(m)] = append(moduleArches[ctx.ModuleName(m)], targetArch)
 
 		var ret android.Paths
 
 		prop := snapshotJsonFlags{}
 
+		// Modules installed to a partition other than the default one of the image are placed
+		// under a subtree named after the partition.
+		prop.Partition = c.image.partition(ctx.DeviceConfig(), m)
+		baseDir := snapshotArchDir
+
+		// Experimental modules are placed under a separate subtree, so that consumers can opt in
+		// to them separately.
+		if m.SnapshotExperimental() {
+			prop.Experimental = true
+			baseDir = filepath.Join(baseDir, "experimental")
+		}
+
+		// Modules whose content depends on the product configuration are placed under a subtree
+		// named after the product variant, so that snapshots of several products don't collide.
+		if variant := ctx.DeviceConfig().SnapshotProductVariant(); variant != "" &&
+			android.InList(ctx.ModuleName(m), ctx.DeviceConfig().SnapshotProductVariantModules()) {
+			prop.ProductVariant = variant
+			baseDir = filepath.Join(baseDir, "product-variants", variant)
+		}
+		targetArchDir := filepath.Join(baseDir, prop.Partition, targetArch)
+
 		// Common properties among snapshots.
 		prop.ModuleName = ctx.ModuleName(m)
+		prop.NativeBridgeSupported = m.IsNativeBridgeSupported()
 		if c.supportsVndkExt && m.IsVndkExt() {
 			// vndk exts are installed to /vendor/lib(64)?/vndk(-sp)?
 			if m.IsVndkSp() {
@@ -342,24 +947,63 @@ func (c *snapshotSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 			} else {
 				prop.RelativeInstallPath = "vndk"
 			}
+			prop.VndkExtends = m.SnapshotVndkExtends()
 		} else {
+			// Other images, e.g. recovery, install the variants of VNDK libraries like any other
+			// library.
 			prop.RelativeInstallPath = m.RelativeInstallPath()
 		}
+		metadata, err := parseSnapshotMetadata(m.SnapshotMetadata())
+		if err != nil {
+			ctx.Errorf("module %q %s", prop.ModuleName, err)
+			return nil
+		}
+		prop.Metadata = metadata
+		prop.FrozenApiLevel = frozenApiLevel
+		prop.Prerelease = prerelease
+		// The captured variant tells apart artifacts of modules available to several images when
+		// multiple snapshots are unpacked into one tree.
+		prop.ImageVariant = c.name
+		// Tools consuming several image snapshots can tell which images a module is available to
+		// without cross-referencing the snapshots.
+		if images := m.SnapshotAvailableImages(); len(images) > 1 {
+			prop.AvailableImages = images
+		}
+		prop.SdkMember = sdkMembers[ctx.ModuleName(m)]
 		prop.RuntimeLibs = m.SnapshotRuntimeLibs()
+		prop.DlopenLibs = m.SnapshotDlopenLibs()
 		prop.Required = m.RequiredModuleNames()
-		for _, path := range m.InitRc() {
-			prop.InitRc = append(prop.InitRc, filepath.Join("configs", path.Base()))
+		if !headersOnly {
+			for _, path := range m.InitRc() {
+				prop.InitRc = append(prop.InitRc, filepath.Join("configs", path.Base()))
+			}
+			for _, path := range m.VintfFragments() {
+				prop.VintfFragments = append(prop.VintfFragments, filepath.Join("configs", path.Base()))
+			}
+
+			// install config files. copyFile ignores any duplicates.
+			for _, path := range append(m.InitRc(), m.VintfFragments()...) {
+				ret = append(ret, copyFile(ctx, path, filepath.Join(configsDir, path.Base()), fake)...)
+			}
 		}
-		for _, path := range m.VintfFragments() {
-			prop.VintfFragments = append(prop.VintfFragments, filepath.Join("configs", path.Base()))
+
+		// install paired kernel modules. copyFile ignores any duplicates.
+		if ctx.DeviceConfig().BuildSnapshotKernelModules() && !headersOnly {
+			for _, path := range m.SnapshotKernelModules() {
+				prop.KernelModules = append(prop.KernelModules, filepath.Join("kernel-modules", path.Base()))
+				ret = append(ret, copyFile(ctx, path, filepath.Join(kernelModulesDir, path.Base()), fake)...)
+			}
 		}
 
-		// install config files. ignores any duplicates.
-		for _, path := range append(m.InitRc(), m.VintfFragments()...) {
-			out := filepath.Join(configsDir, path.Base())
-			if !installedConfigs[out] {
-				installedConfigs[out] = true
-				ret = append(ret, copyFile(ctx, path, out, fake))
+		// install the combined notice file. Many modules have the same notices, so the combined
+		// notice is stored once, named after the hash of the paths of its sources.
+		if len(m.NoticeFiles()) > 0 {
+			prop.NoticeFile = filepath.Join(noticeDir, ".by-hash", noticeHash(m.NoticeFiles())+".txt")
+			noticeOut := filepath.Join(snapshotArchDir, prop.NoticeFile)
+			// skip already combined notice file
+			if !installedNotices[noticeOut] {
+				installedNotices[noticeOut] = true
+				ret = append(ret, combineNoticesRule(ctx, m.NoticeFiles(), noticeOut))
 			}
 		}
 
@@ -368,95 +1012,434 @@ func (c *snapshotSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 		if m.IsSnapshotLibrary() {
 			exporterInfo := ctx.ModuleProvider(m.Module(), FlagExporterInfoProvider).(FlagExporterInfo)
 
+			var libType string
+			if m.Static() {
+				libType = "static"
+			} else if m.Shared() {
+				libType = "shared"
+			} else {
+				libType = "header"
+			}
+
+			// Generated headers, e.g. AIDL or proto headers, are captured under a stable
+			// directory, and the exported directories containing them are rewritten accordingly.
+			generatedDir := filepath.Join(includeDirName, "generated", ctx.ModuleName(m), targetArch, libType)
+			exportedDir := func(dir android.Path) string {
+				if out, ok := generatedHeaderPath(dir, generatedDir); ok {
+					return out
+				}
+				return filepath.Join(includeDirName, dir.String())
+			}
+			for _, header := range m.SnapshotHeaders() {
+				if out, ok := generatedHeaderPath(header, generatedDir); ok {
+					out = filepath.Join(snapshotArchDir, out)
+					installedGeneratedHeaders[out] = true
+					ret = append(ret, copyFile(ctx, header, out, fake)...)
+				}
+			}
+
 			// library flags
+			prop.Rust = m.SnapshotRust()
 			prop.ExportedFlags = exporterInfo.Flags
-			for _, dir := range exporterInfo.IncludeDirs {
-				prop.ExportedDirs = append(prop.ExportedDirs, filepath.Join("include", dir.String()))
-			}
-			for _, dir := range exporterInfo.SystemIncludeDirs {
-				prop.ExportedSystemDirs = append(prop.ExportedSystemDirs, filepath.Join("include", dir.String()))
+			prop.AbiGatingDefines = filterAbiGatingDefines(exporterInfo.Flags)
+			// libraries shipped without headers export no include directories
+			if m.SnapshotExportHeaders() {
+				for _, dir := range exporterInfo.IncludeDirs {
+					prop.ExportedDirs = append(prop.ExportedDirs, exportedDir(dir))
+				}
+				for _, dir := range exporterInfo.SystemIncludeDirs {
+					prop.ExportedSystemDirs = append(prop.ExportedSystemDirs, exportedDir(dir))
+				}
 			}
 
 			// shared libs dependencies aren't meaningful on static or header libs
 			if m.Shared() {
 				prop.SharedLibs = m.SnapshotSharedLibs()
+				prop.NeededLibs = m.SnapshotNeededLibs()
+				// link-time only dependencies aren't installed by the snapshot prebuilts either
+				if isNonInstallable(m) {
+					prop.Installable = BoolPtr(false)
+				}
+			}
+			// static libs dependencies are linked in everything but static libs
+			if m.Static() {
+				prop.StaticLibs = m.SnapshotStaticLibs()
+			}
+			// headers of header libs dependencies are needed to compile against static and
+			// shared libs, and header libs may reexport them
+			if m.Static() || m.Shared() || m.Header() {
+				prop.HeaderLibs = m.SnapshotHeaderLibs()
+			}
+			// compiler flags affecting ABI are only meaningful on static libs. The language
+			// specific ones are recorded separately as well, for consumers compiling both C and
+			// C++ against them.
+			if m.Static() {
+				prop.AbiRelevantFlags = m.SnapshotAbiRelevantFlags()
+				prop.CppFlags = m.SnapshotCppFlags()
+				prop.ConlyFlags = m.SnapshotConlyFlags()
+			}
+			// symbol visibility determines which symbols a library exports to its consumers
+			if m.Static() || m.Shared() {
+				prop.Visibility = m.SnapshotVisibility()
+				prop.Pic = m.SnapshotPic()
+			}
+			if m.Shared() {
+				prop.FloatAbi = m.SnapshotFloatAbi()
+				prop.InstructionSet = m.SnapshotInstructionSet()
+				prop.Runpaths = m.SnapshotRunpaths()
+				prop.Nocrt = m.SnapshotNocrt()
+				prop.NoLibcrt = m.SnapshotNoLibcrt()
+				// linker flags affecting the runtime behavior are only meaningful on shared libs
+				prop.LinkFlags = m.SnapshotLinkFlags()
+			}
+			// libraries built against the NDK have a fixed API surface. sdk_version is
+			// empty for platform libraries.
+			prop.SdkVersion = m.SnapshotSdkVersion()
+			prop.MinSdkVersion = m.MinSdkVersion()
+			// PGO profiles allow consumers to reproduce or verify PGO-optimized libraries.
+			if profile := m.SnapshotProfileData(); profile.Valid() && ctx.DeviceConfig().BuildSnapshotProfileData() && !headersOnly {
+				prop.ProfileData = filepath.Join("pgo", profile.Path().Base())
+				ret = append(ret, copyFile(ctx, profile.Path(), filepath.Join(pgoDir, profile.Path().Base()), fake)...)
+			}
+			// AFDO profiles are recorded by their source path, or by their path in the snapshot
+			// if they are captured.
+			if profile := m.SnapshotAfdoProfile(); profile.Valid() {
+				if ctx.DeviceConfig().BuildSnapshotAfdoProfiles() && !headersOnly {
+					prop.AfdoProfile = filepath.Join("afdo", profile.Path().Base())
+					ret = append(ret, copyFile(ctx, profile.Path(), filepath.Join(afdoDir, profile.Path().Base()), fake)...)
+				} else {
+					prop.AfdoProfile = profile.Path().Rel()
+				}
 			}
 			if sanitizable, ok := m.(PlatformSanitizeable); ok {
 				if sanitizable.Static() && sanitizable.SanitizePropDefined() {
 					prop.SanitizeMinimalDep = sanitizable.MinimalRuntimeDep() || sanitizable.MinimalRuntimeNeeded()
 					prop.SanitizeUbsanDep = sanitizable.UbsanRuntimeDep() || sanitizable.UbsanRuntimeNeeded()
+					prop.SanitizeRuntimeLibs = snapshotSanitizerRuntimeLibs(m.Target(),
+						prop.SanitizeMinimalDep, prop.SanitizeUbsanDep)
 				}
 			}
-
-			var libType string
+			// hand-written assembly of static libs needs special handling when linked with CFI
 			if m.Static() {
-				libType = "static"
-			} else if m.Shared() {
-				libType = "shared"
-			} else {
-				libType = "header"
+				prop.CfiAssemblySupport = m.SnapshotCfiAssemblySupport()
+			}
+			// consumers of CFI shared libs link with the same CFI exports map for cross-DSO CFI.
+			// copyFile ignores any duplicates.
+			if cfiExportsMap := m.SnapshotCfiExportsMap(); m.Shared() && cfiExportsMap.Valid() && !headersOnly {
+				prop.CfiExportsMap = filepath.Join("cfi-exports", cfiExportsMap.Path().Base())
+				ret = append(ret, copyFile(ctx, cfiExportsMap.Path(),
+					filepath.Join(snapshotArchDir, prop.CfiExportsMap), fake)...)
+			}
+
+			// The version script is captured so that relinking the library reproduces its
+			// exported symbols. Version scripts may vary per arch and are often named alike, so
+			// they are captured per arch and module.
+			if versionScript := m.SnapshotVersionScript(); m.Shared() && versionScript.Valid() && !headersOnly {
+				prop.VersionScript = filepath.Join("version-scripts", ctx.ModuleName(m), versionScript.Path().Base())
+				ret = append(ret, copyFile(ctx, versionScript.Path(),
+					filepath.Join(targetArchDir, prop.VersionScript), fake)...)
 			}
 
 			var stem string
 
+			// Stubs variants are placed under a subtree keyed by their versions, along with the
+			// symbol files they are generated from.
+			libDir := libType
+			if version := m.SnapshotStubsVersion(); version != "" {
+				libDir = filepath.Join("stubs", version)
+				prop.StubsVersion = version
+				if symbolFile := m.SnapshotStubsSymbolFile(); symbolFile.Valid() && !headersOnly {
+					prop.SymbolFile = symbolFile.Path().Base()
+					ret = append(ret, copyFile(ctx, symbolFile.Path(),
+						filepath.Join(targetArchDir, libDir, prop.SymbolFile), fake)...)
+				}
+			} else if symbolFile := m.SnapshotStubsSymbolFile(); m.Shared() && symbolFile.Valid() && !headersOnly {
+				// The symbol file defining the stable API of a library with stubs is captured
+				// once for all arches, so that consumers can regenerate the stubs or check the
+				// API.
+				prop.StubsSymbolFile = filepath.Join("symbol-files", ctx.ModuleName(m), symbolFile.Path().Base())
+				ret = append(ret, copyFile(ctx, symbolFile.Path(),
+					filepath.Join(snapshotArchDir, prop.StubsSymbolFile), fake)...)
+			}
+
 			// install .a or .so
 			if libType != "header" {
 				libPath := m.OutputFile().Path()
 				stem = libPath.Base()
 				if sanitizable, ok := m.(PlatformSanitizeable); ok {
-					if sanitizable.Static() && sanitizable.SanitizePropDefined() && sanitizable.IsSanitizerEnabled(cfi) {
+					if sanitizers := snapshotSanitizerNames(sanitizable); len(sanitizers) > 0 {
 						// both cfi and non-cfi variant for static libraries can exist.
-						// attach .cfi to distinguish between cfi and non-cfi.
-						// e.g. libbase.a -> libbase.cfi.a
+						// attach the sanitizers to distinguish between them, including
+						// the sanitizers combined with cfi.
+						// e.g. libbase.a -> libbase.cfi.a, libbase.cfi.intoverflow.a
 						ext := filepath.Ext(stem)
-						stem = strings.TrimSuffix(stem, ext) + ".cfi" + ext
-						prop.Sanitize = "cfi"
-						prop.ModuleName += ".cfi"
+						suffix := "." + strings.Join(sanitizers, ".")
+						stem = strings.TrimSuffix(stem, ext) + suffix + ext
+						prop.Sanitize = sanitizers
+						prop.ModuleName += suffix
+					}
+				}
+				if suffix := m.SnapshotSuffix(); suffix != "" {
+					// e.g. libbase.a -> libbase_vendor.a with snapshot_suffix: "_vendor.a"
+					stem = strings.TrimSuffix(stem, filepath.Ext(stem)) + suffix
+					prop.Suffix = suffix
+				}
+				snapshotLibOut := filepath.Join(targetArchDir, libDir, stem)
+				if other, exists := installedLibs[snapshotLibOut]; exists && other != prop.ModuleName {
+					ctx.Errorf("module %q is captured to %q, which is already captured from module %q",
+						prop.ModuleName, snapshotLibOut, other)
+					return nil
+				}
+				installedLibs[snapshotLibOut] = prop.ModuleName
+				if !headersOnly {
+					ret = append(ret, copyFile(ctx, libPath, snapshotLibOut, fake)...)
+				}
+				if libType == "static" && !fake && !headersOnly && ctx.DeviceConfig().BuildSnapshotArchiveMembers() {
+					// e.g. libbase.members.txt listing the objects archived in libbase.a, for
+					// auditing what ends up in the snapshot.
+					membersOut := android.PathForOutput(ctx,
+						strings.TrimSuffix(snapshotLibOut, filepath.Ext(snapshotLibOut))+".members.txt")
+					ctx.Build(pctx, android.BuildParams{
+						Rule:        snapshotArchiveMembers,
+						Input:       libPath,
+						Output:      membersOut,
+						Description: "list archive members " + libPath.String(),
+					})
+					ret = append(ret, membersOut)
+				}
+				if libType == "shared" && prop.StubsVersion == "" && m.SnapshotVendorPublic() {
+					prop.VendorPublic = true
+					publicLibraries = append(publicLibraries, stem)
+				}
+				if libType == "shared" && prop.StubsVersion == "" {
+					captureSymbols(m, snapshotLibOut, fake)
+					// Some consumers keep the unstripped library in the same tree, e.g.
+					// libfoo.so.unstripped next to libfoo.so.
+					if unstripped := m.UnstrippedOutputFile(); unstripped != nil && ctx.DeviceConfig().InlineSnapshotUnstripped() && !headersOnly {
+						prop.UnstrippedSharedLibrary = stem + ".unstripped"
+						ret = append(ret, copyFile(ctx, unstripped, snapshotLibOut+".unstripped", fake)...)
+					}
+					// Consumers of large snapshots save regenerating the tables of contents, e.g.
+					// libfoo.so.toc next to libfoo.so.
+					if toc := m.Toc(); toc.Valid() && ctx.DeviceConfig().BuildSnapshotTocs() && !headersOnly {
+						prop.Toc = stem + ".toc"
+						ret = append(ret, copyFile(ctx, toc.Path(), snapshotLibOut+".toc", fake)...)
 					}
 				}
-				snapshotLibOut := filepath.Join(snapshotArchDir, targetArch, libType, stem)
-				ret = append(ret, copyFile(ctx, libPath, snapshotLibOut, fake))
 			} else {
 				stem = ctx.ModuleName(m)
 			}
 
-			propOut = filepath.Join(snapshotArchDir, targetArch, libType, stem+".json")
+			propOut = filepath.Join(targetArchDir, libDir, stem+".json")
+
+			// VNDK extensions are installed to the VNDK APEX directories, vndk or vndk-sp.
+			if c.supportsVndkExt && m.IsVndkExt() && m.Shared() && prop.StubsVersion == "" {
+				lib := vndkLibraries[prop.ModuleName]
+				if lib == nil {
+					lib = &snapshotVndkLibrary{
+						Name:    prop.ModuleName,
+						Apex:    prop.RelativeInstallPath,
+						Extends: prop.VndkExtends,
+						Version: m.VndkVersion(),
+					}
+					vndkLibraries[prop.ModuleName] = lib
+				}
+				lib.Arches = android.SortedUniqueStrings(append(lib.Arches, targetArch))
+			}
 		} else if m.Binary() {
 			// binary flags
 			prop.Symlinks = m.Symlinks()
 			prop.SharedLibs = m.SnapshotSharedLibs()
+			prop.NeededLibs = m.SnapshotNeededLibs()
+			prop.FloatAbi = m.SnapshotFloatAbi()
+			prop.InstructionSet = m.SnapshotInstructionSet()
+			prop.Runpaths = m.SnapshotRunpaths()
+			prop.Nocrt = m.SnapshotNocrt()
+			prop.NoLibcrt = m.SnapshotNoLibcrt()
+			prop.Pic = m.SnapshotPic()
+			prop.Pie = m.SnapshotPie()
+			// binaries built against the NDK, or bundled in APEXes, depend on the API levels
+			// they are built for as libraries do.
+			prop.SdkVersion = m.SnapshotSdkVersion()
+			prop.MinSdkVersion = m.MinSdkVersion()
+			// Binaries are captured only for the arches they are built for, e.g. only the 32-bit
+			// arch of a 64-bit device with compile_multilib: "prefer32". Record the multilib of
+			// the captured variant so that the snapshot prebuilt is built for the same arch.
+			if m.Target().Arch.ArchType.Multilib == "lib32" {
+				prop.CompileMultilib = "32"
+			} else {
+				prop.CompileMultilib = "64"
+			}
 
 			// install bin
 			binPath := m.OutputFile().Path()
-			snapshotBinOut := filepath.Join(snapshotArchDir, targetArch, "binary", binPath.Base())
-			ret = append(ret, copyFile(ctx, binPath, snapshotBinOut, fake))
+			snapshotBinOut := filepath.Join(targetArchDir, "binary", binPath.Base())
+			if !headersOnly {
+				ret = append(ret, copyFile(ctx, binPath, snapshotBinOut, fake)...)
+				// data files, e.g. from filegroups, may be shared by several binaries. copyFile
+				// ignores any duplicates.
+				for _, path := range m.SnapshotData() {
+					data := filepath.Join("data", path.Rel())
+					prop.Data = append(prop.Data, data)
+					ret = append(ret, copyFile(ctx, path, filepath.Join(targetArchDir, "binary", data), fake)...)
+				}
+			}
+			captureSymbols(m, snapshotBinOut, fake)
 			propOut = snapshotBinOut + ".json"
 		} else if m.Object() {
 			// object files aren't installed to the device, so their names can conflict.
-			// Use module name as stem.
+			// Use module name as stem. An object module with multiple inputs produces a single
+			// partially linked object, which is its output file.
 			objPath := m.OutputFile().Path()
-			snapshotObjOut := filepath.Join(snapshotArchDir, targetArch, "object",
+			snapshotObjOut := filepath.Join(targetArchDir, "object",
 				ctx.ModuleName(m)+filepath.Ext(objPath.Base()))
-			ret = append(ret, copyFile(ctx, objPath, snapshotObjOut, fake))
+			if !headersOnly {
+				ret = append(ret, copyFile(ctx, objPath, snapshotObjOut, fake)...)
+				// CRT objects are named after their roles, e.g. crtbegin_so.
+				if m.SnapshotCrt() {
+					if crtObjects[targetArchDir] == nil {
+						crtObjects[targetArchDir] = make(map[string]string)
+					}
+					crtObjects[targetArchDir][ctx.ModuleName(m)] = filepath.Join("object", filepath.Base(snapshotObjOut))
+				}
+			}
 			propOut = snapshotObjOut + ".json"
 		} else {
 			ctx.Errorf("unknown module %q in vendor snapshot", m.String())
 			return nil
 		}
 
-		j, err := json.Marshal(prop)
+		// CFI in the diagnostic mode is ABI compatible with the release mode, but meant for
+		// triage rather than production. Production snapshots may reject it.
+		if m.SnapshotCfiDiag() {
+			prop.CfiDiag = true
+			if ctx.DeviceConfig().StrictSnapshotCfiDiag() {
+				ctx.Errorf("%q is built with diagnostic CFI, which isn't allowed in the %s snapshot", propOut, c.name)
+			}
+		}
+
+		// Shared libraries and binaries linking a static STL bundle it, so their consumers must not
+		// link another copy of the STL.
+		if m.Static() || m.Shared() || m.Binary() {
+			prop.Stl = m.SelectedStl()
+			prop.BundledStl = !m.Static() && strings.HasSuffix(prop.Stl, "_static")
+		}
+
+		if m.IsSnapshotLibrary() {
+			exportedDirs[propOut] = append(android.CopyOf(prop.ExportedDirs), prop.ExportedSystemDirs...)
+		}
+
+		j, err := marshalSnapshotJson(prop, ctx.DeviceConfig())
 		if err != nil {
 			ctx.Errorf("json marshal to %q failed: %#v", propOut, err)
 			return nil
 		}
+		// The json flags are validated as they are written, so that consumers can rely on the
+		// schema version recorded in the manifest.
+		if ctx.DeviceConfig().ValidateSnapshotJson() {
+			if err := validateSnapshotJson(j); err != nil {
+				ctx.Errorf("%q doesn't conform to version %d of the snapshot json schema: %s",
+					propOut, snapshotJsonSchemaVersion, err)
+				return nil
+			}
+		}
 		ret = append(ret, writeStringToFileRule(ctx, string(j), propOut))
 
+		// Provenance records where the captured variant was defined, to trace back prebuilts
+		// reported against a delivered snapshot.
+		if ctx.DeviceConfig().BuildSnapshotProvenance() {
+			provenanceOut := strings.TrimSuffix(propOut, ".json") + ".provenance.json"
+			provenance, err := marshalSnapshotJson(snapshotProvenance{
+				ModuleType:    ctx.ModuleType(m),
+				BlueprintFile: ctx.BlueprintFile(m),
+				Variant:       ctx.ModuleSubDir(m),
+			}, ctx.DeviceConfig())
+			if err != nil {
+				ctx.Errorf("json marshal to %q failed: %#v", provenanceOut, err)
+				return nil
+			}
+			ret = append(ret, writeStringToFileRule(ctx, string(provenance), provenanceOut))
+		}
+
+		// The sanitizer settings of sanitized modules are recorded together, so that consumers
+		// can link sanitized prebuilts the way they were built.
+		if sanitizers := m.SnapshotSanitizers(); len(sanitizers) > 0 {
+			sanitizeOut := strings.TrimSuffix(propOut, ".json") + ".sanitize.json"
+			info := snapshotSanitize{
+				Sanitizers:     sanitizers,
+				DiagSanitizers: m.SnapshotDiagSanitizers(),
+			}
+			if sanitizable, ok := m.(PlatformSanitizeable); ok {
+				info.MinimalRuntime = sanitizable.MinimalRuntimeDep() || sanitizable.MinimalRuntimeNeeded()
+				info.UbsanRuntime = sanitizable.UbsanRuntimeDep() || sanitizable.UbsanRuntimeNeeded()
+				info.RuntimeLibs = snapshotSanitizerRuntimeLibs(m.Target(), info.MinimalRuntime, info.UbsanRuntime)
+			}
+			if blocklist := m.SnapshotSanitizeBlocklist(); blocklist.Valid() && !headersOnly {
+				info.Blocklist = filepath.Join("sanitize-blocklists", ctx.ModuleName(m), blocklist.Path().Base())
+				ret = append(ret, copyFile(ctx, blocklist.Path(),
+					filepath.Join(targetArchDir, info.Blocklist), fake)...)
+			}
+			j, err := marshalSnapshotJson(info, ctx.DeviceConfig())
+			if err != nil {
+				ctx.Errorf("json marshal to %q failed: %#v", sanitizeOut, err)
+				return nil
+			}
+			ret = append(ret, writeStringToFileRule(ctx, string(j), sanitizeOut))
+		}
+
+		for _, out := range ret {
+			outputModules[out.String()] = prop.ModuleName
+		}
+		moduleLicenseKinds[prop.ModuleName] = m.EffectiveLicenseKinds()
+
+		moduleType := filepath.Base(filepath.Dir(propOut))
+		if prop.StubsVersion != "" {
+			moduleType = "stubs"
+		}
+		c.capturedModules = append(c.capturedModules, CapturedSnapshotModule{
+			Name: prop.ModuleName,
+			Type: moduleType,
+			Arch: targetArch,
+		})
+		capturedModuleNames[ctx.ModuleName(m)] = true
+
+		if buildBlueprints && prop.StubsVersion == "" {
+			var src string
+			if moduleType != "header" {
+				src, _ = filepath.Rel(snapshotArchDir, strings.TrimSuffix(propOut, ".json"))
+			}
+			addSnapshotBlueprintVariant(blueprintModules, moduleType, ctx.ModuleName(m), m.Target(), prop, src)
+		}
+
+		// Shared libraries and binaries installed to the same path would collide on the device.
+		// This is checked only once, for the real snapshot.
+		if (m.Shared() || m.Binary()) && prop.StubsVersion == "" && !c.fake {
+			installPath := snapshotInstallPath(c.name, prop, m)
+			if other, ok := installPaths[installPath]; ok && other != prop.ModuleName {
+				ctx.Errorf("%q and %q in the %s snapshot are both installed to %q", other, prop.ModuleName, c.name, installPath)
+			}
+			installPaths[installPath] = prop.ModuleName
+		}
+
+		providedLibs[BaseLibName(prop.ModuleName)+"/"+targetArch] = true
+		// Libraries loaded with dlopen are expected to be captured as well as linked ones.
+		deps := append(append(android.CopyOf(prop.SharedLibs), prop.StaticLibs...), prop.HeaderLibs...)
+		capturedDeps = append(capturedDeps, snapshotDeps{
+			propOut:  propOut,
+			arch:     targetArch,
+			libs:     android.FirstUniqueStrings(append(deps, prop.DlopenLibs...)),
+			required: prop.Required,
+		})
+
 		return ret
 	}
 
 	ctx.VisitAllModules(func(module android.Module) {
 		m, ok := module.(LinkableInterface)
 		if !ok {
+			if c.image.isProprietaryPath(ctx.ModuleDir(module), ctx.DeviceConfig()) {
+				providedModules[ctx.ModuleName(module)] = true
+			}
 			return
 		}
 
@@ -464,19 +1447,61 @@ func (c *snapshotSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 		inProprietaryPath := c.image.isProprietaryPath(moduleDir, ctx.DeviceConfig())
 		apexInfo := ctx.ModuleProvider(module, android.ApexInfoProvider).(android.ApexInfo)
 
-		if c.image.excludeFromSnapshot(m) {
-			if inProprietaryPath {
-				// Error: exclude_from_vendor_snapshot applies
-				// to framework-path modules only.
-				ctx.Errorf("module %q in vendor proprietary path %q may not use \"exclude_from_vendor_snapshot: true\"", m.String(), moduleDir)
-				return
+		if err := checkExcludeFromSnapshot(m, moduleDir, inProprietaryPath, c.image); err != nil {
+			ctx.Errorf("%s", err)
+			return
+		}
+
+		// Libraries owned by the consuming image, and VNDK and LLNDK libraries provided by the
+		// system image, satisfy dependencies of captured modules without being captured. Variants
+		// disabled for their arch provide nothing.
+		if m.Enabled() && (inProprietaryPath || c.image.excludeFromSnapshot(m) ||
+			(c.image.includeVndk() && (m.IsLlndk() || (m.IsVndk() && !m.IsVndkExt())))) {
+			providedLibs[BaseLibName(ctx.ModuleName(m))+"/"+snapshotArchDirName(m.Target())] = true
+		}
+
+		// LLNDK stubs aren't captured as shared libraries, but their symbol files and versions
+		// describe the LLNDK surface the vendor image is built against.
+		if buildLlndk && m.Enabled() && m.IsLlndk() && m.Shared() && m.InVendor() && m.SnapshotLlndkSymbolFile().Valid() {
+			name := ctx.ModuleName(m)
+			symbolFile := m.SnapshotLlndkSymbolFile().Path()
+			lib := llndkLibraries[name]
+			if lib == nil {
+				lib = &snapshotLlndkLibrary{
+					Name:       name,
+					SymbolFile: filepath.Join("llndk", name, symbolFile.Base()),
+				}
+				llndkLibraries[name] = lib
+				snapshotOutputs = append(snapshotOutputs,
+					copyFile(ctx, symbolFile, filepath.Join(snapshotArchDir, lib.SymbolFile), c.fake)...)
 			}
+			lib.Versions = android.SortedUniqueStrings(append(lib.Versions, m.SnapshotLlndkVersion()))
 		}
 
 		if !isSnapshotAware(ctx.DeviceConfig(), m, inProprietaryPath, apexInfo, c.image) {
 			return
 		}
 
+		// Modules of the arches excluded from the snapshot are skipped.
+		if !archCaptured(m.Target()) {
+			return
+		}
+
+		// Vendor variants of SDK members are provided by the SDK snapshots, unless the members are
+		// explicitly available to the vendor image.
+		if sdkMembers[ctx.ModuleName(m)] && m.InVendor() && !m.HasVendorVariant() {
+			providedLibs[BaseLibName(ctx.ModuleName(m))+"/"+snapshotArchDirName(m.Target())] = true
+			return
+		}
+
+		// A vendor snapshot filtered by directories, e.g. of the subtree of a team, captures only
+		// the modules under the directories. The other modules are expected to be provided by
+		// other snapshots.
+		if dirFilter := ctx.DeviceConfig().BoardVendorSnapshotDirFilter(); c.name == "vendor" && len(dirFilter) > 0 && !inSnapshotDirs(moduleDir, dirFilter) {
+			providedLibs[BaseLibName(ctx.ModuleName(m))+"/"+snapshotArchDirName(m.Target())] = true
+			return
+		}
+
 		// If we are using directed snapshot and a module is not included in the
 		// list, we will still include the module as if it was a fake module.
 		// The reason is that soong needs all the dependencies to be present, even
@@ -489,64 +1514,787 @@ func (c *snapshotSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 		// installSnapshot installs prebuilts and json flag files
 		snapshotOutputs = append(snapshotOutputs, installSnapshot(m, installAsFake)...)
 		// just gather headers and notice files here, because they are to be deduplicated
+		// generated headers are installed by installSnapshot.
 		if m.IsSnapshotLibrary() {
-			headers = append(headers, m.SnapshotHeaders()...)
+			for _, header := range m.SnapshotHeaders() {
+				if _, ok := generatedHeaderPath(header, ""); !ok {
+					headers = append(headers, header)
+				}
+			}
 		}
+	})
 
-		if len(m.NoticeFiles()) > 0 {
-			noticeName := ctx.ModuleName(m) + ".txt"
-			noticeOut := filepath.Join(noticeDir, noticeName)
-			// skip already copied notice file
-			if !installedNotices[noticeOut] {
-				installedNotices[noticeOut] = true
-				snapshotOutputs = append(snapshotOutputs, combineNoticesRule(ctx, m.NoticeFiles(), noticeOut))
+	// Some tools expect a directory for every arch, so optionally keep one even if no modules are
+	// captured for the arch.
+	if ctx.DeviceConfig().KeepEmptySnapshotArchDirs() {
+		for _, target := range ctx.Config().Targets[android.Android] {
+			if target.NativeBridge == android.NativeBridgeEnabled || !archCaptured(target) {
+				continue
+			}
+			targetArch := snapshotArchDirName(target)
+			if !capturedArches[targetArch] {
+				keepOut := filepath.Join(snapshotArchDir, targetArch, ".keep")
+				snapshotOutputs = append(snapshotOutputs, writeStringToFileRule(ctx, "", keepOut))
 			}
 		}
-	})
+	}
+
+	// Linker configuration fragments, e.g. ld.config.*.txt or public.libraries-*.txt files, are
+	// frozen with the vendor snapshot so that the dynamic linker behaves the same with it.
+	var linkerConfigs []string
+	if c.name == "vendor" && !headersOnly {
+		for _, file := range ctx.DeviceConfig().BoardVendorSnapshotLinkerConfigs() {
+			path := android.ExistentPathForSource(ctx, file)
+			if !path.Valid() {
+				ctx.Errorf("linker config %q of the %s snapshot doesn't exist", file, c.name)
+				continue
+			}
+			out := filepath.Join("linkerconfig", path.Path().Base())
+			linkerConfigs = append(linkerConfigs, out)
+			snapshotOutputs = append(snapshotOutputs,
+				copyFile(ctx, path.Path(), filepath.Join(snapshotArchDir, out), c.fake)...)
+		}
+	}
+
+	// The manifest records the API level the snapshot is frozen against, so that consumers can
+	// refuse snapshots incompatible with their build, and the arches each module is captured for,
+	// so that e.g. a library missing its 32-bit variant stands out.
+	for name, arches := range moduleArches {
+		moduleArches[name] = android.SortedUniqueStrings(arches)
+	}
+	manifest, err := marshalSnapshotJson(snapshotManifest{
+		Image:             c.name,
+		ImageVariant:      c.name,
+		JsonSchemaVersion: snapshotJsonSchemaVersion,
+		FrozenApiLevel:    frozenApiLevel,
+		Prerelease:        prerelease,
+		ManifestRevision:  manifestRevision,
+		ModuleArches:      moduleArches,
+		LinkerConfigs:     android.SortedUniqueStrings(linkerConfigs),
+	}, ctx.DeviceConfig())
+	if err != nil {
+		ctx.Errorf("json marshal of the %s snapshot manifest failed: %#v", c.name, err)
+		return
+	}
+	snapshotOutputs = append(snapshotOutputs,
+		writeStringToFileRule(ctx, string(manifest), filepath.Join(snapshotArchDir, "manifest.json")))
+
+	if buildBlueprints {
+		bp := snapshotBlueprint(c.name, frozenApiLevel, ctx.DeviceConfig().DeviceArch(), blueprintModules)
+		snapshotOutputs = append(snapshotOutputs,
+			writeStringToFileRule(ctx, bp, filepath.Join(snapshotArchDir, "Android.bp")))
+	}
+
+	// The consuming image reconstructs the public library allowlist from the vendor public
+	// libraries of the snapshot.
+	if len(publicLibraries) > 0 {
+		content := strings.Join(android.SortedUniqueStrings(publicLibraries), "\n") + "\n"
+		snapshotOutputs = append(snapshotOutputs,
+			writeStringToFileRule(ctx, content, filepath.Join(snapshotArchDir, "public.libraries.txt")))
+	}
+
+	// The consuming toolchain wires its link commands with the CRT objects listed in crt.json.
+	for _, targetArchDir := range android.SortedStringKeys(crtObjects) {
+		j, err := marshalSnapshotJson(crtObjects[targetArchDir], ctx.DeviceConfig())
+		if err != nil {
+			ctx.Errorf("json marshal of the CRT objects of %q failed: %#v", targetArchDir, err)
+			return
+		}
+		snapshotOutputs = append(snapshotOutputs,
+			writeStringToFileRule(ctx, string(j), filepath.Join(targetArchDir, "crt.json")))
+	}
+
+	// The consuming image reconstructs the VNDK APEX contents from the VNDK libraries of the
+	// snapshot.
+	if len(vndkLibraries) > 0 {
+		var manifest snapshotVndkManifest
+		for _, name := range android.SortedStringKeys(vndkLibraries) {
+			manifest.Libraries = append(manifest.Libraries, *vndkLibraries[name])
+		}
+		j, err := marshalSnapshotJson(manifest, ctx.DeviceConfig())
+		if err != nil {
+			ctx.Errorf("json marshal of the %s snapshot VNDK libraries failed: %#v", c.name, err)
+			return
+		}
+		snapshotOutputs = append(snapshotOutputs,
+			writeStringToFileRule(ctx, string(j), filepath.Join(snapshotArchDir, "vndk", "vndk.json")))
+	}
+
+	if buildLlndk {
+		var manifest snapshotLlndkManifest
+		for _, name := range android.SortedStringKeys(llndkLibraries) {
+			manifest.Libraries = append(manifest.Libraries, *llndkLibraries[name])
+		}
+		j, err := marshalSnapshotJson(manifest, ctx.DeviceConfig())
+		if err != nil {
+			ctx.Errorf("json marshal of the %s snapshot LLNDK libraries failed: %#v", c.name, err)
+			return
+		}
+		snapshotOutputs = append(snapshotOutputs,
+			writeStringToFileRule(ctx, string(j), filepath.Join(llndkDir, "llndk.json")))
+	}
 
 	// install all headers after removing duplicates
 	for _, header := range android.FirstUniquePaths(headers) {
-		snapshotOutputs = append(snapshotOutputs, copyFile(ctx, header, filepath.Join(includeDir, header.String()), c.fake))
+		snapshotOutputs = append(snapshotOutputs, copyFile(ctx, header, filepath.Join(includeDir, header.String()), c.fake)...)
+	}
+
+	// Findings of the checks below are errors in strict mode, and warnings reported when the
+	// snapshot is built otherwise.
+	var warnings snapshotWarnings
+
+	// Check that exported include directories aren't dangling, e.g. because all headers in them
+	// are filtered out. This is done only once, for the real snapshot.
+	if !c.fake {
+		headerDirs := make(map[string]bool)
+		addHeaderDirs := func(header string) {
+			for dir := filepath.Dir(header); dir != "." && !headerDirs[dir]; dir = filepath.Dir(dir) {
+				headerDirs[dir] = true
+			}
+		}
+		for _, header := range headers {
+			addHeaderDirs(filepath.Join(includeDirName, header.String()))
+		}
+		for out := range installedGeneratedHeaders {
+			if rel, err := filepath.Rel(snapshotArchDir, out); err == nil {
+				addHeaderDirs(rel)
+			}
+		}
+		for _, propOut := range android.SortedStringKeys(exportedDirs) {
+			for _, dir := range exportedDirs[propOut] {
+				if headerDirs[dir] {
+					continue
+				}
+				warnings.report(ctx, ctx.DeviceConfig().StrictSnapshotExportedDirs(),
+					"exported directory %q of %q has no headers in the %s snapshot", dir, propOut, c.name)
+			}
+		}
+	}
+
+	// Check that the dependencies of captured modules are captured as well, or provided otherwise.
+	// This is done only once, for the real snapshot.
+	if !c.fake {
+		// Required modules may be of any arch.
+		for lib := range providedLibs {
+			providedModules[lib[:strings.LastIndex(lib, "/")]] = true
+		}
+		for _, deps := range capturedDeps {
+			var missing []string
+			for _, lib := range deps.libs {
+				if !providedLibs[lib+"/"+deps.arch] {
+					missing = append(missing, lib)
+				}
+			}
+			if len(missing) > 0 {
+				warnings.report(ctx, ctx.DeviceConfig().StrictSnapshotDeps(),
+					"dependencies %q of %q aren't captured to the %s snapshot", missing, deps.propOut, c.name)
+			}
+
+			// Required modules missing from the snapshot would otherwise only fail the assembly
+			// of the consuming image.
+			var missingRequired []string
+			for _, required := range deps.required {
+				if !providedModules[required] {
+					missingRequired = append(missingRequired, required)
+				}
+			}
+			if len(missingRequired) > 0 {
+				warnings.report(ctx, ctx.DeviceConfig().StrictSnapshotDeps(),
+					"required modules %q of %q aren't captured to the %s snapshot", missingRequired, deps.propOut, c.name)
+			}
+		}
+	}
+
+	sort.Slice(c.capturedModules, func(i, j int) bool {
+		a, b := c.capturedModules[i], c.capturedModules[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Arch < b.Arch
+	})
+
+	// The SBOM describes every captured file, along with its checksum and the licenses of the
+	// module it is captured from. Computing the checksums is expensive, so this is optional.
+	if ctx.DeviceConfig().BuildSnapshotSbom() && !c.fake {
+		snapshotOutputs = append(snapshotOutputs, snapshotSbomRule(ctx, snapshotOutputs, outputModules,
+			moduleLicenseKinds, snapshotArchDir, c.name+"-"+ctx.Config().DeviceName()))
+	}
+
+	// Check the sizes of the captured files when they are built, to catch accidentally captured
+	// huge files, e.g. unstripped libraries. This is done only once, for the real snapshot.
+	var validations android.Paths
+	if len(warnings) > 0 {
+		validations = append(validations, snapshotWarningsRule(ctx, warnings, snapshotDir, c.name))
+	}
+	if len(copyConflicts) > 0 && !c.fake {
+		validations = append(validations, checkSnapshotCopyConflicts(ctx, copyConflicts, snapshotDir, c.name))
+	}
+	if maxSize := ctx.DeviceConfig().VendorSnapshotMaxFileSizeMB(); maxSize > 0 && !c.fake {
+		validations = append(validations, checkSnapshotFileSizes(ctx, snapshotOutputs, outputModules,
+			snapshotDir, c.name, maxSize, ctx.DeviceConfig().StrictSnapshotMaxFileSize()))
+	}
+
+	// Only reviewed modules, listed in the allowlist, may be captured to the vendor snapshot. The
+	// allowlist is checked when the snapshot is built, and stale entries are reported as well.
+	if allowlist := ctx.DeviceConfig().BoardVendorSnapshotAllowlist(); allowlist != "" && c.name == "vendor" && !c.fake {
+		if path := android.ExistentPathForSource(ctx, allowlist); path.Valid() {
+			validations = append(validations, checkSnapshotAllowlist(ctx, android.SortedStringKeys(capturedModuleNames), path.Path(),
+				snapshotDir, c.name, ctx.DeviceConfig().StrictSnapshotAllowlist()))
+		} else {
+			ctx.Errorf("allowlist %q of the %s snapshot doesn't exist", allowlist, c.name)
+		}
+	}
+
+	// All artifacts are ready. Zip them. The zips are rooted at the snapshot directories by default,
+	// e.g. arm64/arch-arm64-armv8-a/..., and optionally at the arch directories.
+	zipRoot, symbolsZipRoot := snapshotDir, symbolsDir
+	if ctx.DeviceConfig().SnapshotZipRootArchDir() {
+		zipRoot, symbolsZipRoot = snapshotArchDir, symbolsArchDir
+	}
+	if ctx.DeviceConfig().SplitSnapshotZips() {
+		// Consumers may download only the types of files they need, e.g. only the headers.
+		c.splitZipFiles = make(map[string]android.Path)
+		splitOutputs := splitSnapshotOutputs(snapshotOutputs, android.PathForOutput(ctx, snapshotArchDir).String(), includeDirName)
+		for _, zipType := range snapshotZipTypes {
+			if outputs := splitOutputs[zipType]; len(outputs) > 0 {
+				c.splitZipFiles[zipType] = zipSnapshotOutputs(ctx, outputs, validations, zipRoot, snapshotDir,
+					c.name+"-"+ctx.Config().DeviceName()+"-"+zipType)
+			}
+		}
+	} else {
+		c.snapshotZipFile = android.OptionalPathForPath(
+			zipSnapshotOutputs(ctx, snapshotOutputs, validations, zipRoot, snapshotDir, c.name+"-"+ctx.Config().DeviceName()))
 	}
+	if buildSymbols {
+		c.symbolsZipFile = android.OptionalPathForPath(
+			zipSnapshotOutputs(ctx, symbolsOutputs, nil, symbolsZipRoot, snapshotDir, c.name+"-"+ctx.Config().DeviceName()+"-symbols"))
+	}
+
+	// The sizes of the captured files are only known when they are built, so the total size is
+	// written to a file which is exported to Make, rather than to Make directly.
+	if !c.fake {
+		c.totalSizeFile = android.OptionalPathForPath(
+			snapshotTotalSizeRule(ctx, snapshotOutputs, snapshotDir, c.name))
+	}
+}
+
+// snapshotManifest is saved as manifest.json in the snapshot root.
+type snapshotManifest struct {
+	// Name of the image, e.g. "vendor".
+	Image string
+
+	// Image variant the modules were captured from, e.g. "vendor" or "recovery".
+	ImageVariant string `json:",omitempty"`
+
+	// Version of the schema the json flags of the captured modules conform to.
+	JsonSchemaVersion int
+
+	// VNDK API level the snapshot is frozen against, e.g. "30".
+	FrozenApiLevel string `json:",omitempty"`
+
+	// Whether the snapshot is a development snapshot which must not be shipped against.
+	Prerelease bool `json:",omitempty"`
+
+	// Revision of the repo manifest the captured sources are checked out at.
+	ManifestRevision string `json:",omitempty"`
+
+	// Arch directories each module is captured for, keyed by module names, e.g.
+	// {"libfoo": ["arch-arm-armv7-a-neon", "arch-arm64-armv8-a"]}.
+	ModuleArches map[string][]string `json:",omitempty"`
+
+	// Linker configuration fragments frozen with the snapshot, e.g.
+	// "linkerconfig/ld.config.vendor.txt".
+	LinkerConfigs []string `json:",omitempty"`
+}
+
+// snapshotProvenance is saved as <artifact>.provenance.json next to each captured artifact if
+// BuildSnapshotProvenance is set.
+type snapshotProvenance struct {
+	// Soong module type of the captured module, e.g. "cc_library_shared".
+	ModuleType string
+
+	// Blueprint file defining the captured module.
+	BlueprintFile string
+
+	// Variant of the module that was captured, e.g. "android_vendor.30_arm64_armv8-a_shared".
+	Variant string
+}
+
+// snapshotSanitize is saved as <artifact>.sanitize.json next to each captured artifact compiled
+// with sanitizers, recording all of its sanitizer settings.
+type snapshotSanitize struct {
+	// Sanitizers as passed to -fsanitize=, e.g. "cfi".
+	Sanitizers []string
+
+	// Sanitizers running in the diagnostic mode.
+	DiagSanitizers []string `json:",omitempty"`
+
+	// Sanitizer blocklist, relative to the arch directory, e.g.
+	// "sanitize-blocklists/libfoo/blocklist.txt".
+	Blocklist string `json:",omitempty"`
+
+	// Sanitizer runtime libraries the artifact depends on, e.g.
+	// "libclang_rt.ubsan_minimal-aarch64-android.a".
+	RuntimeLibs []string `json:",omitempty"`
+
+	// Whether the artifact depends on the minimal UBSan runtime.
+	MinimalRuntime bool `json:",omitempty"`
+
+	// Whether the artifact depends on the full UBSan runtime.
+	UbsanRuntime bool `json:",omitempty"`
+}
+
+// snapshotVndkManifest is saved as vndk/vndk.json in the snapshot root if VNDK libraries are
+// captured, listing the libraries installed to the VNDK APEX directories.
+type snapshotVndkManifest struct {
+	Libraries []snapshotVndkLibrary `json:",omitempty"`
+}
+
+// snapshotVndkLibrary describes a VNDK library in snapshotVndkManifest.
+type snapshotVndkLibrary struct {
+	// Name of the library, e.g. "libfoo".
+	Name string
+
+	// VNDK APEX directory the library is installed to, "vndk" or "vndk-sp".
+	Apex string
+
+	// Name of the VNDK library the library extends, if it is a VNDK extension.
+	Extends string `json:",omitempty"`
+
+	// VNDK version of the library, e.g. "30".
+	Version string `json:",omitempty"`
+
+	// Arch directories the library is captured to, e.g. ["arch-arm64-armv8-a"].
+	Arches []string `json:",omitempty"`
+}
+
+// snapshotLlndkManifest is saved as llndk/llndk.json in the snapshot root if BuildSnapshotLlndk is
+// set, describing the LLNDK surface of the snapshot.
+type snapshotLlndkManifest struct {
+	Libraries []snapshotLlndkLibrary `json:",omitempty"`
+}
+
+// snapshotLlndkLibrary describes an LLNDK library in snapshotLlndkManifest.
+type snapshotLlndkLibrary struct {
+	// Name of the LLNDK library, e.g. "libc".
+	Name string
+
+	// Symbol file the LLNDK stubs are generated from, relative to the snapshot root, e.g.
+	// "llndk/libc/libc.map.txt".
+	SymbolFile string
+
+	// API levels the LLNDK stubs are generated for, e.g. ["30"].
+	Versions []string `json:",omitempty"`
+}
+
+// checkExcludeFromSnapshot returns an error if m is excluded from the snapshot although it is in
+// a proprietary path.
+func checkExcludeFromSnapshot(m LinkableInterface, moduleDir string, inProprietaryPath bool, image snapshotImage) error {
+	if image.excludeFromSnapshot(m) && inProprietaryPath {
+		// Error: exclude_from_vendor_snapshot applies
+		// to framework-path modules only.
+		return fmt.Errorf("module %q in vendor proprietary path %q may not use \"exclude_from_vendor_snapshot: true\"", m.String(), moduleDir)
+	}
+	return nil
+}
+
+// parseSnapshotMetadata parses the "key=value" pairs of snapshot_metadata.
+func parseSnapshotMetadata(pairs []string) (map[string]string, error) {
+	var ret map[string]string
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("has invalid snapshot_metadata %q, expected \"key=value\"", pair)
+		}
+		if ret == nil {
+			ret = make(map[string]string)
+		}
+		ret[kv[0]] = kv[1]
+	}
+	return ret, nil
+}
+
+// marshalSnapshotJson marshals the json flags of a snapshot module, or another json file of the
+// snapshot, e.g. the manifest. If SortSnapshotJsonKeys is set, the keys are sorted alphabetically
+// instead of following the order of the fields of snapshotJsonFlags, so that reordering the fields
+// doesn't change the output.
+func marshalSnapshotJson(prop interface{}, config android.DeviceConfig) ([]byte, error) {
+	j, err := json.Marshal(prop)
+	if err != nil {
+		return nil, err
+	}
+	if config.SortSnapshotJsonKeys() {
+		// encoding/json marshals maps with their keys sorted.
+		var m map[string]interface{}
+		if err := json.Unmarshal(j, &m); err != nil {
+			return nil, err
+		}
+		if j, err = json.Marshal(m); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// snapshotJsonSchemaVersion is the version of snapshotJsonSchema, recorded in the manifest. Bump
+// it whenever the schema changes, so that consumers of the snapshot can tell which fields to
+// expect.
+const snapshotJsonSchemaVersion = 1
+
+// snapshotJsonSchema is the schema of the json flags of snapshot modules which consumers of the
+// snapshot, e.g. development/vendor_snapshot/update.py, are written against. It maps the known
+// fields to whether they are required. It is maintained separately from snapshotJsonFlags, so that
+// a field added to snapshotJsonFlags without updating the schema fails validation instead of
+// silently changing the format.
+var snapshotJsonSchema = func() map[string]bool {
+	schema := map[string]bool{
+		"ModuleName": true,
+	}
+	for _, field := range []string{
+		"RelativeInstallPath", "VndkExtends", "Partition", "ImageVariant", "Experimental",
+		"ProductVariant", "NoticeFile", "FrozenApiLevel", "Prerelease", "SdkMember",
+		"AvailableImages", "NativeBridgeSupported", "Metadata", "ExportedDirs", "ExportedSystemDirs",
+		"ExportedFlags", "AbiGatingDefines", "Suffix", "Sanitize", "SanitizeMinimalDep",
+		"SanitizeUbsanDep", "SanitizeRuntimeLibs", "CfiAssemblySupport", "CfiExportsMap", "CfiDiag",
+		"VersionScript", "AbiRelevantFlags", "CppFlags", "ConlyFlags", "Visibility", "SdkVersion",
+		"MinSdkVersion", "StubsVersion", "VendorPublic", "Rust", "Installable", "SymbolFile",
+		"StubsSymbolFile", "ProfileData", "AfdoProfile", "LinkFlags", "UnstrippedSharedLibrary",
+		"Toc", "Symlinks", "CompileMultilib", "FloatAbi", "InstructionSet", "Pic", "Pie", "Stl",
+		"BundledStl", "Runpaths", "Nocrt", "NoLibcrt", "KernelModules", "Data", "SharedLibs",
+		"StaticLibs", "HeaderLibs", "NeededLibs", "RuntimeLibs", "DlopenLibs", "Required", "InitRc",
+		"VintfFragments",
+	} {
+		schema[field] = false
+	}
+	return schema
+}()
+
+// validateSnapshotJson checks that the marshalled json flags j of a snapshot module conform to
+// snapshotJsonSchema: j must not have fields unknown to the schema, and it must have all the
+// required fields.
+func validateSnapshotJson(j []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(j, &fields); err != nil {
+		return err
+	}
+	for _, name := range android.SortedStringKeys(fields) {
+		if _, ok := snapshotJsonSchema[name]; !ok {
+			return fmt.Errorf("field %q isn't in version %d of the snapshot json schema", name, snapshotJsonSchemaVersion)
+		}
+	}
+	for _, name := range android.SortedStringKeys(snapshotJsonSchema) {
+		if _, ok := fields[name]; snapshotJsonSchema[name] && !ok {
+			return fmt.Errorf("required field %q is missing", name)
+		}
+	}
+	return nil
+}
+
+// noticeHash returns the hash of the paths of the given notice files, naming their combined
+// notice. Notices are often shared by many modules, e.g. a common license file, and they are only
+// read when the combined notice is built, so the combined notices are deduplicated by the paths of
+// their sources rather than by content.
+func noticeHash(noticeFiles android.Paths) string {
+	h := sha256.New()
+	for _, path := range noticeFiles {
+		h.Write([]byte(path.String()))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// snapshotSbomEntry describes a captured file in the metadata passed to gen_snapshot_sbom.
+type snapshotSbomEntry struct {
+	// Path of the file, relative to the snapshot root.
+	Path string
+
+	// Name of the module the file is captured from, or empty for files shared by modules, e.g.
+	// headers.
+	Module string `json:",omitempty"`
+
+	// License kinds of the module, e.g. SPDX-license-identifier-Apache-2.0.
+	LicenseKinds []string `json:",omitempty"`
+}
+
+// snapshotSbomRule returns an SPDX SBOM, sbom.spdx.json in the snapshot root, describing the
+// outputs of the snapshot.
+func snapshotSbomRule(ctx android.SingletonContext, outputs android.Paths, outputModules map[string]string,
+	moduleLicenseKinds map[string][]string, snapshotArchDir, name string) android.OutputPath {
+
+	root := android.PathForOutput(ctx, snapshotArchDir)
+	var entries []snapshotSbomEntry
+	for _, out := range android.SortedUniquePaths(outputs) {
+		rel, err := filepath.Rel(root.String(), out.String())
+		if err != nil {
+			ctx.Errorf("snapshot output %q isn't in %q", out, root)
+			continue
+		}
+		module := outputModules[out.String()]
+		entries = append(entries, snapshotSbomEntry{
+			Path:         rel,
+			Module:       module,
+			LicenseKinds: moduleLicenseKinds[module],
+		})
+	}
+	j, err := json.Marshal(entries)
+	if err != nil {
+		ctx.Errorf("json marshal of the snapshot SBOM metadata failed: %#v", err)
+		return root.Join(ctx, "sbom.spdx.json")
+	}
+	metadata := android.PathForOutput(ctx, filepath.Dir(snapshotArchDir), name+"-sbom-metadata.json")
+	android.WriteFileRule(ctx, metadata, string(j))
+
+	sbom := root.Join(ctx, "sbom.spdx.json")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		BuiltTool("gen_snapshot_sbom").
+		FlagWithArg("--name ", name).
+		FlagWithArg("--root ", root.String()).
+		FlagWithInput("--metadata ", metadata).
+		FlagWithOutput("--output ", sbom).
+		Implicits(outputs)
+	rule.Build(name+"_sbom", name+" snapshot SBOM")
+	return sbom
+}
+
+// checkSnapshotFileSizes returns a stamp file of a rule checking that none of the outputs is larger
+// than maxSizeMB megabytes. Oversized outputs are reported along with the modules they are
+// captured from, as errors if strict is true, and as warnings otherwise.
+func checkSnapshotFileSizes(ctx android.SingletonContext, outputs android.Paths, outputModules map[string]string,
+	snapshotDir, name string, maxSizeMB int, strict bool) android.OutputPath {
+
+	list := make(map[string]string)
+	for _, out := range outputs {
+		module := outputModules[out.String()]
+		if module == "" {
+			module = "-"
+		}
+		list[out.String()] = module
+	}
+	listFile := installMapListFileRule(ctx, list, filepath.Join(snapshotDir, name+"-file-sizes.list"))
+	stamp := android.PathForOutput(ctx, snapshotDir, name+"-file-sizes.stamp")
+
+	level, status := "warning", "0"
+	if strict {
+		level, status = "error", "1"
+	}
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text("ret=0;").
+		Text("while read f m || [ -n \"$f\" ]; do").
+		Textf("if [ $(stat -L -c %%s $f) -gt %d ]; then", maxSizeMB*1024*1024).
+		Textf("echo \"%s: $f of module $m in the %s snapshot is larger than %d MB\" >&2; ret=%s;", level, name, maxSizeMB, status).
+		Text("fi; done <").Input(listFile).Text("; [ $ret -eq 0 ]").
+		Implicits(outputs)
+	rule.Command().Text("touch").Output(stamp)
+	rule.Build(name+"_snapshot_file_sizes", name+" snapshot file sizes")
+	return stamp
+}
+
+// checkSnapshotCopyConflicts returns a stamp file of a rule checking that the different files
+// captured to the same destinations have the same content, e.g. identical init_rc files of
+// different modules. Only the first of them is copied, so the others must not differ from it.
+func checkSnapshotCopyConflicts(ctx android.SingletonContext, conflicts map[string]android.Paths,
+	snapshotDir, name string) android.OutputPath {
+
+	stamp := android.PathForOutput(ctx, snapshotDir, name+"-copy-conflicts.stamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	for _, out := range android.SortedStringKeys(conflicts) {
+		srcs := conflicts[out]
+		for _, src := range srcs[1:] {
+			rule.Command().
+				Text("cmp -s").Input(srcs[0]).Input(src).
+				Textf("|| { echo \"error: %s and %s are both captured to %s with different contents\" >&2; exit 1; }",
+					srcs[0], src, out)
+		}
+	}
+	rule.Command().Text("touch").Output(stamp)
+	rule.Build(name+"_snapshot_copy_conflicts", name+" snapshot copy conflicts")
+	return stamp
+}
 
-	// All artifacts are ready. Sort them to normalize ninja and then zip.
-	sort.Slice(snapshotOutputs, func(i, j int) bool {
-		return snapshotOutputs[i].String() < snapshotOutputs[j].String()
+// snapshotWarnings collects the findings of the checks of a snapshot which aren't strict.
+type snapshotWarnings []string
+
+// report reports a finding of a check of a snapshot. It is an error if strict is set, and a
+// warning otherwise.
+func (w *snapshotWarnings) report(ctx android.SingletonContext, strict bool, format string, args ...interface{}) {
+	if strict {
+		ctx.Errorf(format, args...)
+		return
+	}
+	*w = append(*w, fmt.Sprintf(format, args...))
+}
+
+// snapshotWarningsRule returns a stamp file of a rule printing the warnings when the snapshot is
+// built. The warnings are written to a report file next to the snapshot zip as well.
+func snapshotWarningsRule(ctx android.SingletonContext, warnings snapshotWarnings, snapshotDir, name string) android.OutputPath {
+	report := writeStringToFileRule(ctx, strings.Join(warnings, "\n"), filepath.Join(snapshotDir, name+"-warnings.txt"))
+	stamp := android.PathForOutput(ctx, snapshotDir, name+"-warnings.stamp")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().Text("sed 's/^/warning: /'").Input(report).Text(">&2")
+	rule.Command().Text("touch").Output(stamp)
+	rule.Build(name+"_snapshot_warnings", name+" snapshot warnings")
+	return stamp
+}
+
+// checkSnapshotAllowlist returns a stamp file of a rule checking that the captured modules are
+// listed in the allowlist, which lists a module name per line. Lines starting with # are comments.
+// Captured modules which aren't listed are errors if strict is set, and warnings otherwise.
+// Listed modules which aren't captured are reported as stale entries.
+func checkSnapshotAllowlist(ctx android.SingletonContext, modules []string, allowlist android.Path,
+	snapshotDir, name string, strict bool) android.OutputPath {
+
+	listFile := writeStringToFileRule(ctx, strings.Join(android.SortedUniqueStrings(modules), "\n"),
+		filepath.Join(snapshotDir, name+"-captured-modules.list"))
+	stamp := android.PathForOutput(ctx, snapshotDir, name+"-allowlist.stamp")
+
+	level, status := "warning", "0"
+	if strict {
+		level, status = "error", "1"
+	}
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text("ret=0;").
+		Text("while read m || [ -n \"$m\" ]; do").
+		Text("if ! grep -qxF \"$m\"").Input(allowlist).Text("; then").
+		Textf("echo \"%s: module $m captured to the %s snapshot isn't on the allowlist %s\" >&2; ret=%s;",
+			level, name, allowlist.String(), status).
+		Text("fi; done <").Input(listFile).Text(";").
+		Text("grep -v -e '^#' -e '^$'").Input(allowlist).Text("| while read m; do").
+		Text("if ! grep -qxF \"$m\"").Input(listFile).Text("; then").
+		Textf("echo \"warning: stale entry $m of the allowlist %s isn't captured to the %s snapshot\" >&2;",
+			allowlist.String(), name).
+		Text("fi; done; [ $ret -eq 0 ]")
+	rule.Command().Text("touch").Output(stamp)
+	rule.Build(name+"_snapshot_allowlist", name+" snapshot allowlist")
+	return stamp
+}
+
+// snapshotTotalSizeRule returns a file containing the total size of the outputs in bytes, computed
+// when the outputs are built.
+func snapshotTotalSizeRule(ctx android.SingletonContext, outputs android.Paths, snapshotDir, name string) android.OutputPath {
+	listFile := writeStringToFileRule(ctx, strings.Join(android.SortedUniqueStrings(outputs.Strings()), "\n"),
+		filepath.Join(snapshotDir, name+"-total-size.list"))
+	totalSize := android.PathForOutput(ctx, snapshotDir, name+"-total-size.txt")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text("xargs stat -L -c %s <").Input(listFile).
+		Text("| awk '{ s += $1 } END { print s + 0 }' >").Output(totalSize).
+		Implicits(outputs)
+	rule.Build(name+"_snapshot_total_size", name+" snapshot total size")
+	return totalSize
+}
+
+// snapshotZipTypes are the types of captured files which are zipped separately if
+// SplitSnapshotZips is set. "other" holds the files of no other type, e.g. objects and configs.
+var snapshotZipTypes = []string{"shared", "static", "headers", "binary", "other"}
+
+// splitSnapshotOutputs sorts the outputs under the snapshot arch directory archDir into the
+// snapshotZipTypes. Files are classified by the directory following their arch directory, e.g.
+// shared for arch-arm64-armv8-a/shared/libfoo.so, also when the arch directory is nested, e.g.
+// under experimental/, a partition or product-variants/.
+func splitSnapshotOutputs(outputs android.Paths, archDir string, includeDirName string) map[string]android.Paths {
+	ret := make(map[string]android.Paths)
+	for _, out := range outputs {
+		zipType := "other"
+		if rel, err := filepath.Rel(archDir, out.String()); err == nil {
+			dirs := strings.Split(rel, string(filepath.Separator))
+			for i, dir := range dirs[:len(dirs)-1] {
+				if dir == includeDirName {
+					// e.g. include/...
+					zipType = "headers"
+					break
+				}
+				if !strings.HasPrefix(dir, "arch-") {
+					continue
+				}
+				if i+2 < len(dirs) {
+					// e.g. arch-arm64-armv8-a/shared/...
+					switch dirs[i+1] {
+					case "shared", "stubs":
+						zipType = "shared"
+					case "static", "binary":
+						zipType = dirs[i+1]
+					case "header":
+						zipType = "headers"
+					}
+				}
+				break
+			}
+		}
+		ret[zipType] = append(ret[zipType], out)
+	}
+	return ret
+}
+
+// zipSnapshotOutputs zips the given outputs under the directory rootDir into {zipDir}/{name}.zip,
+// and returns the path to the zip file.
+func zipSnapshotOutputs(ctx android.SingletonContext, outputs, validations android.Paths, rootDir, zipDir, name string) android.OutputPath {
+	// Sort the outputs to normalize ninja.
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].String() < outputs[j].String()
 	})
 
-	zipPath := android.PathForOutput(
-		ctx,
-		snapshotDir,
-		c.name+"-"+ctx.Config().DeviceName()+".zip")
+	zipPath := android.PathForOutput(ctx, zipDir, name+".zip")
 	zipRule := android.NewRuleBuilder(pctx, ctx)
 
 	// filenames in rspfile from FlagWithRspFileInputList might be single-quoted. Remove it with tr
-	snapshotOutputList := android.PathForOutput(
-		ctx,
-		snapshotDir,
-		c.name+"-"+ctx.Config().DeviceName()+"_list")
-	rspFile := snapshotOutputList.ReplaceExtension(ctx, "rsp")
+	outputList := android.PathForOutput(ctx, zipDir, name+"_list")
+	rspFile := outputList.ReplaceExtension(ctx, "rsp")
 	zipRule.Command().
 		Text("tr").
 		FlagWithArg("-d ", "\\'").
-		FlagWithRspFileInputList("< ", rspFile, snapshotOutputs).
-		FlagWithOutput("> ", snapshotOutputList)
+		FlagWithRspFileInputList("< ", rspFile, outputs).
+		FlagWithOutput("> ", outputList)
 
-	zipRule.Temporary(snapshotOutputList)
+	zipRule.Temporary(outputList)
 
-	zipRule.Command().
+	zipCmd := zipRule.Command().
 		BuiltTool("soong_zip").
-		FlagWithOutput("-o ", zipPath).
-		FlagWithArg("-C ", android.PathForOutput(ctx, snapshotDir).String()).
-		FlagWithInput("-l ", snapshotOutputList)
+		FlagWithOutput("-o ", zipPath)
+	// The prefix applies to the files following it.
+	if prefix := ctx.DeviceConfig().SnapshotZipPrefix(); prefix != "" {
+		zipCmd.FlagWithArg("-P ", prefix)
+	}
+	zipCmd.
+		FlagWithArg("-C ", android.PathForOutput(ctx, rootDir).String()).
+		FlagWithInput("-l ", outputList).
+		Validations(validations)
 
-	zipRule.Build(zipPath.String(), c.name+" snapshot "+zipPath.String())
+	zipRule.Build(zipPath.String(), name+" snapshot "+zipPath.String())
 	zipRule.DeleteTemporaryFiles()
-	c.snapshotZipFile = android.OptionalPathForPath(zipPath)
+	return zipPath
 }
 
 func (c *snapshotSingleton) MakeVars(ctx android.MakeVarsContext) {
 	ctx.Strict(
 		c.makeVar,
 		c.snapshotZipFile.String())
+	if c.symbolsZipFile.Valid() {
+		// e.g. SOONG_VENDOR_SNAPSHOT_ZIP -> SOONG_VENDOR_SNAPSHOT_SYMBOLS_ZIP
+		ctx.Strict(
+			strings.TrimSuffix(c.makeVar, "_ZIP")+"_SYMBOLS_ZIP",
+			c.symbolsZipFile.String())
+	}
+	for _, zipType := range android.SortedStringKeys(c.splitZipFiles) {
+		// e.g. SOONG_VENDOR_SNAPSHOT_ZIP -> SOONG_VENDOR_SNAPSHOT_SHARED_ZIP
+		ctx.Strict(
+			strings.TrimSuffix(c.makeVar, "_ZIP")+"_"+strings.ToUpper(zipType)+"_ZIP",
+			c.splitZipFiles[zipType].String())
+	}
+	if c.totalSizeFile.Valid() {
+		// Make variables are fixed when Soong runs, before the captured files are built, so the
+		// size is exported as the file holding it. Make rules checking the partition budget depend
+		// on the file and read it, e.g. with $$(cat $(SOONG_VENDOR_SNAPSHOT_TOTAL_SIZE)).
+		// e.g. SOONG_VENDOR_SNAPSHOT_ZIP -> SOONG_VENDOR_SNAPSHOT_TOTAL_SIZE
+		ctx.Strict(
+			strings.TrimSuffix(c.makeVar, "_ZIP")+"_TOTAL_SIZE",
+			c.totalSizeFile.String())
+	}
 }
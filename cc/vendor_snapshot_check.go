@@ -0,0 +1,91 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"strings"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("vendor-snapshot-check", VendorSnapshotCheckSingleton)
+}
+
+// vendorSnapshotCheckSingleton runs the validation of the vendor snapshot, e.g. the consistency of
+// exclude_from_vendor_snapshot, without capturing anything. Building the "vendor-snapshot-check"
+// phony target fails with the found errors, so it can be used as a fast presubmit check. Unlike
+// the vendor snapshot itself, the errors don't fail the whole build.
+type vendorSnapshotCheckSingleton struct{}
+
+func VendorSnapshotCheckSingleton() android.Singleton {
+	return &vendorSnapshotCheckSingleton{}
+}
+
+func (s *vendorSnapshotCheckSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	image := vendorSnapshotImageSingleton
+	if !image.shouldGenerateSnapshot(ctx) {
+		return
+	}
+
+	var errs []string
+	ctx.VisitAllModules(func(module android.Module) {
+		m, ok := module.(LinkableInterface)
+		if !ok {
+			return
+		}
+
+		moduleDir := ctx.ModuleDir(module)
+		inProprietaryPath := image.isProprietaryPath(moduleDir, ctx.DeviceConfig())
+		apexInfo := ctx.ModuleProvider(module, android.ApexInfoProvider).(android.ApexInfo)
+
+		if err := checkExcludeFromSnapshot(m, moduleDir, inProprietaryPath, image); err != nil {
+			errs = append(errs, err.Error())
+			return
+		}
+
+		if !isSnapshotAware(ctx.DeviceConfig(), m, inProprietaryPath, apexInfo, image) {
+			return
+		}
+
+		if !m.IsSnapshotLibrary() && !m.Binary() && !m.Object() {
+			errs = append(errs, fmt.Sprintf("unknown module %q in vendor snapshot", m.String()))
+		}
+		if _, err := parseSnapshotMetadata(m.SnapshotMetadata()); err != nil {
+			errs = append(errs, fmt.Sprintf("module %q %s", ctx.ModuleName(m), err))
+		}
+	})
+
+	stamp := android.PathForOutput(ctx, "vendor-snapshot-check", "check.stamp")
+	if len(errs) > 0 {
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        android.ErrorRule,
+			Output:      stamp,
+			Description: "vendor snapshot check",
+			Args: map[string]string{
+				// The error rule echoes the message in double quotes.
+				"error": strings.ReplaceAll(strings.Join(android.FirstUniqueStrings(errs), "; "), `"`, `\"`),
+			},
+		})
+	} else {
+		ctx.Build(pctx, android.BuildParams{
+			Rule:   android.Touch,
+			Output: stamp,
+		})
+	}
+
+	ctx.Phony("vendor-snapshot-check", stamp)
+}
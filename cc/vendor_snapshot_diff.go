@@ -0,0 +1,53 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("vendor-snapshot-diff", VendorSnapshotDiffSingleton)
+}
+
+// vendorSnapshotDiffSingleton compares two vendor snapshot zips, given with
+// VendorSnapshotDiffBase and VendorSnapshotDiffTarget, and writes a report of added and removed
+// modules and modules whose metadata (stems, exported flags, dependencies, ...) changed. The
+// report is built with the "vendor-snapshot-diff" phony target.
+type vendorSnapshotDiffSingleton struct{}
+
+func VendorSnapshotDiffSingleton() android.Singleton {
+	return &vendorSnapshotDiffSingleton{}
+}
+
+func (s *vendorSnapshotDiffSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	base := ctx.DeviceConfig().VendorSnapshotDiffBase()
+	target := ctx.DeviceConfig().VendorSnapshotDiffTarget()
+	if base == "" || target == "" {
+		return
+	}
+
+	report := android.PathForOutput(ctx, "vendor-snapshot-diff", "report.txt")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		BuiltTool("snapshot_diff").
+		Input(android.PathForSource(ctx, base)).
+		Input(android.PathForSource(ctx, target)).
+		FlagWithOutput("--output ", report)
+	rule.Build("vendor_snapshot_diff", "vendor snapshot diff")
+
+	ctx.Phony("vendor-snapshot-diff", report)
+}
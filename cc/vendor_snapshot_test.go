@@ -16,13 +16,34 @@ package cc
 
 import (
 	"android/soong/android"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
 
+// testSnapshotConfig returns a config generating the vendor snapshot, as tests of snapshot
+// capture need.
+func testSnapshotConfig(t *testing.T, bp string, fs map[string][]byte) android.Config {
+	config := TestConfig(t.TempDir(), android.Android, nil, bp, fs)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("current")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("29")
+	return config
+}
+
+// readSnapshotJson parses the json file jsonFile generated by the snapshot singleton into v, e.g.
+// the snapshotJsonFlags of a captured module or the snapshotManifest.
+func readSnapshotJson(t *testing.T, singleton android.TestingSingleton, jsonFile string, v interface{}) {
+	t.Helper()
+	content := android.ContentFromFileRuleForTests(t, singleton.Output(jsonFile))
+	if err := json.Unmarshal([]byte(content), v); err != nil {
+		t.Fatalf("failed to parse %q: %s", jsonFile, err)
+	}
+}
+
 func TestVendorSnapshotCapture(t *testing.T) {
 	bp := `
 	cc_library {
@@ -149,31 +170,3292 @@ func TestVendorSnapshotCapture(t *testing.T) {
 				filepath.Join(binaryDir, "vendor_available_bin.json"))
 		}
 
-		// For header libraries, all vendor:true and vendor_available modules are captured.
-		headerDir := filepath.Join(snapshotVariantPath, archDir, "header")
-		jsonFiles = append(jsonFiles, filepath.Join(headerDir, "libvendor_headers.json"))
+		// For header libraries, all vendor:true and vendor_available modules are captured.
+		headerDir := filepath.Join(snapshotVariantPath, archDir, "header")
+		jsonFiles = append(jsonFiles, filepath.Join(headerDir, "libvendor_headers.json"))
+
+		// For object modules, all vendor:true and vendor_available modules are captured.
+		objectVariant := fmt.Sprintf("android_vendor.29_%s_%s", archType, archVariant)
+		objectDir := filepath.Join(snapshotVariantPath, archDir, "object")
+		checkSnapshot(t, ctx, snapshotSingleton, "obj", "obj.o", objectDir, objectVariant)
+		jsonFiles = append(jsonFiles, filepath.Join(objectDir, "obj.o.json"))
+	}
+
+	for _, jsonFile := range jsonFiles {
+		// verify all json files exist
+		if snapshotSingleton.MaybeOutput(jsonFile).Rule == nil {
+			t.Errorf("%q expected but not found", jsonFile)
+		}
+	}
+
+	// fake snapshot should have all outputs in the normal snapshot.
+	fakeSnapshotSingleton := ctx.SingletonForTests("vendor-fake-snapshot")
+	for _, output := range snapshotSingleton.AllOutputs() {
+		fakeOutput := strings.Replace(output, "/vendor-snapshot/", "/fake/vendor-snapshot/", 1)
+		if fakeSnapshotSingleton.MaybeOutput(fakeOutput).Rule == nil {
+			t.Errorf("%q expected but not found", fakeOutput)
+		}
+	}
+}
+
+func TestVendorSnapshotAbiRelevantFlags(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		cflags: ["-fshort-enums", "-Wall", "-fvisibility=hidden"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	jsonFile := filepath.Join("out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static", "libvendor.a.json")
+
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+
+	for _, flag := range []string{"-fshort-enums", "-fno-rtti"} {
+		if !android.InList(flag, prop.AbiRelevantFlags) {
+			t.Errorf("expected %q in AbiRelevantFlags, got %q", flag, prop.AbiRelevantFlags)
+		}
+	}
+	if android.InList("-Wall", prop.AbiRelevantFlags) {
+		t.Errorf("unexpected %q in AbiRelevantFlags", "-Wall")
+	}
+	android.AssertStringEquals(t, "Visibility", "hidden", prop.Visibility)
+}
+
+func TestVendorSnapshotLanguageFlags(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		cppflags: ["-fexceptions", "-Wno-unused"],
+		conlyflags: ["-std=gnu11", "-funsigned-char"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	jsonFile := filepath.Join("out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static", "libvendor.a.json")
+
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+
+	for _, tc := range []struct {
+		name     string
+		flags    []string
+		expected []string
+		other    []string
+	}{
+		{"CppFlags", prop.CppFlags, []string{"-fexceptions"}, []string{"-std=gnu11", "-funsigned-char", "-Wno-unused"}},
+		{"ConlyFlags", prop.ConlyFlags, []string{"-std=gnu11", "-funsigned-char"}, []string{"-fexceptions"}},
+	} {
+		for _, flag := range tc.expected {
+			if !android.InList(flag, tc.flags) {
+				t.Errorf("expected %q in %s, got %q", flag, tc.name, tc.flags)
+			}
+		}
+		for _, flag := range tc.other {
+			if android.InList(flag, tc.flags) {
+				t.Errorf("unexpected %q in %s", flag, tc.name)
+			}
+		}
+	}
+}
+
+func TestVendorSnapshotNocrt(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libnocrt",
+		vendor: true,
+		nocrt: true,
+		no_libcrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libcrt",
+		vendor: true,
+		system_shared_libs: [],
+		stl: "none",
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, tc := range []struct {
+		name     string
+		nocrt    bool
+		noLibcrt bool
+	}{
+		{"libnocrt", true, true},
+		{"libcrt", false, false},
+	} {
+		jsonFile := filepath.Join(sharedDir, tc.name+".so.json")
+		var prop snapshotJsonFlags
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertBoolEquals(t, "Nocrt of "+jsonFile, tc.nocrt, prop.Nocrt)
+		android.AssertBoolEquals(t, "NoLibcrt of "+jsonFile, tc.noLibcrt, prop.NoLibcrt)
+	}
+}
+
+func TestVendorSnapshotAvailableImages(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libavailable",
+		vendor_available: true,
+		recovery_available: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, tc := range []struct {
+		name     string
+		expected []string
+	}{
+		{"libavailable", []string{"core", "vendor", "recovery"}},
+		// modules available to the vendor image only omit the images
+		{"libvendor", nil},
+	} {
+		jsonFile := filepath.Join(sharedDir, tc.name+".so.json")
+		var prop snapshotJsonFlags
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertDeepEquals(t, "AvailableImages of "+jsonFile, tc.expected, prop.AvailableImages)
+	}
+}
+
+func TestVendorSnapshotImageCflags(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor_available",
+		vendor_available: true,
+		nocrt: true,
+		target: {
+			vendor: {
+				cflags: [
+					"-DVENDOR_BUILD",
+					"-D_LIBCPP_ABI_UNSTABLE",
+					"-fshort-enums",
+					"-Wno-unused-parameter",
+					"-Ivendor/include",
+				],
+			},
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	jsonFile := filepath.Join("out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static", "libvendor_available.a.json")
+
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	// Only the vendor cflags affecting the ABI are recorded.
+	for _, flag := range []string{"-D_LIBCPP_ABI_UNSTABLE", "-fshort-enums"} {
+		android.AssertStringListContains(t, "AbiRelevantFlags", prop.AbiRelevantFlags, flag)
+	}
+	for _, flag := range []string{"-DVENDOR_BUILD", "-Wno-unused-parameter", "-Ivendor/include"} {
+		android.AssertStringListDoesNotContain(t, "AbiRelevantFlags", prop.AbiRelevantFlags, flag)
+	}
+}
+
+func TestVendorSnapshotSuffix(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		snapshot_suffix: "_vendor.lib",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	staticDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static"
+	staticVariant := "android_vendor.29_arm64_armv8-a_static"
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor", "libvendor_vendor.lib", staticDir, staticVariant)
+	if snapshotSingleton.MaybeOutput(filepath.Join(staticDir, "libvendor_vendor.lib.json")).Rule == nil {
+		t.Errorf("json file for %q not found", "libvendor")
+	}
+
+	bp = `
+	cc_library_static {
+		name: "libfoo",
+		vendor: true,
+		nocrt: true,
+		snapshot_suffix: "_bar.a",
+	}
+
+	cc_library_static {
+		name: "libfoo_bar",
+		vendor: true,
+		nocrt: true,
+	}
+`
+	config = testSnapshotConfig(t, bp, nil)
+	testCcErrorWithConfig(t, `is already captured from module`, config)
+}
+
+func TestVendorSnapshotExperimental(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libvendor_experimental",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		snapshot_experimental: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotDir := "out/soong/vendor-snapshot/arm64"
+	sharedDir := filepath.Join(snapshotDir, "arch-arm64-armv8-a", "shared")
+	experimentalSharedDir := filepath.Join(snapshotDir, "experimental", "arch-arm64-armv8-a", "shared")
+	sharedVariant := "android_vendor.29_arm64_armv8-a_shared"
+
+	// Experimental modules are only captured to the experimental subtree, the others only to the
+	// main tree.
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor", "libvendor.so", sharedDir, sharedVariant)
+	checkSnapshotExclude(t, ctx, snapshotSingleton, "libvendor", "libvendor.so", experimentalSharedDir, sharedVariant)
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor_experimental", "libvendor_experimental.so", experimentalSharedDir, sharedVariant)
+	checkSnapshotExclude(t, ctx, snapshotSingleton, "libvendor_experimental", "libvendor_experimental.so", sharedDir, sharedVariant)
+
+	for _, tc := range []struct {
+		jsonFile     string
+		experimental bool
+	}{
+		{filepath.Join(sharedDir, "libvendor.so.json"), false},
+		{filepath.Join(experimentalSharedDir, "libvendor_experimental.so.json"), true},
+	} {
+		var prop snapshotJsonFlags
+		readSnapshotJson(t, snapshotSingleton, tc.jsonFile, &prop)
+		android.AssertBoolEquals(t, "Experimental of "+tc.jsonFile, tc.experimental, prop.Experimental)
+	}
+}
+
+func TestVendorSnapshotKeepEmptyArchDirs(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	snapshotDir := "out/soong/vendor-snapshot/arm64"
+	for _, keep := range []bool{false, true} {
+		config := testSnapshotConfig(t, bp, nil)
+		config.TestProductVariables.KeepEmptySnapshotArchDirs = keep
+		ctx := testCcWithConfig(t, config)
+
+		// Only the 32-bit arch has no captured modules, so only its directory is kept, and only if
+		// asked to.
+		snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+		keepFile := filepath.Join(snapshotDir, "arch-arm-armv7-a-neon", ".keep")
+		android.AssertBoolEquals(t, keepFile, keep, snapshotSingleton.MaybeOutput(keepFile).Rule != nil)
+		if snapshotSingleton.MaybeOutput(filepath.Join(snapshotDir, "arch-arm64-armv8-a", ".keep")).Rule != nil {
+			t.Errorf("the directory of arch-arm64-armv8-a is kept by its captured modules")
+		}
+	}
+}
+
+func TestVendorSnapshotGeneratedHeaders(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libaidl",
+		vendor: true,
+		nocrt: true,
+		srcs: ["IFoo.aidl"],
+		aidl: {
+			export_aidl_headers: true,
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, map[string][]byte{
+		"IFoo.aidl": nil,
+	})
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotVariantPath := "out/soong/vendor-snapshot/arm64"
+	archDir := "arch-arm64-armv8-a"
+	generatedDir := filepath.Join("include", "generated", "libaidl", archDir, "shared", "aidl")
+
+	jsonFile := filepath.Join(snapshotVariantPath, archDir, "shared", "libaidl.so.json")
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	if !android.InList(generatedDir, prop.ExportedDirs) {
+		t.Errorf("expected %q in ExportedDirs, got %q", generatedDir, prop.ExportedDirs)
+	}
+
+	for _, header := range []string{"IFoo.h", "BnFoo.h", "BpFoo.h"} {
+		out := filepath.Join(snapshotVariantPath, generatedDir, header)
+		if snapshotSingleton.MaybeOutput(out).Rule == nil {
+			t.Errorf("generated header %q expected but not found", out)
+		}
+	}
+}
+
+func TestVendorSnapshotHeaderExportedFlags(t *testing.T) {
+	bp := `
+	cc_library_headers {
+		name: "libvendor_headers",
+		vendor: true,
+		nocrt: true,
+		export_cflags: ["-DFEATURE=1"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	// Check that the captured json file has the exported flags.
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/header/libvendor_headers.json"
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertArrayString(t, "ExportedFlags", []string{"-DFEATURE=1"}, prop.ExportedFlags)
+
+	// Check that the snapshot module exports the flags.
+	bp = `
+	vendor_snapshot_header {
+		name: "libvendor_headers",
+		version: "31",
+		target_arch: "arm64",
+		vendor: true,
+		arch: {
+			arm64: {
+				export_flags: ["-DFEATURE=1"],
+			},
+		},
+	}
+`
+	config = TestConfig(t.TempDir(), android.Android, nil, bp, nil)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("31")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("32")
+	ctx = testCcWithConfig(t, config)
+
+	module := ctx.ModuleForTests("libvendor_headers.vendor_header.31.arm64", "android_vendor.31_arm64_armv8-a").Module()
+	exporterInfo := ctx.ModuleProvider(module, FlagExporterInfoProvider).(FlagExporterInfo)
+	android.AssertArrayString(t, "exported flags", []string{"-DFEATURE=1"}, exporterInfo.Flags)
+}
+
+func TestVendorSnapshotAbiGatingDefines(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		export_cflags: ["-DFEATURE=1", "-D_FILE_OFFSET_BITS=64", "-DBIG_ENDIAN"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json"
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertArrayString(t, "AbiGatingDefines",
+		[]string{"-D_FILE_OFFSET_BITS=64", "-DBIG_ENDIAN"}, prop.AbiGatingDefines)
+}
+
+func TestVendorSnapshotSymbols(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+	}
+
+	cc_binary {
+		name: "vendor_bin",
+		vendor: true,
+		nocrt: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BuildSnapshotSymbols = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	symbolsArchDir := "out/soong/vendor-snapshot-symbols/arm64/arch-arm64-armv8-a"
+
+	for _, m := range []struct {
+		name, variant, out string
+	}{
+		{"libvendor", "android_vendor.29_arm64_armv8-a_shared", "shared/libvendor.so"},
+		{"vendor_bin", "android_vendor.29_arm64_armv8-a", "binary/vendor_bin"},
+	} {
+		unstripped := ctx.ModuleForTests(m.name, m.variant).Module().(*Module).UnstrippedOutputFile()
+		out := snapshotSingleton.Output(filepath.Join(symbolsArchDir, m.out))
+		android.AssertStringEquals(t, "unstripped input of "+m.name, unstripped.String(), out.Input.String())
+	}
+
+	snapshotSingleton.Output("out/soong/vendor-snapshot/vendor-test_device-symbols.zip")
+}
+
+func TestVendorDlkmSnapshotCapture(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+	}
+
+	cc_library_shared {
+		name: "libvendor_dlkm",
+		vendor: true,
+		vendor_dlkm_available: true,
+		nocrt: true,
+	}
+
+	cc_library_shared {
+		name: "libodm_dlkm",
+		device_specific: true,
+		vendor_dlkm_available: true,
+		nocrt: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BuildSnapshotBlueprints = true
+	ctx := testCcWithConfig(t, config)
+
+	vendorSingleton := ctx.SingletonForTests("vendor-snapshot")
+	vendorDlkmSingleton := ctx.SingletonForTests("vendor_dlkm-snapshot")
+	sharedVariant := "android_vendor.29_arm64_armv8-a_shared"
+
+	vendorDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	checkSnapshot(t, ctx, vendorSingleton, "libvendor", "libvendor.so", vendorDir, sharedVariant)
+	checkSnapshotExclude(t, ctx, vendorSingleton, "libvendor_dlkm", "libvendor_dlkm.so", vendorDir, sharedVariant)
+
+	vendorDlkmDir := "out/soong/vendor_dlkm-snapshot/arm64/vendor_dlkm/arch-arm64-armv8-a/shared"
+	checkSnapshot(t, ctx, vendorDlkmSingleton, "libvendor_dlkm", "libvendor_dlkm.so", vendorDlkmDir, sharedVariant)
+	checkSnapshotExclude(t, ctx, vendorDlkmSingleton, "libvendor", "libvendor.so", vendorDlkmDir, sharedVariant)
+
+	odmDlkmDir := "out/soong/vendor_dlkm-snapshot/arm64/odm_dlkm/arch-arm64-armv8-a/shared"
+	checkSnapshot(t, ctx, vendorDlkmSingleton, "libodm_dlkm", "libodm_dlkm.so", odmDlkmDir, sharedVariant)
+
+	for _, tc := range []struct {
+		name, dir, partition string
+	}{
+		{"libvendor_dlkm", vendorDlkmDir, "vendor_dlkm"},
+		{"libodm_dlkm", odmDlkmDir, "odm_dlkm"},
+	} {
+		installed := ctx.ModuleForTests(tc.name, sharedVariant).Module().FilesToInstall()
+		android.AssertStringEquals(t, "installed file of "+tc.name,
+			"out/soong/target/product/test_device/"+tc.partition+"/lib64/"+tc.name+".so",
+			android.PathRelativeToTop(installed[0]))
+
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(tc.dir, tc.name+".so.json")
+		readSnapshotJson(t, vendorDlkmSingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "Partition of "+tc.name, tc.partition, prop.Partition)
+	}
+
+	// The vendor_dlkm snapshot modules are listed in the vendor_snapshot module.
+	content := android.ContentFromFileRuleForTests(t, vendorDlkmSingleton.Output("out/soong/vendor_dlkm-snapshot/arm64/Android.bp"))
+	for _, expected := range []string{
+		"vendor_snapshot {\n    name: \"vendor_snapshot\",\n",
+		"vendor_dlkm_snapshot_shared {\n    name: \"libvendor_dlkm\",\n",
+		"src: \"vendor_dlkm/arch-arm64-armv8-a/shared/libvendor_dlkm.so\",",
+		"src: \"odm_dlkm/arch-arm64-armv8-a/shared/libodm_dlkm.so\",",
+	} {
+		android.AssertStringDoesContain(t, "Android.bp", content, expected)
+	}
+}
+
+func TestVendorSnapshotPartition(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libodm",
+		device_specific: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libodm_available",
+		odm_available: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	for _, tc := range []struct {
+		name    string
+		odmPath *string
+		odm     string
+	}{
+		{"odm partition", nil, "odm"},
+		{"odm under vendor", StringPtr("vendor/odm"), "vendor/odm"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testSnapshotConfig(t, bp, nil)
+			config.TestProductVariables.OdmPath = tc.odmPath
+			ctx := testCcWithConfig(t, config)
+
+			// The partition is the one the vendor variant is installed to, unless it is the vendor
+			// partition.
+			snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+			for _, m := range []struct {
+				name, partition string
+			}{
+				{"libvendor", ""},
+				{"libodm", tc.odm},
+				{"libodm_available", tc.odm},
+			} {
+				jsonFile := filepath.Join("out/soong/vendor-snapshot/arm64", m.partition,
+					"arch-arm64-armv8-a/shared", m.name+".so.json")
+				var prop snapshotJsonFlags
+				readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+				android.AssertStringEquals(t, "Partition of "+m.name, m.partition, prop.Partition)
+			}
+		})
+	}
+}
+
+func TestVendorDlkmAvailableErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, bp, err string
+	}{
+		{
+			name: "no vendor variant",
+			bp: `
+			cc_library_shared {
+				name: "libsystem",
+				vendor_dlkm_available: true,
+				nocrt: true,
+			}`,
+			err: `vendor_dlkm_available: requires a vendor variant`,
+		},
+		{
+			name: "vndk",
+			bp: `
+			cc_library_shared {
+				name: "libvndk",
+				vendor_available: true,
+				product_available: true,
+				vendor_dlkm_available: true,
+				vndk: {
+					enabled: true,
+				},
+				nocrt: true,
+			}`,
+			err: `vendor_dlkm_available: may not be used for VNDK modules`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testSnapshotConfig(t, tc.bp, nil)
+			testCcErrorWithConfig(t, tc.err, config)
+		})
+	}
+}
+
+func TestVendorDlkmSnapshotUse(t *testing.T) {
+	bp := `
+	vendor_snapshot {
+		name: "vendor_snapshot",
+		version: "31",
+		arch: {
+			arm64: {
+				shared_libs: [
+					"libvendor_dlkm",
+				],
+			},
+		},
+	}
+
+	vendor_dlkm_snapshot_shared {
+		name: "libvendor_dlkm",
+		version: "31",
+		target_arch: "arm64",
+		compile_multilib: "64",
+		vendor: true,
+		arch: {
+			arm64: {
+				src: "libvendor_dlkm.so",
+			},
+		},
+	}
+`
+	fs := map[string][]byte{
+		"libvendor_dlkm.so": nil,
+	}
+	config := TestConfig(t.TempDir(), android.Android, nil, bp, fs)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("31")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("32")
+	ctx := testCcWithConfig(t, config)
+
+	installed := ctx.ModuleForTests("libvendor_dlkm.vendor_shared.31.arm64", "android_vendor.31_arm64_armv8-a_shared").Module().FilesToInstall()
+	android.AssertStringEquals(t, "installed file",
+		"out/soong/target/product/test_device/vendor_dlkm/lib64/libvendor_dlkm.so",
+		android.PathRelativeToTop(installed[0]))
+}
+
+func TestVendorSnapshotCopyConflict(t *testing.T) {
+	bp := `
+	cc_binary {
+		name: "bin1",
+		vendor: true,
+		nocrt: true,
+		init_rc: ["bin1/init.rc"],
+	}
+
+	cc_binary {
+		name: "bin2",
+		vendor: true,
+		nocrt: true,
+		init_rc: ["bin2/init.rc"],
+	}
+`
+	fs := map[string][]byte{
+		"bin1/init.rc": nil,
+		"bin2/init.rc": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	// Only the first of the files is copied, and the others are checked to have the same content
+	// when the snapshot is built.
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	initRc := snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/configs/init.rc")
+	android.AssertStringEquals(t, "copied init_rc", "bin1/init.rc", initRc.Input.String())
+
+	stamp := "out/soong/vendor-snapshot/vendor-copy-conflicts.stamp"
+	check := snapshotSingleton.Output(stamp)
+	android.AssertStringDoesContain(t, "copy conflict check command", check.RuleParams.Command,
+		"cmp -s bin1/init.rc bin2/init.rc")
+	zip := snapshotSingleton.Output("out/soong/vendor-snapshot/vendor-test_device.zip")
+	android.AssertPathsRelativeToTopEquals(t, "zip validations", []string{stamp}, zip.Validations)
+}
+
+func TestVendorSnapshotCopySameFile(t *testing.T) {
+	bp := `
+	cc_binary {
+		name: "bin1",
+		vendor: true,
+		nocrt: true,
+		init_rc: ["init.rc"],
+	}
+
+	cc_binary {
+		name: "bin2",
+		vendor: true,
+		nocrt: true,
+		init_rc: ["init.rc"],
+	}
+`
+	fs := map[string][]byte{
+		"init.rc": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	// The same file shared by several modules is copied once without any check.
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/configs/init.rc")
+	if snapshotSingleton.MaybeOutput("out/soong/vendor-snapshot/vendor-copy-conflicts.stamp").Rule != nil {
+		t.Errorf("copy conflicts are checked for the same file")
+	}
+}
+
+func TestVendorSnapshotPrefer32(t *testing.T) {
+	bp := `
+	cc_binary {
+		name: "bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "prefer32",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	binary32Dir := "out/soong/vendor-snapshot/arm64/arch-arm-armv7-a-neon/binary"
+	binary64Dir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/binary"
+	checkSnapshot(t, ctx, snapshotSingleton, "bin", "bin", binary32Dir, "android_vendor.29_arm_armv7-a-neon")
+	if snapshotSingleton.MaybeOutput(filepath.Join(binary64Dir, "bin")).Rule != nil {
+		t.Errorf("64-bit variant of %q is captured", "bin")
+	}
+
+	var prop snapshotJsonFlags
+	jsonFile := filepath.Join(binary32Dir, "bin.json")
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertStringEquals(t, "CompileMultilib", "32", prop.CompileMultilib)
+}
+
+func TestVendorSnapshotMetadata(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		snapshot_metadata: ["bug=12345", "review=approved=yes"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json"
+
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertStringEquals(t, "bug", "12345", prop.Metadata["bug"])
+	android.AssertStringEquals(t, "review", "approved=yes", prop.Metadata["review"])
+
+	bp = `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		snapshot_metadata: ["bug"],
+	}
+`
+	config = testSnapshotConfig(t, bp, nil)
+	testCcErrorWithConfig(t, `invalid snapshot_metadata`, config)
+}
+
+func TestVendorSnapshotCapturedModules(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_binary {
+		name: "vendor_bin",
+		vendor: true,
+		nocrt: true,
+	}
+
+	cc_library_shared {
+		name: "libsystem",
+		nocrt: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot").Singleton().(*snapshotSingleton)
+	var captured []string
+	for _, m := range snapshotSingleton.CapturedModules() {
+		if m.Name == "libvendor" || m.Name == "vendor_bin" || m.Name == "libsystem" {
+			captured = append(captured, m.Name+":"+m.Type+":"+m.Arch)
+		}
+	}
+	android.AssertArrayString(t, "captured modules", []string{
+		"libvendor:shared:arch-arm64-armv8-a",
+		"vendor_bin:binary:arch-arm64-armv8-a",
+	}, captured)
+}
+
+func TestSnapshotCaptureToMultipleImages(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libboth",
+		vendor_available: true,
+		recovery_available: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "librecovery",
+		recovery: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.RecoverySnapshotVersion = StringPtr("current")
+	ctx := testCcWithConfig(t, config)
+
+	vendorSingleton := ctx.SingletonForTests("vendor-snapshot")
+	recoverySingleton := ctx.SingletonForTests("recovery-snapshot")
+	checkSnapshot(t, ctx, vendorSingleton, "libboth", "libboth.so",
+		"out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared",
+		"android_vendor.29_arm64_armv8-a_shared")
+	checkSnapshot(t, ctx, recoverySingleton, "libboth", "libboth.so",
+		"out/soong/recovery-snapshot/arm64/arch-arm64-armv8-a/shared",
+		"android_recovery_arm64_armv8-a_shared")
+
+	// Each image records only the modules captured to it.
+	for _, test := range []struct {
+		singleton android.TestingSingleton
+		expected  []string
+	}{
+		{vendorSingleton, []string{"libboth:shared:arch-arm64-armv8-a"}},
+		{recoverySingleton, []string{
+			"libboth:shared:arch-arm64-armv8-a",
+			"librecovery:shared:arch-arm64-armv8-a",
+		}},
+	} {
+		var captured []string
+		for _, m := range test.singleton.Singleton().(*snapshotSingleton).CapturedModules() {
+			if m.Name == "libboth" || m.Name == "librecovery" {
+				captured = append(captured, m.Name+":"+m.Type+":"+m.Arch)
+			}
+		}
+		android.AssertArrayString(t, "captured modules", test.expected, captured)
+	}
+}
+
+func TestVendorSnapshotNotInstalledShared(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+	}
+
+	cc_library_shared {
+		name: "libvendor_staging",
+		vendor: true,
+		nocrt: true,
+		installable: false,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	sharedVariant := "android_vendor.29_arm64_armv8-a_shared"
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor", "libvendor.so", sharedDir, sharedVariant)
+	checkSnapshotExclude(t, ctx, snapshotSingleton, "libvendor_staging", "libvendor_staging.so", sharedDir, sharedVariant)
+}
+
+func TestVendorSnapshotArmAbi(t *testing.T) {
+	bp := `
+	cc_binary {
+		name: "bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "32",
+		instruction_set: "arm",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm-armv7-a-neon/binary/bin.json"
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertStringEquals(t, "FloatAbi", "softfp", prop.FloatAbi)
+	android.AssertStringEquals(t, "InstructionSet", "arm", prop.InstructionSet)
+}
+
+func TestVendorSnapshotNoticeFiles(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor1",
+		vendor: true,
+		nocrt: true,
+		notice: "NOTICE",
+	}
+
+	cc_library_shared {
+		name: "libvendor2",
+		vendor: true,
+		nocrt: true,
+		notice: "NOTICE",
+	}
+
+	cc_library_shared {
+		name: "libvendor3",
+		vendor: true,
+		nocrt: true,
+		notice: "NOTICE.copy",
+	}
+
+	cc_library_shared {
+		name: "libvendor4",
+		vendor: true,
+		nocrt: true,
+		notice: "NOTICE.other",
+	}
+`
+	fs := map[string][]byte{
+		"NOTICE":       []byte("Apache License"),
+		"NOTICE.copy":  []byte("Apache License"),
+		"NOTICE.other": []byte("BSD License"),
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+
+	var noticeFiles []string
+	for _, name := range []string{"libvendor1", "libvendor2", "libvendor3", "libvendor4"} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(sharedDir, name+".so.json")
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		noticeFiles = append(noticeFiles, prop.NoticeFile)
+	}
+	if noticeFiles[0] == "" || noticeFiles[0] != noticeFiles[1] {
+		t.Errorf("expected modules with the same notice to share a notice file, got %q", noticeFiles)
+	}
+	// Notices are deduplicated by path, as they aren't read before they are built.
+	if noticeFiles[0] == noticeFiles[2] || noticeFiles[0] == noticeFiles[3] {
+		t.Errorf("expected modules with different notices not to share a notice file, got %q", noticeFiles)
+	}
+	for _, noticeFile := range android.FirstUniqueStrings(noticeFiles) {
+		combined := snapshotSingleton.Output(filepath.Join("out/soong/vendor-snapshot/arm64", noticeFile))
+		if len(combined.Inputs) == 0 {
+			t.Errorf("expected the notices of %q as inputs", noticeFile)
+		}
+	}
+}
+
+func TestVendorSnapshotPerImageNoticeDirs(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		notice: "NOTICE",
+	}
+`
+	fs := map[string][]byte{
+		"NOTICE": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.PerImageSnapshotNoticeDirs = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	if !strings.HasPrefix(prop.NoticeFile, "NOTICE_FILES_vendor/") {
+		t.Errorf("expected NoticeFile in NOTICE_FILES_vendor/, got %q", prop.NoticeFile)
+	}
+	snapshotSingleton.Output(filepath.Join("out/soong/vendor-snapshot/arm64", prop.NoticeFile))
+}
+
+func TestVendorSnapshotPerImageIncludeDirs(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libfoo",
+		vendor_available: true,
+		recovery_available: true,
+		nocrt: true,
+		compile_multilib: "64",
+		export_include_dirs: ["include/libfoo"],
+	}
+`
+	fs := map[string][]byte{
+		"include/libfoo/foo.h": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.RecoverySnapshotVersion = StringPtr("current")
+	config.TestProductVariables.PerImageSnapshotIncludeDirs = true
+	ctx := testCcWithConfig(t, config)
+
+	for _, image := range []string{"vendor", "recovery"} {
+		snapshotSingleton := ctx.SingletonForTests(image + "-snapshot")
+		snapshotDir := filepath.Join("out/soong", image+"-snapshot", "arm64")
+
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(snapshotDir, "arch-arm64-armv8-a/shared/libfoo.so.json")
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertDeepEquals(t, "ExportedDirs of "+jsonFile,
+			[]string{"include_" + image + "/include/libfoo"}, prop.ExportedDirs)
+		snapshotSingleton.Output(filepath.Join(snapshotDir, "include_"+image, "include/libfoo/foo.h"))
+	}
+}
+
+func TestVendorSnapshotSortJsonKeys(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+	}
+`
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static/libvendor.a.json"
+	for _, sortKeys := range []bool{false, true} {
+		config := testSnapshotConfig(t, bp, nil)
+		config.TestProductVariables.SortSnapshotJsonKeys = sortKeys
+		ctx := testCcWithConfig(t, config)
+
+		content := android.ContentFromFileRuleForTests(t, ctx.SingletonForTests("vendor-snapshot").Output(jsonFile))
+		// AbiRelevantFlags comes after ModuleName in snapshotJsonFlags, but sorts before it.
+		if sorted := strings.HasPrefix(content, `{"AbiRelevantFlags"`); sorted != sortKeys {
+			t.Errorf("expected keys sorted to be %t, got %q", sortKeys, content)
+		}
+	}
+}
+
+func TestVendorSnapshotPublicLibraries(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendorpublic",
+		vendor: true,
+		nocrt: true,
+		vendor_public_library: {
+			symbol_file: "libvendorpublic.map.txt",
+		},
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+	}
+`
+	fs := map[string][]byte{
+		"libvendorpublic.map.txt": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, lib := range []struct {
+		name         string
+		vendorPublic bool
+	}{
+		{"libvendorpublic", true},
+		{"libvendor", false},
+	} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(sharedDir, lib.name+".so.json")
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertBoolEquals(t, lib.name+" VendorPublic", lib.vendorPublic, prop.VendorPublic)
+	}
+
+	publicLibraries := snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/public.libraries.txt")
+	android.AssertStringEquals(t, "public.libraries.txt", "libvendorpublic.so\n",
+		android.ContentFromFileRuleForTests(t, publicLibraries))
+}
+
+func TestVendorSnapshotKernelModules(t *testing.T) {
+	bp := `
+	cc_binary {
+		name: "vendor_bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		snapshot_kernel_modules: ["foo.ko"],
+	}
+`
+	fs := map[string][]byte{
+		"foo.ko": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.BuildSnapshotKernelModules = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/kernel-modules/foo.ko")
+
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/binary/vendor_bin.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertArrayString(t, "KernelModules", []string{"kernel-modules/foo.ko"}, prop.KernelModules)
+}
+
+func TestVendorSnapshotBinaryData(t *testing.T) {
+	bp := `
+	filegroup {
+		name: "vendor_data",
+		srcs: ["etc/config.xml"],
+	}
+
+	cc_binary {
+		name: "vendor_bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		snapshot_data: [":vendor_data"],
+	}
+
+	cc_binary {
+		name: "vendor_bin2",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		snapshot_data: [":vendor_data"],
+	}
+`
+	fs := map[string][]byte{
+		"etc/config.xml": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	binaryDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/binary"
+	snapshotSingleton.Output(filepath.Join(binaryDir, "data/etc/config.xml"))
+
+	for _, bin := range []string{"vendor_bin", "vendor_bin2"} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(binaryDir, bin+".json")
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertArrayString(t, "Data of "+jsonFile, []string{"data/etc/config.xml"}, prop.Data)
+	}
+}
+
+func TestVendorSnapshotVndkExtends(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libvndk",
+		vendor_available: true,
+		product_available: true,
+		vndk: {
+			enabled: true,
+		},
+		nocrt: true,
+	}
+
+	cc_library {
+		name: "libvndk_ext",
+		vendor: true,
+		vndk: {
+			enabled: true,
+			extends: "libvndk",
+		},
+		nocrt: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvndk_ext.so.json"
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertStringEquals(t, "RelativeInstallPath", "vndk", prop.RelativeInstallPath)
+	android.AssertStringEquals(t, "VndkExtends", "libvndk", prop.VndkExtends)
+
+	var manifest snapshotVndkManifest
+	vndkJson := "out/soong/vendor-snapshot/arm64/vndk/vndk.json"
+	readSnapshotJson(t, snapshotSingleton, vndkJson, &manifest)
+	android.AssertDeepEquals(t, "VNDK libraries", []snapshotVndkLibrary{
+		{
+			Name:    "libvndk_ext",
+			Apex:    "vndk",
+			Extends: "libvndk",
+			Version: "29",
+			Arches:  []string{"arch-arm-armv7-a-neon", "arch-arm64-armv8-a"},
+		},
+	}, manifest.Libraries)
+}
+
+func TestVendorSnapshotRunpaths(t *testing.T) {
+	bp := `
+	cc_binary {
+		name: "vendor_bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		ldflags: ["-Wl,-rpath,/vendor/lib64/foo", "-Wl,--rpath=/vendor/lib64/bar"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/binary/vendor_bin.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertArrayString(t, "Runpaths", []string{"/vendor/lib64/foo", "/vendor/lib64/bar"}, prop.Runpaths)
+}
+
+func TestVendorSnapshotLinkFlags(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		ldflags: [
+			"-Wl,-z,max-page-size=16384",
+			"-Wl,--exclude-libs,libfoo.a",
+			"-Wl,--no-undefined",
+		],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	for _, flag := range []string{"-Wl,-z,max-page-size=16384", "-Wl,--exclude-libs,libfoo.a"} {
+		if !android.InList(flag, prop.LinkFlags) {
+			t.Errorf("expected %q in LinkFlags, got %q", flag, prop.LinkFlags)
+		}
+	}
+	if android.InList("-Wl,--no-undefined", prop.LinkFlags) {
+		t.Errorf("unexpected %q in LinkFlags", "-Wl,--no-undefined")
+	}
+}
+
+func TestVendorSnapshotCfiAssemblySupport(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		sanitize: {
+			config: {
+				cfi_assembly_support: true,
+			},
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static/libvendor.a.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertBoolEquals(t, "CfiAssemblySupport", true, prop.CfiAssemblySupport)
+}
+
+func TestVendorSnapshotCfiExportsMap(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor_cfi",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		version_script: "libvendor_cfi.map.txt",
+		sanitize: {
+			cfi: true,
+		},
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		version_script: "libvendor.map.txt",
+	}
+`
+	fs := map[string][]byte{
+		"libvendor_cfi.map.txt":                 nil,
+		"libvendor.map.txt":                     nil,
+		"build/soong/cc/config/cfi_exports.map": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, tc := range []struct {
+		jsonFile      string
+		cfiExportsMap string
+	}{
+		{filepath.Join(sharedDir, "libvendor_cfi.so.json"), "cfi-exports/cfi_exports.map"},
+		{filepath.Join(sharedDir, "libvendor.so.json"), ""},
+	} {
+		var prop snapshotJsonFlags
+		readSnapshotJson(t, snapshotSingleton, tc.jsonFile, &prop)
+		android.AssertStringEquals(t, "CfiExportsMap of "+tc.jsonFile, tc.cfiExportsMap, prop.CfiExportsMap)
+	}
+
+	exportsMap := snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/cfi-exports/cfi_exports.map")
+	android.AssertStringEquals(t, "cfi exports map source", "build/soong/cc/config/cfi_exports.map", exportsMap.Input.String())
+}
+
+func TestVendorSnapshotCfiDiag(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor_diag",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		sanitize: {
+			cfi: true,
+			diag: {
+				cfi: true,
+			},
+		},
+	}
+
+	cc_library_shared {
+		name: "libvendor_cfi",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		sanitize: {
+			cfi: true,
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, tc := range []struct {
+		jsonFile string
+		cfiDiag  bool
+	}{
+		{"libvendor_diag.so.json", true},
+		{"libvendor_cfi.so.json", false},
+	} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(sharedDir, tc.jsonFile)
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertBoolEquals(t, "CfiDiag of "+tc.jsonFile, tc.cfiDiag, prop.CfiDiag)
+	}
+
+	// Production snapshots may reject diagnostic CFI.
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.StrictSnapshotCfiDiag = true
+	testCcErrorWithConfig(t, `libvendor_diag.so.json" is built with diagnostic CFI`, config)
+}
+
+func TestVendorSnapshotSanitizeJson(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		sanitize: {
+			integer_overflow: true,
+			diag: {
+				integer_overflow: true,
+			},
+			blocklist: "blocklist.txt",
+		},
+	}
+
+	cc_library_shared {
+		name: "libvendor_nosanitize",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	fs := map[string][]byte{
+		"blocklist.txt": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	archDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a"
+
+	var info snapshotSanitize
+	sanitizeFile := filepath.Join(archDir, "shared", "libvendor.so.sanitize.json")
+	readSnapshotJson(t, snapshotSingleton, sanitizeFile, &info)
+	overflow := []string{"unsigned-integer-overflow", "signed-integer-overflow"}
+	android.AssertDeepEquals(t, "Sanitizers", overflow, info.Sanitizers)
+	android.AssertDeepEquals(t, "DiagSanitizers", overflow, info.DiagSanitizers)
+	android.AssertStringEquals(t, "Blocklist", "sanitize-blocklists/libvendor/blocklist.txt", info.Blocklist)
+	android.AssertBoolEquals(t, "MinimalRuntime", false, info.MinimalRuntime)
+	android.AssertBoolEquals(t, "UbsanRuntime", true, info.UbsanRuntime)
+	android.AssertDeepEquals(t, "RuntimeLibs",
+		[]string{"libclang_rt.ubsan_standalone-aarch64-android"}, info.RuntimeLibs)
+	snapshotSingleton.Output(filepath.Join(archDir, "sanitize-blocklists", "libvendor", "blocklist.txt"))
+
+	if snapshotSingleton.MaybeOutput(filepath.Join(archDir, "shared", "libvendor_nosanitize.so.sanitize.json")).Rule != nil {
+		t.Errorf("libvendor_nosanitize.so.sanitize.json is generated for a module without sanitizers")
+	}
+}
+
+func TestVendorSnapshotCombinedSanitizers(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		sanitize: {
+			integer_overflow: true,
+		},
+	}
+
+	cc_library_static {
+		name: "libvendor_nooverflow",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	staticDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static"
+	staticVariant := "android_vendor.29_arm64_armv8-a_static"
+	staticCfiVariant := "android_vendor.29_arm64_armv8-a_static_cfi"
+
+	// Sanitizers combined with cfi are attached to the stem of the cfi variant.
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor", "libvendor.a", staticDir, staticVariant)
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor", "libvendor.cfi.intoverflow.a", staticDir, staticCfiVariant)
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor_nooverflow", "libvendor_nooverflow.a", staticDir, staticVariant)
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor_nooverflow", "libvendor_nooverflow.cfi.a", staticDir, staticCfiVariant)
+
+	for _, tc := range []struct {
+		jsonFile   string
+		moduleName string
+		sanitize   []string
+	}{
+		{"libvendor.a.json", "libvendor", nil},
+		{"libvendor.cfi.intoverflow.a.json", "libvendor.cfi.intoverflow", []string{"cfi", "intoverflow"}},
+		{"libvendor_nooverflow.cfi.a.json", "libvendor_nooverflow.cfi", []string{"cfi"}},
+	} {
+		var prop snapshotJsonFlags
+		readSnapshotJson(t, snapshotSingleton, filepath.Join(staticDir, tc.jsonFile), &prop)
+		android.AssertStringEquals(t, "ModuleName of "+tc.jsonFile, tc.moduleName, prop.ModuleName)
+		android.AssertDeepEquals(t, "Sanitize of "+tc.jsonFile, tc.sanitize, prop.Sanitize)
+	}
+}
+
+func TestVendorSnapshotStubsSymbolFile(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		stubs: {
+			symbol_file: "libvendor.map.txt",
+			versions: ["29"],
+		},
+	}
+`
+	fs := map[string][]byte{
+		"libvendor.map.txt": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotDir := "out/soong/vendor-snapshot/arm64"
+	for _, archDir := range []string{"arch-arm64-armv8-a", "arch-arm-armv7-a-neon"} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(snapshotDir, archDir, "shared", "libvendor.so.json")
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "StubsSymbolFile of "+jsonFile,
+			"symbol-files/libvendor/libvendor.map.txt", prop.StubsSymbolFile)
+	}
+	snapshotSingleton.Output(filepath.Join(snapshotDir, "symbol-files", "libvendor", "libvendor.map.txt"))
+}
+
+func TestVendorSnapshotStubsVariants(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		stubs: {
+			symbol_file: "libvendor.map.txt",
+			versions: ["29"],
+		},
+	}
+`
+	fs := map[string][]byte{
+		"libvendor.map.txt": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	archDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a"
+	sharedDir := filepath.Join(archDir, "shared")
+	stubsDir := filepath.Join(archDir, "stubs", "29")
+
+	// Both the implementation and the stubs variants are captured, each to its own directory.
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor", "libvendor.so", sharedDir, "android_vendor.29_arm64_armv8-a_shared")
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor", "libvendor.so", stubsDir, "android_vendor.29_arm64_armv8-a_shared_29")
+
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, filepath.Join(stubsDir, "libvendor.so.json"), &prop)
+	android.AssertStringEquals(t, "StubsVersion", "29", prop.StubsVersion)
+	android.AssertStringEquals(t, "SymbolFile", "libvendor.map.txt", prop.SymbolFile)
+	symbolFile := snapshotSingleton.Output(filepath.Join(stubsDir, "libvendor.map.txt"))
+	android.AssertStringEquals(t, "symbol file source", "libvendor.map.txt", symbolFile.Input.String())
+
+	var implProp snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, filepath.Join(sharedDir, "libvendor.so.json"), &implProp)
+	android.AssertStringEquals(t, "StubsVersion of the implementation", "", implProp.StubsVersion)
+	android.AssertStringEquals(t, "SymbolFile of the implementation", "", implProp.SymbolFile)
+}
+
+func TestVendorSnapshotVersionScript(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		version_script: "libvendor.map.txt",
+		target: {
+			vendor: {
+				version_script: "libvendor.vendor.map.txt",
+			},
+		},
+	}
+
+	cc_library_shared {
+		name: "libvendor_noscript",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	fs := map[string][]byte{
+		"libvendor.map.txt":        nil,
+		"libvendor.vendor.map.txt": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	archDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a"
+	for _, tc := range []struct {
+		jsonFile      string
+		versionScript string
+	}{
+		{"shared/libvendor.so.json", "version-scripts/libvendor/libvendor.vendor.map.txt"},
+		{"shared/libvendor_noscript.so.json", ""},
+	} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(archDir, tc.jsonFile)
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "VersionScript of "+tc.jsonFile, tc.versionScript, prop.VersionScript)
+	}
+
+	versionScript := snapshotSingleton.Output(filepath.Join(archDir, "version-scripts/libvendor/libvendor.vendor.map.txt"))
+	android.AssertStringEquals(t, "version script source", "libvendor.vendor.map.txt", versionScript.Input.String())
+}
+
+func TestVendorSnapshotProductVariant(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libvendor_feature",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.SnapshotProductVariant = StringPtr("feature_on")
+	config.TestProductVariables.SnapshotProductVariantModules = []string{"libvendor_feature"}
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotDir := "out/soong/vendor-snapshot/arm64"
+	for _, tc := range []struct {
+		dir            string
+		name           string
+		productVariant string
+	}{
+		{"arch-arm64-armv8-a", "libvendor", ""},
+		{"product-variants/feature_on/arch-arm64-armv8-a", "libvendor_feature", "feature_on"},
+	} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(snapshotDir, tc.dir, "shared", tc.name+".so.json")
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "ProductVariant of "+tc.name, tc.productVariant, prop.ProductVariant)
+		snapshotSingleton.Output(filepath.Join(snapshotDir, tc.dir, "shared", tc.name+".so"))
+	}
+}
+
+func TestVendorSnapshotExcludeArch(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		arch: {
+			arm: {
+				exclude_from_snapshot: true,
+			},
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotDir := "vendor-snapshot"
+	snapshotVariantPath := filepath.Join("out/soong", snapshotDir, "arm64")
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor", "libvendor.so",
+		filepath.Join(snapshotVariantPath, "arch-arm64-armv8-a", "shared"),
+		"android_vendor.29_arm64_armv8-a_shared")
+	checkSnapshotExclude(t, ctx, snapshotSingleton, "libvendor", "libvendor.so",
+		filepath.Join(snapshotVariantPath, "arch-arm-armv7-a-neon", "shared"),
+		"android_vendor.29_arm_armv7-a-neon_shared")
+}
+
+func TestVendorSnapshotMissingDeps(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor_available",
+		vendor_available: true,
+		nocrt: true,
+		system_shared_libs: [],
+		stl: "none",
+		arch: {
+			arm64: {
+				exclude_from_snapshot: true,
+			},
+		},
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		system_shared_libs: [],
+		stl: "none",
+		compile_multilib: "64",
+		shared_libs: ["libvendor_available"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	// Missing dependencies are warnings unless StrictSnapshotDeps is set.
+	report := android.ContentFromFileRuleForTests(t,
+		ctx.SingletonForTests("vendor-snapshot").Output("out/soong/vendor-snapshot/vendor-warnings.txt"))
+	android.AssertStringDoesContain(t, "warnings", report, `dependencies ["libvendor_available"] of`)
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.StrictSnapshotDeps = true
+	testCcErrorWithConfig(t, `dependencies \["libvendor_available"\] of .*libvendor.so.json" aren't captured`, config)
+}
+
+func TestVendorSnapshotMissingRequired(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libsystem",
+		nocrt: true,
+		system_shared_libs: [],
+		stl: "none",
+	}
+
+	cc_library_shared {
+		name: "libvendor_required",
+		vendor: true,
+		nocrt: true,
+		system_shared_libs: [],
+		stl: "none",
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		system_shared_libs: [],
+		stl: "none",
+		compile_multilib: "64",
+		required: ["libvendor_required", "libsystem"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	// Missing required modules are warnings unless StrictSnapshotDeps is set.
+	report := android.ContentFromFileRuleForTests(t,
+		ctx.SingletonForTests("vendor-snapshot").Output("out/soong/vendor-snapshot/vendor-warnings.txt"))
+	android.AssertStringDoesContain(t, "warnings", report, `required modules ["libsystem"] of`)
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.StrictSnapshotDeps = true
+	testCcErrorWithConfig(t, `required modules \["libsystem"\] of .*libvendor.so.json" aren't captured`, config)
+}
+
+func TestVendorSnapshotDlopenLibs(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libplugin",
+		vendor: true,
+		nocrt: true,
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		snapshot_dlopen_libs: ["libplugin"],
+	}
+
+	cc_binary {
+		name: "bin",
+		vendor: true,
+		nocrt: true,
+		snapshot_dlopen_libs: ["libplugin_missing"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertDeepEquals(t, "DlopenLibs", []string{"libplugin"}, prop.DlopenLibs)
+
+	// Uncaptured dlopen libs are reported like uncaptured linked libs.
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.StrictSnapshotDeps = true
+	testCcErrorWithConfig(t, `dependencies \["libplugin_missing"\] of .*bin.json" aren't captured`, config)
+}
+
+func TestVendorSnapshotMaxFileSize(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BoardVendorSnapshotMaxFileSizeMB = 100
+	config.TestProductVariables.StrictSnapshotMaxFileSize = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	stamp := "out/soong/vendor-snapshot/vendor-file-sizes.stamp"
+	check := snapshotSingleton.Output(stamp)
+	android.AssertStringDoesContain(t, "size check command", check.RuleParams.Command, "-gt 104857600")
+	android.AssertStringDoesContain(t, "size check command", check.RuleParams.Command, "error:")
+	lib := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so"
+	if !android.InList(lib, check.Implicits.Strings()) {
+		t.Errorf("expected %q in size check inputs, got %q", lib, check.Implicits.Strings())
+	}
+
+	zip := snapshotSingleton.Output("out/soong/vendor-snapshot/vendor-test_device.zip")
+	android.AssertPathsRelativeToTopEquals(t, "zip validations", []string{stamp}, zip.Validations)
+}
+
+func TestVendorSnapshotWarnings(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		export_include_dirs: ["include_empty"],
+	}
+`
+	fs := map[string][]byte{
+		"include_empty/README.txt": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	// Findings of the checks which aren't strict are reported when the snapshot is built.
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	report := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output("out/soong/vendor-snapshot/vendor-warnings.txt"))
+	android.AssertStringDoesContain(t, "warnings", report,
+		`exported directory "include/include_empty" of "vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json" has no headers`)
+
+	stamp := "out/soong/vendor-snapshot/vendor-warnings.stamp"
+	zip := snapshotSingleton.Output("out/soong/vendor-snapshot/vendor-test_device.zip")
+	android.AssertPathsRelativeToTopEquals(t, "zip validations", []string{stamp}, zip.Validations)
+
+	config = testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.StrictSnapshotExportedDirs = true
+	testCcErrorWithConfig(t, `exported directory "include/include_empty" of .*libvendor.so.json" has no headers`, config)
+}
+
+func TestVendorSnapshotAllowlist(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	fs := map[string][]byte{
+		"vendor/allowlist.txt": []byte("# reviewed libraries\nlibvendor\n"),
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.BoardVendorSnapshotAllowlist = StringPtr("vendor/allowlist.txt")
+	config.TestProductVariables.StrictSnapshotAllowlist = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	modules := "out/soong/vendor-snapshot/vendor-captured-modules.list"
+	content := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output(modules))
+	// The CFI variant of libvendor.a is listed as libvendor, as in the allowlist.
+	android.AssertDeepEquals(t, "captured modules", []string{"libvendor"}, strings.Split(strings.TrimSpace(content), "\n"))
+
+	stamp := "out/soong/vendor-snapshot/vendor-allowlist.stamp"
+	check := snapshotSingleton.Output(stamp)
+	android.AssertStringDoesContain(t, "allowlist check command", check.RuleParams.Command, "error:")
+	android.AssertStringDoesContain(t, "allowlist check command", check.RuleParams.Command, "vendor/allowlist.txt")
+
+	zip := snapshotSingleton.Output("out/soong/vendor-snapshot/vendor-test_device.zip")
+	android.AssertPathsRelativeToTopEquals(t, "zip validations", []string{stamp}, zip.Validations)
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BoardVendorSnapshotAllowlist = StringPtr("vendor/allowlist.txt")
+	testCcErrorWithConfig(t, `allowlist "vendor/allowlist.txt" of the vendor snapshot doesn't exist`, config)
+}
+
+func TestVendorSnapshotTotalSize(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	vendorSnapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	total := vendorSnapshotSingleton.Output("out/soong/vendor-snapshot/vendor-total-size.txt")
+	android.AssertStringDoesContain(t, "total size command", total.RuleParams.Command, "stat -L -c %s")
+	lib := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so"
+	if !android.InList(lib, total.Implicits.Strings()) {
+		t.Errorf("expected %q in total size inputs, got %q", lib, total.Implicits.Strings())
+	}
+	android.AssertStringEquals(t, "exported total size file", total.Output.String(),
+		vendorSnapshotSingleton.Singleton().(*snapshotSingleton).totalSizeFile.String())
+
+	// The total size isn't computed if the vendor snapshot isn't generated.
+	config = TestConfig(t.TempDir(), android.Android, nil, bp, nil)
+	config.TestProductVariables.Platform_vndk_version = StringPtr("29")
+	ctx = testCcWithConfig(t, config)
+
+	vendorSnapshotSingleton = ctx.SingletonForTests("vendor-snapshot")
+	if vendorSnapshotSingleton.MaybeOutput("out/soong/vendor-snapshot/vendor-total-size.txt").Rule != nil {
+		t.Errorf("total size must not be computed if the vendor snapshot isn't generated")
+	}
+	if vendorSnapshotSingleton.Singleton().(*snapshotSingleton).totalSizeFile.Valid() {
+		t.Errorf("total size must not be exported if the vendor snapshot isn't generated")
+	}
+}
+
+func TestVendorSnapshotSplitZips(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		export_include_dirs: ["include"],
+	}
+
+	cc_binary {
+		name: "bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	fs := map[string][]byte{
+		"include/a.h": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.SplitSnapshotZips = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	if snapshotSingleton.MaybeOutput("out/soong/vendor-snapshot/vendor-test_device.zip").Rule != nil {
+		t.Errorf("unexpected combined snapshot zip")
+	}
+
+	archDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a"
+	for _, tc := range []struct {
+		zipType string
+		file    string
+	}{
+		{"shared", filepath.Join(archDir, "shared/libvendor.so")},
+		{"static", filepath.Join(archDir, "static/libvendor.a")},
+		{"headers", "out/soong/vendor-snapshot/arm64/include/include/a.h"},
+		{"binary", filepath.Join(archDir, "binary/bin")},
+	} {
+		zip := snapshotSingleton.Output("out/soong/vendor-snapshot/vendor-test_device-" + tc.zipType + ".zip")
+		if !android.InList(tc.file, zip.Inputs.Strings()) {
+			t.Errorf("expected %q in the %s zip, got %q", tc.file, tc.zipType, zip.Inputs.Strings())
+		}
+	}
+}
+
+func TestSplitSnapshotOutputs(t *testing.T) {
+	archDir := "out/soong/vendor-snapshot/arm64"
+	outputs := android.PathsForTesting(
+		archDir+"/arch-arm64-armv8-a/shared/libfoo.so",
+		archDir+"/experimental/arch-arm64-armv8-a/shared/libx.so",
+		archDir+"/odm/arch-arm64-armv8-a/binary/bin",
+		archDir+"/product-variants/foo/arch-arm64-armv8-a/static/liby.a",
+		archDir+"/include/foo/a.h",
+		archDir+"/arch-arm64-armv8-a/crt.json",
+		archDir+"/manifest.json",
+	)
+	got := splitSnapshotOutputs(outputs, archDir, "include")
+	for zipType, expected := range map[string][]string{
+		"shared": {
+			archDir + "/arch-arm64-armv8-a/shared/libfoo.so",
+			archDir + "/experimental/arch-arm64-armv8-a/shared/libx.so",
+		},
+		"binary":  {archDir + "/odm/arch-arm64-armv8-a/binary/bin"},
+		"static":  {archDir + "/product-variants/foo/arch-arm64-armv8-a/static/liby.a"},
+		"headers": {archDir + "/include/foo/a.h"},
+		"other":   {archDir + "/arch-arm64-armv8-a/crt.json", archDir + "/manifest.json"},
+	} {
+		android.AssertDeepEquals(t, zipType+" outputs", expected, got[zipType].Strings())
+	}
+}
+
+func TestVendorSnapshotBlueprint(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		export_include_dirs: ["include"],
+	}
+
+	cc_binary {
+		name: "bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		shared_libs: ["libvendor"],
+	}
+`
+	fs := map[string][]byte{
+		"include/a.h": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.BuildSnapshotBlueprints = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	content := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/Android.bp"))
+	for _, expected := range []string{
+		"vendor_snapshot {\n    name: \"vendor_snapshot\",\n    version: \"29\",\n",
+		"vendor_snapshot_shared {\n    name: \"libvendor\",\n    version: \"29\",\n    target_arch: \"arm64\",\n" +
+			"    vendor: true,\n    compile_multilib: \"64\",\n",
+		"src: \"arch-arm64-armv8-a/shared/libvendor.so\",\n            export_include_dirs: [\n                \"include/include\",\n",
+		"src: \"arch-arm64-armv8-a/static/libvendor.a\",",
+		"vendor_snapshot_binary {\n    name: \"bin\",",
+		"src: \"arch-arm64-armv8-a/binary/bin\",",
+	} {
+		android.AssertStringDoesContain(t, "Android.bp", content, expected)
+	}
+
+	// The fake snapshot has no prebuilts to declare.
+	if ctx.SingletonForTests("vendor-fake-snapshot").MaybeOutput("out/soong/fake/vendor-snapshot/arm64/Android.bp").Rule != nil {
+		t.Errorf("unexpected Android.bp in the fake snapshot")
+	}
+}
+
+func TestVendorSnapshotHeaderLibs(t *testing.T) {
+	bp := `
+	cc_library_headers {
+		name: "libvendor_headers",
+		vendor: true,
+		export_include_dirs: ["include"],
+	}
+
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		header_libs: ["libvendor_headers"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static/libvendor.a.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	if !android.InList("libvendor_headers", prop.HeaderLibs) {
+		t.Errorf("expected %q in HeaderLibs, got %q", "libvendor_headers", prop.HeaderLibs)
+	}
+}
+
+func TestVendorSnapshotReexportedHeaderLibs(t *testing.T) {
+	bp := `
+	cc_library_headers {
+		name: "libvendor_headers_a",
+		vendor: true,
+		export_include_dirs: ["include_a"],
+		header_libs: ["libvendor_headers_b"],
+		export_header_lib_headers: ["libvendor_headers_b"],
+	}
+
+	cc_library_headers {
+		name: "libvendor_headers_b",
+		vendor: true,
+		export_include_dirs: ["include_b"],
+	}
+`
+	fs := map[string][]byte{
+		"include_a/a.h": nil,
+		"include_b/b.h": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/header/libvendor_headers_a.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertDeepEquals(t, "HeaderLibs", []string{"libvendor_headers_b"}, prop.HeaderLibs)
+	android.AssertDeepEquals(t, "ExportedDirs",
+		[]string{"include/include_a", "include/include_b"}, prop.ExportedDirs)
+
+	// The reexported headers are captured with libvendor_headers_b.
+	snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/include/include_b/b.h")
+}
+
+func TestVendorSnapshotSbom(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BuildSnapshotSbom = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sbom := snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/sbom.spdx.json")
+	android.AssertStringDoesContain(t, "SBOM command", sbom.RuleParams.Command, "gen_snapshot_sbom")
+
+	var entries []snapshotSbomEntry
+	metadata := "out/soong/vendor-snapshot/vendor-test_device-sbom-metadata.json"
+	readSnapshotJson(t, snapshotSingleton, metadata, &entries)
+	found := false
+	for _, entry := range entries {
+		if entry.Path == "arch-arm64-armv8-a/shared/libvendor.so" {
+			found = true
+			android.AssertStringEquals(t, "Module", "libvendor", entry.Module)
+		}
+	}
+	if !found {
+		t.Errorf("expected libvendor.so in the SBOM metadata, got %v", entries)
+	}
+}
+
+func TestVendorSnapshotExportHeaders(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		export_include_dirs: ["include"],
+	}
+
+	cc_library_shared {
+		name: "libvendor_noheaders",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		export_include_dirs: ["include_noheaders"],
+		snapshot_export_headers: false,
+	}
+`
+	fs := map[string][]byte{
+		"include/a.h":           nil,
+		"include_noheaders/b.h": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/include/include/a.h")
+	if snapshotSingleton.MaybeOutput("out/soong/vendor-snapshot/arm64/include/include_noheaders/b.h").Rule != nil {
+		t.Errorf("unexpected header of libvendor_noheaders captured")
+	}
+
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor_noheaders.so.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertDeepEquals(t, "ExportedDirs", []string(nil), prop.ExportedDirs)
+	snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor_noheaders.so")
+}
+
+func TestVendorSnapshotFrozenApiLevel(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertStringEquals(t, "FrozenApiLevel", "29", prop.FrozenApiLevel)
+
+	var manifest snapshotManifest
+	manifestFile := "out/soong/vendor-snapshot/arm64/manifest.json"
+	readSnapshotJson(t, snapshotSingleton, manifestFile, &manifest)
+	android.AssertStringEquals(t, "Image", "vendor", manifest.Image)
+	android.AssertStringEquals(t, "FrozenApiLevel", "29", manifest.FrozenApiLevel)
+}
+
+func TestVendorSnapshotNativeBridgeSupported(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		native_bridge_supported: true,
+	}
+
+	cc_library_shared {
+		name: "libvendor_nonb",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, tc := range []struct {
+		jsonFile  string
+		supported bool
+	}{
+		{"libvendor.so.json", true},
+		{"libvendor_nonb.so.json", false},
+	} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(sharedDir, tc.jsonFile)
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertBoolEquals(t, "NativeBridgeSupported of "+tc.jsonFile, tc.supported, prop.NativeBridgeSupported)
+	}
+}
+
+func TestVendorSnapshotManifestModuleArches(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libboth",
+		vendor: true,
+		nocrt: true,
+	}
+
+	cc_library_shared {
+		name: "lib64",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var manifest snapshotManifest
+	manifestFile := "out/soong/vendor-snapshot/arm64/manifest.json"
+	readSnapshotJson(t, snapshotSingleton, manifestFile, &manifest)
+	android.AssertDeepEquals(t, "arches of libboth",
+		[]string{"arch-arm-armv7-a-neon", "arch-arm64-armv8-a"}, manifest.ModuleArches["libboth"])
+	android.AssertDeepEquals(t, "arches of lib64",
+		[]string{"arch-arm64-armv8-a"}, manifest.ModuleArches["lib64"])
+}
+
+func TestVendorSnapshotManifestRevision(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.SnapshotManifestRevision = StringPtr("0123abcd")
+	config.TestProductVariables.SnapshotPinnedManifestRevision = StringPtr("0123abcd")
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var manifest snapshotManifest
+	manifestFile := "out/soong/vendor-snapshot/arm64/manifest.json"
+	readSnapshotJson(t, snapshotSingleton, manifestFile, &manifest)
+	android.AssertStringEquals(t, "ManifestRevision", "0123abcd", manifest.ManifestRevision)
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.SnapshotManifestRevision = StringPtr("0123abcd")
+	config.TestProductVariables.SnapshotPinnedManifestRevision = StringPtr("4567cdef")
+	testCcErrorWithConfig(t, `the vendor snapshot is pinned to manifest revision "4567cdef", but the sources are at "0123abcd"`, config)
+}
+
+func TestVendorSnapshotLinkerConfigs(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	fs := map[string][]byte{
+		"device/etc/ld.config.vendor.txt":        nil,
+		"device/etc/public.libraries-vendor.txt": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.BoardVendorSnapshotLinkerConfigs = []string{
+		"device/etc/public.libraries-vendor.txt",
+		"device/etc/ld.config.vendor.txt",
+	}
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var manifest snapshotManifest
+	manifestFile := "out/soong/vendor-snapshot/arm64/manifest.json"
+	readSnapshotJson(t, snapshotSingleton, manifestFile, &manifest)
+	android.AssertDeepEquals(t, "LinkerConfigs", []string{
+		"linkerconfig/ld.config.vendor.txt",
+		"linkerconfig/public.libraries-vendor.txt",
+	}, manifest.LinkerConfigs)
+	for _, file := range manifest.LinkerConfigs {
+		snapshotSingleton.Output(filepath.Join("out/soong/vendor-snapshot/arm64", file))
+	}
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BoardVendorSnapshotLinkerConfigs = []string{"device/etc/ld.config.missing.txt"}
+	testCcErrorWithConfig(t, `linker config "device/etc/ld.config.missing.txt" of the vendor snapshot doesn't exist`, config)
+}
+
+func TestSnapshotImageVariant(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libfoo",
+		vendor_available: true,
+		recovery_available: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.RecoverySnapshotVersion = StringPtr("current")
+	ctx := testCcWithConfig(t, config)
+
+	for _, image := range []string{"vendor", "recovery"} {
+		snapshotSingleton := ctx.SingletonForTests(image + "-snapshot")
+
+		var prop snapshotJsonFlags
+		jsonFile := "out/soong/" + image + "-snapshot/arm64/arch-arm64-armv8-a/shared/libfoo.so.json"
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "ImageVariant of "+jsonFile, image, prop.ImageVariant)
+
+		var manifest snapshotManifest
+		manifestFile := "out/soong/" + image + "-snapshot/arm64/manifest.json"
+		readSnapshotJson(t, snapshotSingleton, manifestFile, &manifest)
+		android.AssertStringEquals(t, "ImageVariant of "+manifestFile, image, manifest.ImageVariant)
+	}
+}
+
+func TestVendorSnapshotProvenance(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	provenanceFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.provenance.json"
+
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	if ctx.SingletonForTests("vendor-snapshot").MaybeOutput(provenanceFile).Rule != nil {
+		t.Errorf("%q not expected without BuildSnapshotProvenance", provenanceFile)
+	}
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BuildSnapshotProvenance = true
+	ctx = testCcWithConfig(t, config)
+
+	var provenance snapshotProvenance
+	readSnapshotJson(t, ctx.SingletonForTests("vendor-snapshot"), provenanceFile, &provenance)
+	android.AssertStringEquals(t, "ModuleType", "cc_library_shared", provenance.ModuleType)
+	android.AssertStringEquals(t, "BlueprintFile", "Android.bp", provenance.BlueprintFile)
+	android.AssertStringEquals(t, "Variant", "android_vendor.29_arm64_armv8-a_shared", provenance.Variant)
+}
+
+func TestVendorSnapshotArchiveMembers(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	staticDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static"
+	membersFile := filepath.Join(staticDir, "libvendor.members.txt")
+
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	if ctx.SingletonForTests("vendor-snapshot").MaybeOutput(membersFile).Rule != nil {
+		t.Errorf("%q not expected without BuildSnapshotArchiveMembers", membersFile)
+	}
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BuildSnapshotArchiveMembers = true
+	ctx = testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	members := snapshotSingleton.Output(membersFile)
+	android.AssertPathRelativeToTopEquals(t, "members input",
+		android.PathRelativeToTop(snapshotSingleton.Output(filepath.Join(staticDir, "libvendor.a")).Input),
+		members.Input)
+}
+
+func TestVendorSnapshotDirFilter(t *testing.T) {
+	libBp := `
+	cc_library_shared {
+		name: "%s",
+		vendor_available: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	mockFS := map[string][]byte{
+		"deps/Android.bp":               []byte(GatherRequiredDepsForTest(android.Android)),
+		"framework/team/Android.bp":     []byte(fmt.Sprintf(libBp, "libteam")),
+		"framework/team/sub/Android.bp": []byte(fmt.Sprintf(libBp, "libteam_sub")),
+		"framework/team2/Android.bp":    []byte(fmt.Sprintf(libBp, "libteam2")),
+	}
+
+	config := testSnapshotConfig(t, "", mockFS)
+	config.TestProductVariables.BoardVendorSnapshotDirFilter = []string{"framework/team"}
+	ctx := CreateTestContext(config)
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"deps/Android.bp", "framework/team/Android.bp",
+		"framework/team/sub/Android.bp", "framework/team2/Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfErrored(t, errs)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	snapshotSingleton.Output(filepath.Join(sharedDir, "libteam.so.json"))
+	snapshotSingleton.Output(filepath.Join(sharedDir, "libteam_sub.so.json"))
+	if snapshotSingleton.MaybeOutput(filepath.Join(sharedDir, "libteam2.so.json")).Rule != nil {
+		t.Errorf("libteam2 outside of the filtered directories is captured")
+	}
+}
+
+func TestVendorSnapshotArches(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BoardVendorSnapshotArches = []string{"arm64"}
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotDir := "out/soong/vendor-snapshot/arm64"
+	snapshotSingleton.Output(filepath.Join(snapshotDir, "arch-arm64-armv8-a/shared/libvendor.so.json"))
+	if snapshotSingleton.MaybeOutput(filepath.Join(snapshotDir, "arch-arm-armv7-a-neon/shared/libvendor.so.json")).Rule != nil {
+		t.Errorf("libvendor of the excluded arch is captured")
+	}
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BoardVendorSnapshotArches = []string{"x86"}
+	testCcErrorWithConfig(t, `arch "x86" of the vendor snapshot isn't built`, config)
+}
+
+func TestVendorSnapshotHeadersOnly(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		export_include_dirs: ["include/libvendor"],
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libvendor_cfi",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		version_script: "libvendor_cfi.map.txt",
+		stubs: {
+			symbol_file: "libvendor_cfi.stubs.map.txt",
+			versions: ["29"],
+		},
+		sanitize: {
+			cfi: true,
+		},
+	}
+
+	cc_binary {
+		name: "bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	fs := map[string][]byte{
+		"include/libvendor/a.h":                 nil,
+		"libvendor_cfi.map.txt":                 nil,
+		"libvendor_cfi.stubs.map.txt":           nil,
+		"build/soong/cc/config/cfi_exports.map": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.BoardVendorSnapshotHeadersOnly = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotDir := "out/soong/vendor-snapshot/arm64"
+	archDir := filepath.Join(snapshotDir, "arch-arm64-armv8-a")
+
+	// Headers and json flag files are captured.
+	for _, out := range []string{
+		filepath.Join(snapshotDir, "include/include/libvendor/a.h"),
+		filepath.Join(archDir, "shared/libvendor.so.json"),
+		filepath.Join(archDir, "static/libvendor.a.json"),
+		filepath.Join(archDir, "binary/bin.json"),
+	} {
+		snapshotSingleton.Output(out)
+	}
+
+	// Prebuilt binaries, and the files used to link against them, aren't.
+	for _, out := range []string{
+		filepath.Join(archDir, "shared/libvendor.so"),
+		filepath.Join(archDir, "static/libvendor.a"),
+		filepath.Join(archDir, "binary/bin"),
+		filepath.Join(snapshotDir, "cfi-exports/cfi_exports.map"),
+		filepath.Join(archDir, "version-scripts/libvendor_cfi/libvendor_cfi.map.txt"),
+		filepath.Join(archDir, "stubs/29/libvendor_cfi.stubs.map.txt"),
+		filepath.Join(snapshotDir, "symbol-files/libvendor_cfi/libvendor_cfi.stubs.map.txt"),
+	} {
+		if snapshotSingleton.MaybeOutput(out).Rule != nil {
+			t.Errorf("%q not expected in a headers-only snapshot", out)
+		}
+	}
+
+	var prop snapshotJsonFlags
+	jsonFile := filepath.Join(archDir, "shared/libvendor_cfi.so.json")
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertStringEquals(t, "CfiExportsMap", "", prop.CfiExportsMap)
+	android.AssertStringEquals(t, "VersionScript", "", prop.VersionScript)
+	android.AssertStringEquals(t, "StubsSymbolFile", "", prop.StubsSymbolFile)
+}
+
+func TestVendorSnapshotPrerelease(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	for _, prerelease := range []bool{false, true} {
+		config := testSnapshotConfig(t, bp, nil)
+		config.TestProductVariables.SnapshotPrerelease = prerelease
+		ctx := testCcWithConfig(t, config)
+
+		snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+		var prop snapshotJsonFlags
+		jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json"
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertBoolEquals(t, "Prerelease of "+jsonFile, prerelease, prop.Prerelease)
+
+		var manifest snapshotManifest
+		manifestFile := "out/soong/vendor-snapshot/arm64/manifest.json"
+		readSnapshotJson(t, snapshotSingleton, manifestFile, &manifest)
+		android.AssertBoolEquals(t, "Prerelease of "+manifestFile, prerelease, manifest.Prerelease)
+	}
+}
+
+func TestVendorSnapshotForceGenerate(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	forcedVersionFile := "out/soong/vendor-snapshot/arm64/FORCED_VERSION"
+	zipFile := "out/soong/vendor-snapshot/vendor-test_device.zip"
+	for _, tc := range []struct {
+		name          string
+		vndkVersion   string
+		force         bool
+		forcedVersion string
+		// The expected content of FORCED_VERSION, or empty if the snapshot isn't forced.
+		expected string
+		// Whether the snapshot is generated at all.
+		generated bool
+	}{
+		{"not forced", "28", false, "", "", false},
+		{"forced with a version", "28", true, "99", "99", true},
+		{"forced without a version", "28", true, "", "forced", true},
+		// The forced version never overrides a snapshot generated for BOARD_VNDK_VERSION=current.
+		{"forced with current", "current", true, "99", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testSnapshotConfig(t, bp, nil)
+			config.TestProductVariables.DeviceVndkVersion = StringPtr(tc.vndkVersion)
+			config.TestProductVariables.BoardVendorSnapshotForceGenerate = tc.force
+			if tc.forcedVersion != "" {
+				config.TestProductVariables.BoardVendorSnapshotForcedVersion = StringPtr(tc.forcedVersion)
+			}
+			ctx := testCcWithConfig(t, config)
+
+			snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+			android.AssertBoolEquals(t, "snapshot generated", tc.generated,
+				snapshotSingleton.MaybeOutput(zipFile).Rule != nil)
+			versionFile := snapshotSingleton.MaybeOutput(forcedVersionFile)
+			if tc.expected == "" {
+				if versionFile.Rule != nil {
+					t.Errorf("%s is generated for a snapshot that isn't forced", forcedVersionFile)
+				}
+				return
+			}
+			android.AssertStringEquals(t, "FORCED_VERSION", tc.expected,
+				android.ContentFromFileRuleForTests(t, versionFile))
+		})
+	}
+}
+
+func TestVendorSnapshotLlndk(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libllndk",
+		llndk: {
+			symbol_file: "libllndk.map.txt",
+		},
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		shared_libs: ["libllndk"],
+	}
+`
+	fs := map[string][]byte{
+		"libllndk.map.txt": nil,
+	}
+	llndkJson := "out/soong/vendor-snapshot/arm64/llndk/llndk.json"
+
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	if ctx.SingletonForTests("vendor-snapshot").MaybeOutput(llndkJson).Rule != nil {
+		t.Errorf("%q not expected without BuildSnapshotLlndk", llndkJson)
+	}
+
+	config = testSnapshotConfig(t, bp, fs)
+	config.TestProductVariables.BuildSnapshotLlndk = true
+	ctx = testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var manifest snapshotLlndkManifest
+	readSnapshotJson(t, snapshotSingleton, llndkJson, &manifest)
+	android.AssertDeepEquals(t, "LLNDK libraries", []snapshotLlndkLibrary{
+		{
+			Name:       "libllndk",
+			SymbolFile: "llndk/libllndk/libllndk.map.txt",
+			Versions:   []string{"current"},
+		},
+	}, manifest.Libraries)
+
+	symbolFile := snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/llndk/libllndk/libllndk.map.txt")
+	android.AssertStringEquals(t, "symbol file source", "libllndk.map.txt", symbolFile.Input.String())
+
+	// The LLNDK stubs are still not captured as shared libraries.
+	sharedJson := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libllndk.so.json"
+	if snapshotSingleton.MaybeOutput(sharedJson).Rule != nil {
+		t.Errorf("%q not expected", sharedJson)
+	}
+}
+
+func TestVendorSnapshotCrtObjects(t *testing.T) {
+	bp := `
+	cc_object {
+		name: "obj",
+		vendor_available: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	for _, archDir := range []string{"arch-arm64-armv8-a", "arch-arm-armv7-a-neon"} {
+		crtJson := filepath.Join("out/soong/vendor-snapshot/arm64", archDir, "crt.json")
+		var crtObjects map[string]string
+		readSnapshotJson(t, snapshotSingleton, crtJson, &crtObjects)
+		// Only CRT objects are listed, not obj.
+		android.AssertDeepEquals(t, "CRT objects of "+archDir, map[string]string{
+			"crtbegin_dynamic": "object/crtbegin_dynamic.o",
+			"crtbegin_so":      "object/crtbegin_so.o",
+			"crtbegin_static":  "object/crtbegin_static.o",
+			"crtend_android":   "object/crtend_android.o",
+			"crtend_so":        "object/crtend_so.o",
+		}, crtObjects)
+	}
+}
+
+func TestVendorSnapshotNonInstallableLibs(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor_noinstall",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		installable: false,
+	}
+`
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor_noinstall.so.json"
+
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	if ctx.SingletonForTests("vendor-snapshot").MaybeOutput(jsonFile).Rule != nil {
+		t.Errorf("%q not expected without SnapshotNonInstallableLibs", jsonFile)
+	}
+
+	config = testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.SnapshotNonInstallableLibs = true
+	ctx = testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	checkSnapshot(t, ctx, snapshotSingleton, "libvendor_noinstall", "libvendor_noinstall.so",
+		"out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared", "android_vendor.29_arm64_armv8-a_shared")
+
+	var prop snapshotJsonFlags
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertDeepEquals(t, "Installable", BoolPtr(false), prop.Installable)
+}
+
+func TestValidateSnapshotJson(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		json string
+		err  string
+	}{
+		{"valid", `{"ModuleName":"libfoo","SharedLibs":["libc"]}`, ""},
+		{"unknown field", `{"ModuleName":"libfoo","Unknown":true}`, `field "Unknown" isn't in version 1 of the snapshot json schema`},
+		{"missing field", `{"SharedLibs":["libc"]}`, `required field "ModuleName" is missing`},
+	} {
+		err := validateSnapshotJson([]byte(tc.json))
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", tc.name, err)
+			}
+		} else if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	bp := `
+	cc_library {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+	}
+`
+	for _, sortKeys := range []bool{false, true} {
+		config := testSnapshotConfig(t, bp, nil)
+		config.TestProductVariables.SortSnapshotJsonKeys = sortKeys
+		config.TestProductVariables.ValidateSnapshotJson = true
+		ctx := testCcWithConfig(t, config)
+
+		snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+		manifestFile := "out/soong/vendor-snapshot/arm64/manifest.json"
+		var manifest snapshotManifest
+		readSnapshotJson(t, snapshotSingleton, manifestFile, &manifest)
+		android.AssertIntEquals(t, "JsonSchemaVersion", snapshotJsonSchemaVersion, manifest.JsonSchemaVersion)
+
+		// The written json flags conform to the schema, and they don't anymore once a required
+		// field is dropped.
+		jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libvendor.so.json"
+		content := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output(jsonFile))
+		if err := validateSnapshotJson([]byte(content)); err != nil {
+			t.Errorf("%q doesn't conform to the schema: %s", jsonFile, err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(content), &fields); err != nil {
+			t.Fatalf("failed to parse %q: %s", jsonFile, err)
+		}
+		delete(fields, "ModuleName")
+		j, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatalf("failed to marshal %q: %s", jsonFile, err)
+		}
+		if err := validateSnapshotJson(j); err == nil || err.Error() != `required field "ModuleName" is missing` {
+			t.Errorf("expected the missing ModuleName of %q to be reported, got %v", jsonFile, err)
+		}
+	}
+}
+
+func TestVendorSnapshotPositionIndependence(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_binary {
+		name: "bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_binary {
+		name: "bin_nopie",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		cflags: ["-fno-pie"],
+		ldflags: ["-no-pie"],
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	archDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a"
+	for _, tc := range []struct {
+		jsonFile string
+		pic      bool
+		pie      bool
+	}{
+		{"shared/libvendor.so.json", true, false},
+		{"static/libvendor.a.json", true, false},
+		{"binary/bin.json", true, true},
+		{"binary/bin_nopie.json", false, false},
+	} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(archDir, tc.jsonFile)
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertBoolEquals(t, "Pic of "+tc.jsonFile, tc.pic, prop.Pic)
+		android.AssertBoolEquals(t, "Pie of "+tc.jsonFile, tc.pie, prop.Pie)
+	}
+}
+
+func TestVendorSnapshotExcludeHeaders(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		export_include_dirs: ["include"],
+		snapshot_exclude_headers: ["*_internal.h"],
+	}
+`
+	fs := map[string][]byte{
+		"include/a.h":          nil,
+		"include/a_internal.h": nil,
+	}
+	config := testSnapshotConfig(t, bp, fs)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/include/include/a.h")
+	if snapshotSingleton.MaybeOutput("out/soong/vendor-snapshot/arm64/include/include/a_internal.h").Rule != nil {
+		t.Errorf("unexpected excluded header a_internal.h captured")
+	}
+}
+
+func TestVendorSnapshotInstallPathCollision(t *testing.T) {
+	bp := `
+	cc_binary {
+		name: "bin_a",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		stem: "tool",
+	}
+
+	cc_binary {
+		name: "bin_b",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		stem: "tool",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	testCcErrorWithConfig(t, `"bin_[ab]" and "bin_[ab]" in the vendor snapshot are both installed to "vendor/bin/tool"`, config)
+
+	// Binaries are installed by their stems, so a binary named after the stem of another one
+	// collides with it, and a binary renamed with stem doesn't collide with its module name.
+	bp = `
+	cc_binary {
+		name: "tool",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_binary {
+		name: "bin_c",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		stem: "tool",
+	}
+`
+	config = testSnapshotConfig(t, bp, nil)
+	testCcErrorWithConfig(t, `"(tool|bin_c)" and "(tool|bin_c)" in the vendor snapshot are both installed to "vendor/bin/tool"`, config)
+
+	bp = `
+	cc_binary {
+		name: "bin_d",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		stem: "bin_e",
+	}
+
+	cc_binary {
+		name: "bin_e",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		stem: "bin_d",
+	}
+`
+	config = testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	binaryDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/binary"
+	for _, tc := range []struct {
+		stem       string
+		moduleName string
+	}{
+		{"bin_e", "bin_d"},
+		{"bin_d", "bin_e"},
+	} {
+		var prop snapshotJsonFlags
+		readSnapshotJson(t, snapshotSingleton, filepath.Join(binaryDir, tc.stem+".json"), &prop)
+		android.AssertStringEquals(t, "ModuleName of "+tc.stem, tc.moduleName, prop.ModuleName)
+	}
+}
+
+func TestVendorSnapshotMinSdkVersion(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		min_sdk_version: "29",
+	}
+
+	cc_binary {
+		name: "bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		min_sdk_version: "29",
+	}
+
+	cc_binary {
+		name: "bin_nominsdk",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	archDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a"
+	for _, tc := range []struct {
+		jsonFile      string
+		minSdkVersion string
+	}{
+		{"shared/libvendor.so.json", "29"},
+		{"binary/bin.json", "29"},
+		{"binary/bin_nominsdk.json", ""},
+	} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(archDir, tc.jsonFile)
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "MinSdkVersion of "+tc.jsonFile, tc.minSdkVersion, prop.MinSdkVersion)
+	}
+}
+
+func TestVendorSnapshotSdkVersion(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor_available",
+		vendor_available: true,
+		nocrt: true,
+		compile_multilib: "64",
+		sdk_version: "current",
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	// sdk_version is cleared for the vendor variant, which is not built against the SDK, but the
+	// snapshot still records it.
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, tc := range []struct {
+		jsonFile   string
+		sdkVersion string
+	}{
+		{"libvendor_available.so.json", "current"},
+		{"libvendor.so.json", ""},
+	} {
+		var prop snapshotJsonFlags
+		readSnapshotJson(t, snapshotSingleton, filepath.Join(sharedDir, tc.jsonFile), &prop)
+		android.AssertStringEquals(t, "SdkVersion of "+tc.jsonFile, tc.sdkVersion, prop.SdkVersion)
+	}
+
+	content := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output(filepath.Join(sharedDir, "libvendor.so.json")))
+	if strings.Contains(content, "SdkVersion") {
+		t.Errorf("SdkVersion is recorded for a module without sdk_version: %s", content)
+	}
+}
+
+func TestVendorSnapshotAfdoProfile(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		pgo: {
+			sampling: true,
+			profile_file: "libvendor.afdo",
+		},
+	}
+
+	cc_library_shared {
+		name: "libvendor_instr",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		pgo: {
+			instrumentation: true,
+			profile_file: "libvendor_instr.profdata",
+			benchmarks: ["bench"],
+		},
+	}
+`
+	fs := map[string][]byte{
+		"toolchain/pgo-profiles/libvendor.afdo":           nil,
+		"toolchain/pgo-profiles/libvendor_instr.profdata": nil,
+	}
+	archDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a"
+
+	for _, tc := range []struct {
+		capture     bool
+		afdoProfile string
+	}{
+		{false, "toolchain/pgo-profiles/libvendor.afdo"},
+		{true, "afdo/libvendor.afdo"},
+	} {
+		config := testSnapshotConfig(t, bp, fs)
+		config.TestProductVariables.BuildSnapshotAfdoProfiles = tc.capture
+		config.TestProductVariables.BuildSnapshotProfileData = true
+		ctx := testCcWithConfig(t, config)
+
+		snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+		for _, lib := range []struct {
+			name        string
+			afdoProfile string
+		}{
+			{"libvendor", tc.afdoProfile},
+			// instrumentation PGO profiles are not AFDO profiles
+			{"libvendor_instr", ""},
+		} {
+			var prop snapshotJsonFlags
+			jsonFile := filepath.Join(archDir, "shared", lib.name+".so.json")
+			readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+			android.AssertStringEquals(t, "AfdoProfile of "+lib.name, lib.afdoProfile, prop.AfdoProfile)
+		}
+
+		// AFDO profiles aren't captured as PGO profiles as well.
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(archDir, "shared", "libvendor.so.json")
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "ProfileData of libvendor", "", prop.ProfileData)
+		if snapshotSingleton.MaybeOutput("out/soong/vendor-snapshot/arm64/pgo/libvendor.afdo").Rule != nil {
+			t.Errorf("libvendor.afdo must not be captured as a PGO profile")
+		}
+
+		captured := snapshotSingleton.MaybeOutput("out/soong/vendor-snapshot/arm64/afdo/libvendor.afdo").Rule != nil
+		android.AssertBoolEquals(t, "libvendor.afdo captured", tc.capture, captured)
+	}
+}
+
+func TestVendorSnapshotBundledStl(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libvendor_bundled",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		stl: "libc++_static",
+	}
+
+	cc_library_shared {
+		name: "libvendor_nostl",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		stl: "none",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	archDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a"
+	for _, tc := range []struct {
+		jsonFile string
+		stl      string
+		bundled  bool
+	}{
+		{"shared/libvendor.so.json", "libc++", false},
+		{"static/libvendor.a.json", "libc++_static", false},
+		{"shared/libvendor_bundled.so.json", "libc++_static", true},
+		{"shared/libvendor_nostl.so.json", "", false},
+	} {
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(archDir, tc.jsonFile)
+		readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "Stl of "+tc.jsonFile, tc.stl, prop.Stl)
+		android.AssertBoolEquals(t, "BundledStl of "+tc.jsonFile, tc.bundled, prop.BundledStl)
+	}
+}
+
+func TestVendorSnapshotInlineUnstripped(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.InlineSnapshotUnstripped = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	var prop snapshotJsonFlags
+	jsonFile := filepath.Join(sharedDir, "libvendor.so.json")
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertStringEquals(t, "UnstrippedSharedLibrary", "libvendor.so.unstripped", prop.UnstrippedSharedLibrary)
+
+	unstripped := ctx.ModuleForTests("libvendor", "android_vendor.29_arm64_armv8-a_shared").Module().(*Module).UnstrippedOutputFile()
+	copied := snapshotSingleton.Output(filepath.Join(sharedDir, "libvendor.so.unstripped"))
+	android.AssertPathRelativeToTopEquals(t, "unstripped input", android.PathRelativeToTop(unstripped), copied.Input)
+	snapshotSingleton.Output(filepath.Join(sharedDir, "libvendor.so"))
+}
+
+func TestVendorSnapshotToc(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.BuildSnapshotTocs = true
+	config.TestProductVariables.BuildSnapshotBlueprints = true
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	var prop snapshotJsonFlags
+	jsonFile := filepath.Join(sharedDir, "libvendor.so.json")
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertStringEquals(t, "Toc", "libvendor.so.toc", prop.Toc)
+
+	toc := ctx.ModuleForTests("libvendor", "android_vendor.29_arm64_armv8-a_shared").Module().(*Module).Toc()
+	copied := snapshotSingleton.Output(filepath.Join(sharedDir, "libvendor.so.toc"))
+	android.AssertPathRelativeToTopEquals(t, "toc input", android.PathRelativeToTop(toc.Path()), copied.Input)
+
+	bpContent := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output("out/soong/vendor-snapshot/arm64/Android.bp"))
+	android.AssertStringDoesContain(t, "Android.bp", bpContent,
+		"src: \"arch-arm64-armv8-a/shared/libvendor.so\",\n            toc: \"arch-arm64-armv8-a/shared/libvendor.so.toc\",\n")
+}
+
+func TestVendorSnapshotSanitizeRuntimeLibs(t *testing.T) {
+	bp := `
+	cc_library_static {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		sanitize: {
+			diag: {
+				undefined: true,
+			},
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/static/libvendor.a.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	android.AssertBoolEquals(t, "SanitizeUbsanDep", true, prop.SanitizeUbsanDep)
+	if !android.InList("libclang_rt.ubsan_standalone-aarch64-android", prop.SanitizeRuntimeLibs) {
+		t.Errorf("expected the UBSan runtime in SanitizeRuntimeLibs, got %q", prop.SanitizeRuntimeLibs)
+	}
+}
+
+func TestVendorSnapshotZipLayout(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.SnapshotZipRootArchDir = true
+	config.TestProductVariables.SnapshotZipPrefix = StringPtr("vendor")
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	zip := snapshotSingleton.Output("out/soong/vendor-snapshot/vendor-test_device.zip")
+	android.AssertStringDoesContain(t, "zip command", zip.RuleParams.Command, "-P vendor -C out/soong/vendor-snapshot/arm64 ")
+}
 
-		// For object modules, all vendor:true and vendor_available modules are captured.
-		objectVariant := fmt.Sprintf("android_vendor.29_%s_%s", archType, archVariant)
-		objectDir := filepath.Join(snapshotVariantPath, archDir, "object")
-		checkSnapshot(t, ctx, snapshotSingleton, "obj", "obj.o", objectDir, objectVariant)
-		jsonFiles = append(jsonFiles, filepath.Join(objectDir, "obj.o.json"))
+func TestVendorSnapshotNeededLibs(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
 	}
 
-	for _, jsonFile := range jsonFiles {
-		// verify all json files exist
-		if snapshotSingleton.MaybeOutput(jsonFile).Rule == nil {
-			t.Errorf("%q expected but not found", jsonFile)
-		}
+	cc_binary {
+		name: "vendor_bin",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+		shared_libs: ["libvendor"],
 	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
 
-	// fake snapshot should have all outputs in the normal snapshot.
-	fakeSnapshotSingleton := ctx.SingletonForTests("vendor-fake-snapshot")
-	for _, output := range snapshotSingleton.AllOutputs() {
-		fakeOutput := strings.Replace(output, "/vendor-snapshot/", "/fake/vendor-snapshot/", 1)
-		if fakeSnapshotSingleton.MaybeOutput(fakeOutput).Rule == nil {
-			t.Errorf("%q expected but not found", fakeOutput)
-		}
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	var prop snapshotJsonFlags
+	jsonFile := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/binary/vendor_bin.json"
+	readSnapshotJson(t, snapshotSingleton, jsonFile, &prop)
+	if !android.InList("libvendor.so", prop.NeededLibs) {
+		t.Errorf("expected %q in NeededLibs, got %q", "libvendor.so", prop.NeededLibs)
+	}
+	if !sort.StringsAreSorted(prop.NeededLibs) {
+		t.Errorf("expected NeededLibs to be sorted, got %q", prop.NeededLibs)
 	}
 }
 
@@ -917,15 +4199,349 @@ func TestVendorSnapshotUse(t *testing.T) {
 		t.Errorf("libvendor must not have variant %#v, but it does", sharedVariant)
 	}
 
-	libvendorAvailableVariants := ctx.ModuleVariantsForTests("libvendor_available")
-	if inList(sharedVariant, libvendorAvailableVariants) {
-		t.Errorf("libvendor_available must not have variant %#v, but it does", sharedVariant)
+	libvendorAvailableVariants := ctx.ModuleVariantsForTests("libvendor_available")
+	if inList(sharedVariant, libvendorAvailableVariants) {
+		t.Errorf("libvendor_available must not have variant %#v, but it does", sharedVariant)
+	}
+
+	binVariants := ctx.ModuleVariantsForTests("bin")
+	if inList(binaryVariant, binVariants) {
+		t.Errorf("bin must not have variant %#v, but it does", sharedVariant)
+	}
+}
+
+func TestVendorSnapshotElfMachineCheck(t *testing.T) {
+	vendorProprietaryBp := `
+	vendor_snapshot {
+		name: "vendor_snapshot",
+		version: "31",
+		arch: {
+			arm64: {
+				shared_libs: ["libvendor"],
+			},
+			arm: {
+				shared_libs: ["libvendor"],
+			},
+		},
+	}
+
+	vendor_snapshot_shared {
+		name: "libvendor",
+		version: "31",
+		target_arch: "arm64",
+		compile_multilib: "both",
+		vendor: true,
+		arch: {
+			arm64: {
+				src: "libvendor.so",
+			},
+			arm: {
+				src: "libvendor32.so",
+			},
+		},
+	}
+`
+	depsBp := GatherRequiredDepsForTest(android.Android)
+
+	mockFS := map[string][]byte{
+		"deps/Android.bp":       []byte(depsBp),
+		"vendor/Android.bp":     []byte(vendorProprietaryBp),
+		"vendor/libvendor.so":   nil,
+		"vendor/libvendor32.so": nil,
+	}
+
+	config := TestConfig(t.TempDir(), android.Android, nil, "", mockFS)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("31")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("32")
+	config.TestProductVariables.ValidateSnapshotElfMachine = true
+	ctx := CreateTestContext(config)
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"deps/Android.bp", "vendor/Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfErrored(t, errs)
+
+	for _, tc := range []struct {
+		variant string
+		out     string
+		machine string
+	}{
+		{"android_vendor.31_arm64_armv8-a_shared", "checked/libvendor.so", "AArch64"},
+		{"android_vendor.31_arm_armv7-a-neon_shared", "checked/libvendor32.so", "ARM"},
+	} {
+		check := ctx.ModuleForTests("libvendor.vendor_shared.31.arm64", tc.variant).Output(tc.out)
+		android.AssertStringEquals(t, "ELF machine of "+tc.variant, tc.machine, check.Args["machine"])
+	}
+}
+
+func TestVendorSnapshotUseToc(t *testing.T) {
+	vendorProprietaryBp := `
+	vendor_snapshot_shared {
+		name: "libvendor",
+		version: "31",
+		target_arch: "arm64",
+		compile_multilib: "64",
+		vendor: true,
+		arch: {
+			arm64: {
+				src: "libvendor.so",
+				toc: "libvendor.so.toc",
+			},
+		},
+	}
+
+	vendor_snapshot_shared {
+		name: "libvendor_notoc",
+		version: "31",
+		target_arch: "arm64",
+		compile_multilib: "64",
+		vendor: true,
+		arch: {
+			arm64: {
+				src: "libvendor_notoc.so",
+			},
+		},
+	}
+`
+	depsBp := GatherRequiredDepsForTest(android.Android)
+
+	mockFS := map[string][]byte{
+		"deps/Android.bp":           []byte(depsBp),
+		"vendor/Android.bp":         []byte(vendorProprietaryBp),
+		"vendor/libvendor.so":       nil,
+		"vendor/libvendor.so.toc":   nil,
+		"vendor/libvendor_notoc.so": nil,
+	}
+
+	config := TestConfig(t.TempDir(), android.Android, nil, "", mockFS)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("31")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("32")
+	ctx := CreateTestContext(config)
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"deps/Android.bp", "vendor/Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfErrored(t, errs)
+
+	variant := "android_vendor.31_arm64_armv8-a_shared"
+	libvendor := ctx.ModuleForTests("libvendor.vendor_shared.31.arm64", variant)
+	android.AssertStringEquals(t, "toc of libvendor", "vendor/libvendor.so.toc",
+		libvendor.Module().(*Module).Toc().String())
+	if libvendor.MaybeOutput("libvendor.so.toc").Rule != nil {
+		t.Errorf("the toc of libvendor is generated although the snapshot provides it")
+	}
+
+	// The toc is generated if the snapshot doesn't provide it.
+	ctx.ModuleForTests("libvendor_notoc.vendor_shared.31.arm64", variant).Output("libvendor_notoc.so.toc")
+}
+
+func TestVendorSnapshotUseObjects(t *testing.T) {
+	frameworkBp := `
+	cc_object {
+		name: "objvendor",
+		vendor: true,
+		stl: "none",
+		system_shared_libs: [],
+		srcs: ["obj.cpp"],
+	}
+`
+
+	vendorProprietaryBp := `
+	cc_object {
+		name: "objclient",
+		vendor: true,
+		stl: "none",
+		system_shared_libs: [],
+		srcs: ["client.cpp"],
+		objs: ["objvendor"],
+	}
+
+	vendor_snapshot {
+		name: "vendor_snapshot",
+		version: "31",
+		arch: {
+			arm64: {
+				objects: ["objvendor"],
+			},
+			arm: {
+				objects: ["objvendor"],
+			},
+		},
+	}
+
+	vendor_snapshot_object {
+		name: "objvendor",
+		version: "31",
+		target_arch: "arm64",
+		compile_multilib: "both",
+		vendor: true,
+		arch: {
+			arm64: {
+				src: "objvendor.o",
+			},
+			arm: {
+				src: "objvendor.o",
+			},
+		},
+	}
+`
+	depsBp := GatherRequiredDepsForTest(android.Android)
+
+	mockFS := map[string][]byte{
+		"deps/Android.bp":      []byte(depsBp),
+		"framework/Android.bp": []byte(frameworkBp),
+		"framework/obj.cpp":    nil,
+		"vendor/Android.bp":    []byte(vendorProprietaryBp),
+		"vendor/client.cpp":    nil,
+		"vendor/objvendor.o":   nil,
+	}
+
+	config := TestConfig(t.TempDir(), android.Android, nil, "", mockFS)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("31")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("32")
+	ctx := CreateTestContext(config)
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"deps/Android.bp", "framework/Android.bp", "vendor/Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfErrored(t, errs)
+
+	objectVariant := "android_vendor.31_arm64_armv8-a"
+
+	// objclient refers to objvendor by its source name, and links against objvendor.vendor_object.31.arm64
+	objvendorOutputPaths := getOutputPaths(ctx, objectVariant, []string{"objvendor.vendor_object.31.arm64"})
+	objclientInputs := ctx.ModuleForTests("objclient", objectVariant).Output("objclient.o").Inputs.Strings()
+	if !inList(objvendorOutputPaths[0].String(), objclientInputs) {
+		t.Errorf("inputs for objclient must contain %#v, but was %#v", objvendorOutputPaths[0], objclientInputs)
+	}
+
+	// objvendor doesn't have vendor.31 variant
+	if inList(objectVariant, ctx.ModuleVariantsForTests("objvendor")) {
+		t.Errorf("objvendor must not have variant %#v, but it does", objectVariant)
+	}
+}
+
+func TestVendorSnapshotCaptureMultiInputObject(t *testing.T) {
+	bp := `
+	cc_object {
+		name: "objdep",
+		vendor: true,
+		stl: "none",
+		system_shared_libs: [],
+		srcs: ["dep.cpp"],
+		compile_multilib: "64",
+	}
+
+	cc_object {
+		name: "objmulti",
+		vendor: true,
+		stl: "none",
+		system_shared_libs: [],
+		srcs: ["a.cpp", "b.cpp"],
+		objs: ["objdep"],
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	objectVariant := "android_vendor.29_arm64_armv8-a"
+	objectDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/object"
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+
+	// objmulti is partially linked from its own objects and objdep.
+	combined := ctx.ModuleForTests("objmulti", objectVariant).Output("objmulti.o")
+	android.AssertIntEquals(t, "number of objmulti.o inputs", 3, len(combined.Inputs))
+
+	// The combined object is captured rather than any of its inputs.
+	checkSnapshot(t, ctx, snapshotSingleton, "objmulti", "objmulti.o", objectDir, objectVariant)
+	android.AssertPathRelativeToTopEquals(t, "captured objmulti", android.PathRelativeToTop(combined.Output),
+		snapshotSingleton.Output(filepath.Join(objectDir, "objmulti.o")).Input)
+	checkSnapshot(t, ctx, snapshotSingleton, "objdep", "objdep.o", objectDir, objectVariant)
+}
+
+func TestVendorSnapshotArchDisabled(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libfoo",
+		vendor: true,
+		nocrt: true,
+		arch: {
+			arm: {
+				enabled: false,
+			},
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	ctx := testCcWithConfig(t, config)
+
+	snapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	snapshotDir := "out/soong/vendor-snapshot/arm64"
+
+	// libfoo is captured for arm64 only.
+	checkSnapshot(t, ctx, snapshotSingleton, "libfoo", "libfoo.so",
+		filepath.Join(snapshotDir, "arch-arm64-armv8-a", "shared"), "android_vendor.29_arm64_armv8-a_shared")
+	for _, out := range []string{"libfoo.so", "libfoo.so.json"} {
+		path := filepath.Join(snapshotDir, "arch-arm-armv7-a-neon", "shared", out)
+		if snapshotSingleton.MaybeOutput(path).Rule != nil {
+			t.Errorf("%q not expected but found", path)
+		}
+	}
+}
+
+func TestVendorSnapshotStrictVersions(t *testing.T) {
+	bp := `
+	vendor_snapshot {
+		name: "vendor_snapshot",
+		version: "29",
+		arch: {
+			arm64: {
+				shared_libs: ["libinactive"],
+			},
+		},
+	}
+
+	vendor_snapshot_shared {
+		name: "libinactive",
+		version: "29",
+		target_arch: "arm64",
+		vendor: true,
+		arch: {
+			arm64: {
+				src: "libinactive.so",
+			},
+		},
+	}
+
+	vendor_snapshot_shared {
+		name: "libvendor",
+		version: "30",
+		target_arch: "arm64",
+		vendor: true,
+		arch: {
+			arm64: {
+				src: "libvendor.so",
+			},
+		},
 	}
+`
+	config := TestConfig(t.TempDir(), android.Android, nil, bp, nil)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("31")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("32")
+	config.TestProductVariables.StrictSnapshotVersions = true
+	ctx := testCcWithConfig(t, config)
 
-	binVariants := ctx.ModuleVariantsForTests("bin")
-	if inList(binaryVariant, binVariants) {
-		t.Errorf("bin must not have variant %#v, but it does", sharedVariant)
-	}
+	// libvendor has no vendor_snapshot module of its version, so it is reported as stale.
+	// libinactive is a part of the inactive vendor snapshot v29, so it isn't.
+	checkSingleton := ctx.SingletonForTests("snapshot-version-check")
+	report := android.ContentFromFileRuleForTests(t, checkSingleton.Output("out/soong/snapshot-version-check/stale-warnings.txt"))
+	android.AssertStringDoesContain(t, "warnings", report,
+		`snapshot module "libvendor.vendor_shared.30.arm64" has version "30" which is older than the target snapshot version "31"`)
+	android.AssertStringDoesNotContain(t, "warnings", report, "libinactive")
 }
 
 func TestVendorSnapshotSanitizer(t *testing.T) {
@@ -1181,6 +4797,30 @@ func TestVendorSnapshotExcludeInVendorProprietaryPathErrors(t *testing.T) {
 	})
 }
 
+func TestSnapshotProfiles(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libfoo",
+		vendor_available: true,
+		recovery_available: true,
+		nocrt: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.RecoverySnapshotVersion = StringPtr("current")
+	config.TestProductVariables.BoardSnapshotProfiles = []string{"vendor"}
+	ctx := testCcWithConfig(t, config)
+
+	vendorJson := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared/libfoo.so.json"
+	if ctx.SingletonForTests("vendor-snapshot").MaybeOutput(vendorJson).Rule == nil {
+		t.Errorf("%q expected but not found", vendorJson)
+	}
+	recoveryJson := "out/soong/recovery-snapshot/arm64/arch-arm64-armv8-a/shared/libfoo.so.json"
+	if ctx.SingletonForTests("recovery-snapshot").MaybeOutput(recoveryJson).Rule != nil {
+		t.Errorf("%q not expected but found", recoveryJson)
+	}
+}
+
 func TestRecoverySnapshotCapture(t *testing.T) {
 	bp := `
 	cc_library {
@@ -1307,6 +4947,85 @@ func TestRecoverySnapshotCapture(t *testing.T) {
 	}
 }
 
+func TestRecoverySnapshotCapturesRecoveryVariant(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libfoo",
+		vendor_available: true,
+		recovery_available: true,
+		nocrt: true,
+		target: {
+			recovery: {
+				cflags: ["-DRECOVERY"],
+			},
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.RecoverySnapshotVersion = StringPtr("current")
+	ctx := testCcWithConfig(t, config)
+
+	// The recovery snapshot captures the recovery variant, not the vendor variant.
+	recoverySingleton := ctx.SingletonForTests("recovery-snapshot")
+	sharedDir := "out/soong/recovery-snapshot/arm64/arch-arm64-armv8-a/shared"
+	checkSnapshot(t, ctx, recoverySingleton, "libfoo", "libfoo.so", sharedDir, "android_recovery_arm64_armv8-a_shared")
+
+	vendorOutput := ctx.ModuleForTests("libfoo", "android_vendor.29_arm64_armv8-a_shared").Module().(*Module).OutputFile().Path()
+	recoveryInput := recoverySingleton.Output(filepath.Join(sharedDir, "libfoo.so")).Input
+	if recoveryInput.String() == vendorOutput.String() {
+		t.Errorf("recovery snapshot captured the vendor variant %q", vendorOutput)
+	}
+}
+
+func TestRecoverySnapshotVndk(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libvndk",
+		vendor_available: true,
+		recovery_available: true,
+		vndk: {
+			enabled: true,
+		},
+		nocrt: true,
+	}
+
+	cc_library_shared {
+		name: "libvndk_sp",
+		vendor_available: true,
+		recovery_available: true,
+		vndk: {
+			enabled: true,
+			support_system_process: true,
+		},
+		nocrt: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.RecoverySnapshotVersion = StringPtr("current")
+	ctx := testCcWithConfig(t, config)
+
+	// The recovery variants of VNDK libraries are installed to the plain library directories of
+	// the recovery image, not to the VNDK APEX directories.
+	recoverySingleton := ctx.SingletonForTests("recovery-snapshot")
+	sharedDir := "out/soong/recovery-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, name := range []string{"libvndk", "libvndk_sp"} {
+		installed := ctx.ModuleForTests(name, "android_recovery_arm64_armv8-a_shared").Module().FilesToInstall()
+		android.AssertStringEquals(t, "installed file of "+name,
+			"out/soong/target/product/test_device/recovery/root/system/lib64/"+name+".so",
+			android.PathRelativeToTop(installed[0]))
+
+		var prop snapshotJsonFlags
+		jsonFile := filepath.Join(sharedDir, name+".so.json")
+		readSnapshotJson(t, recoverySingleton, jsonFile, &prop)
+		android.AssertStringEquals(t, "RelativeInstallPath of "+name, "", prop.RelativeInstallPath)
+		android.AssertStringEquals(t, "VndkExtends of "+name, "", prop.VndkExtends)
+	}
+
+	if recoverySingleton.MaybeOutput("out/soong/recovery-snapshot/arm64/vndk/vndk.json").Rule != nil {
+		t.Errorf("unexpected vndk.json in the recovery snapshot")
+	}
+}
+
 func TestRecoverySnapshotExclude(t *testing.T) {
 	// This test verifies that the exclude_from_recovery_snapshot property
 	// makes its way from the Android.bp source file into the module data
@@ -1501,3 +5220,315 @@ func TestRecoverySnapshotDirected(t *testing.T) {
 		}
 	}
 }
+
+func TestProductSnapshotCapture(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libboth",
+		vendor_available: true,
+		product_available: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libproduct",
+		product_specific: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		vendor: true,
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.ProductVndkVersion = StringPtr("current")
+	ctx := testCcWithConfig(t, config)
+
+	vendorSnapshotSingleton := ctx.SingletonForTests("vendor-snapshot")
+	productSnapshotSingleton := ctx.SingletonForTests("product-snapshot")
+	vendorVariant := "android_vendor.29_arm64_armv8-a_shared"
+	productVariant := "android_product.29_arm64_armv8-a_shared"
+	vendorSharedDir := filepath.Join("out/soong/vendor-snapshot", "arm64", "arch-arm64-armv8-a", "shared")
+	productSharedDir := filepath.Join("out/soong/product-snapshot", "arm64", "arch-arm64-armv8-a", "shared")
+
+	// A module available to both images is captured to both snapshots from the matching variants.
+	checkSnapshot(t, ctx, vendorSnapshotSingleton, "libboth", "libboth.so", vendorSharedDir, vendorVariant)
+	checkSnapshot(t, ctx, productSnapshotSingleton, "libboth", "libboth.so", productSharedDir, productVariant)
+
+	checkSnapshot(t, ctx, productSnapshotSingleton, "libproduct", "libproduct.so", productSharedDir, productVariant)
+	checkSnapshotExclude(t, ctx, productSnapshotSingleton, "libvendor", "libvendor.so", productSharedDir, vendorVariant)
+	if vendorSnapshotSingleton.MaybeOutput(filepath.Join(vendorSharedDir, "libproduct.so")).Rule != nil {
+		t.Errorf("product specific libproduct must not be captured to the vendor snapshot")
+	}
+}
+
+func TestProductSnapshotVndk(t *testing.T) {
+	bp := `
+	cc_library {
+		name: "libvndk",
+		vendor_available: true,
+		product_available: true,
+		vndk: {
+			enabled: true,
+		},
+		nocrt: true,
+		compile_multilib: "64",
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.ProductVndkVersion = StringPtr("current")
+	ctx := testCcWithConfig(t, config)
+
+	// Product variants link against the VNDK, so the static VNDK libraries are captured to the
+	// product snapshot, while the shared ones are provided by the system image.
+	productSnapshotSingleton := ctx.SingletonForTests("product-snapshot")
+	archDir := filepath.Join("out/soong/product-snapshot", "arm64", "arch-arm64-armv8-a")
+	checkSnapshot(t, ctx, productSnapshotSingleton, "libvndk", "libvndk.a",
+		filepath.Join(archDir, "static"), "android_product.29_arm64_armv8-a_static")
+	checkSnapshotExclude(t, ctx, productSnapshotSingleton, "libvndk", "libvndk.so",
+		filepath.Join(archDir, "shared"), "android_product.29_arm64_armv8-a_shared")
+}
+
+func TestProductSnapshotUse(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libboth",
+		vendor_available: true,
+		product_available: true,
+		nocrt: true,
+		system_shared_libs: [],
+		stl: "none",
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libffi",
+		vendor_available: true,
+		product_available: true,
+		nocrt: true,
+		system_shared_libs: [],
+		stl: "none",
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libclient",
+		product_specific: true,
+		shared_libs: ["libboth", "libffi"],
+		nocrt: true,
+		system_shared_libs: [],
+		stl: "none",
+		compile_multilib: "64",
+	}
+
+	product_snapshot {
+		name: "product_snapshot",
+		version: "28",
+		arch: {
+			arm64: {
+				shared_libs: ["libboth", "libffi"],
+			},
+		},
+	}
+
+	product_snapshot_shared {
+		name: "libboth",
+		version: "28",
+		target_arch: "arm64",
+		compile_multilib: "64",
+		product_specific: true,
+		arch: {
+			arm64: {
+				src: "libboth.so",
+			},
+		},
+	}
+
+	product_snapshot_rust_ffi_shared {
+		name: "libffi",
+		version: "28",
+		target_arch: "arm64",
+		compile_multilib: "64",
+		product_specific: true,
+		arch: {
+			arm64: {
+				src: "libffi.so",
+			},
+		},
+	}
+`
+	config := testSnapshotConfig(t, bp, map[string][]byte{
+		"libboth.so": nil,
+		"libffi.so":  nil,
+	})
+	config.TestProductVariables.ProductVndkVersion = StringPtr("28")
+	ctx := testCcWithConfig(t, config)
+
+	// The product variant of libclient links against the product snapshots of libboth and of the
+	// Rust FFI library libffi, while the vendor variant of libboth is still built from source.
+	variant := "android_product.28_arm64_armv8-a_shared"
+	libclientLdFlags := ctx.ModuleForTests("libclient", variant).Rule("ld").Args["libFlags"]
+	for _, snapshotOutput := range getOutputPaths(ctx, variant, []string{
+		"libboth.product_shared.28.arm64",
+		"libffi.product_shared.28.arm64",
+	}) {
+		android.AssertStringDoesContain(t, "libFlags of libclient", libclientLdFlags, snapshotOutput.String())
+	}
+	ctx.ModuleForTests("libboth", "android_vendor.29_arm64_armv8-a_shared").Rule("ld")
+}
+
+func TestRamdiskSnapshotCapture(t *testing.T) {
+	bp := `
+	cc_defaults {
+		name: "ramdisk_defaults",
+		nocrt: true,
+		no_libcrt: true,
+		system_shared_libs: [],
+		stl: "none",
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libramdisk",
+		defaults: ["ramdisk_defaults"],
+		ramdisk: true,
+	}
+
+	cc_library_shared {
+		name: "libvendor_ramdisk",
+		defaults: ["ramdisk_defaults"],
+		vendor_ramdisk: true,
+	}
+
+	cc_library_shared {
+		name: "libboth_ramdisks",
+		defaults: ["ramdisk_defaults"],
+		vendor_available: true,
+		ramdisk_available: true,
+		vendor_ramdisk_available: true,
+	}
+
+	cc_library_shared {
+		name: "libvendor",
+		defaults: ["ramdisk_defaults"],
+		vendor: true,
+	}
+`
+	config := testSnapshotConfig(t, bp, nil)
+	config.TestProductVariables.RamdiskSnapshotVersion = StringPtr("current")
+	config.TestProductVariables.VendorRamdiskSnapshotVersion = StringPtr("current")
+	ctx := testCcWithConfig(t, config)
+
+	archDir := filepath.Join("arm64", "arch-arm64-armv8-a", "shared")
+	for _, tc := range []struct {
+		image    string
+		variant  string
+		included []string
+		excluded []string
+	}{
+		{
+			image:    "vendor",
+			variant:  "android_vendor.29_arm64_armv8-a_shared",
+			included: []string{"libboth_ramdisks", "libvendor"},
+			excluded: []string{"libramdisk", "libvendor_ramdisk"},
+		},
+		{
+			image:    "ramdisk",
+			variant:  "android_ramdisk_arm64_armv8-a_shared",
+			included: []string{"libboth_ramdisks", "libramdisk"},
+			excluded: []string{"libvendor", "libvendor_ramdisk"},
+		},
+		{
+			image:    "vendor_ramdisk",
+			variant:  "android_vendor_ramdisk_arm64_armv8-a_shared",
+			included: []string{"libboth_ramdisks", "libvendor_ramdisk"},
+			excluded: []string{"libvendor", "libramdisk"},
+		},
+	} {
+		snapshotSingleton := ctx.SingletonForTests(tc.image + "-snapshot")
+		sharedDir := filepath.Join("out/soong", tc.image+"-snapshot", archDir)
+		for _, name := range tc.included {
+			checkSnapshot(t, ctx, snapshotSingleton, name, name+".so", sharedDir, tc.variant)
+		}
+		// Modules only in the other images have no variants of this image, so only the snapshot
+		// outputs are checked.
+		for _, name := range tc.excluded {
+			if snapshotSingleton.MaybeOutput(filepath.Join(sharedDir, name+".so")).Rule != nil {
+				t.Errorf("%q must not be captured to the %s snapshot", name, tc.image)
+			}
+		}
+	}
+}
+
+func TestRamdiskWithoutSnapshot(t *testing.T) {
+	bp := `
+	cc_defaults {
+		name: "ramdisk_defaults",
+		nocrt: true,
+		no_libcrt: true,
+		system_shared_libs: [],
+		stl: "none",
+		compile_multilib: "64",
+	}
+
+	cc_library_shared {
+		name: "libdep",
+		defaults: ["ramdisk_defaults"],
+		ramdisk_available: true,
+		vendor_ramdisk_available: true,
+	}
+
+	cc_library_shared {
+		name: "libclient",
+		defaults: ["ramdisk_defaults"],
+		ramdisk_available: true,
+		vendor_ramdisk_available: true,
+		shared_libs: ["libdep"],
+	}
+
+	ramdisk_snapshot {
+		name: "ramdisk_snapshot",
+		version: "28",
+		arch: {
+			arm64: {
+				shared_libs: ["libdep"],
+			},
+		},
+	}
+
+	ramdisk_snapshot_shared {
+		name: "libdep",
+		version: "28",
+		target_arch: "arm64",
+		compile_multilib: "64",
+		ramdisk: true,
+		arch: {
+			arm64: {
+				src: "libdep.so",
+			},
+		},
+	}
+`
+	config := TestConfig(t.TempDir(), android.Android, nil, bp, map[string][]byte{
+		"libdep.so": nil,
+	})
+	config.TestProductVariables.Platform_vndk_version = StringPtr("29")
+	ctx := testCcWithConfig(t, config)
+
+	// Without a ramdisk or vendor_ramdisk snapshot version, ramdisk and vendor_ramdisk variants
+	// link against their dependencies built from source.
+	for _, variant := range []string{
+		"android_ramdisk_arm64_armv8-a_shared",
+		"android_vendor_ramdisk_arm64_armv8-a_shared",
+	} {
+		libclientLdFlags := ctx.ModuleForTests("libclient", variant).Rule("ld").Args["libFlags"]
+		libdepOutput := getOutputPaths(ctx, variant, []string{"libdep"})[0]
+		android.AssertStringDoesContain(t, "libFlags of libclient", libclientLdFlags, libdepOutput.String())
+		android.AssertStringDoesNotContain(t, "libFlags of libclient", libclientLdFlags, "libdep.ramdisk_shared")
+	}
+}
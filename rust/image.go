@@ -33,6 +33,10 @@ func (mod *Module) OdmAvailable() bool {
 	return Bool(mod.VendorProperties.Odm_available)
 }
 
+func (mod *Module) VendorDlkmAvailable() bool {
+	return Bool(mod.VendorProperties.Vendor_dlkm_available)
+}
+
 func (mod *Module) ProductAvailable() bool {
 	return false
 }
@@ -132,6 +136,19 @@ func (c *Module) VendorVariantToOdm() bool {
 	return Bool(c.VendorProperties.Odm_available)
 }
 
+// Returns true when the vendor variant of this module is for the vendor_dlkm partition.
+func (c *Module) VendorVariantToVendorDlkm() bool {
+	return Bool(c.VendorProperties.Vendor_dlkm_available)
+}
+
+func (mod *Module) InstallPartition() string {
+	return mod.installPartition
+}
+
+func (mod *Module) InstallInVendorDlkm() bool {
+	return mod.InVendor() && mod.VendorVariantToVendorDlkm()
+}
+
 func (ctx *moduleContext) ProductSpecific() bool {
 	return false
 }
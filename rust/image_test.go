@@ -15,6 +15,7 @@
 package rust
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 
@@ -45,6 +46,94 @@ func TestVendorLinkage(t *testing.T) {
 	}
 }
 
+// Test that vendor_available rust_ffi libraries are captured to the vendor snapshot along with
+// their exported C headers and cc library dependencies.
+func TestVendorSnapshotRustFFI(t *testing.T) {
+	skipTestIfOsNotSupported(t)
+	result := android.GroupFixturePreparers(
+		prepareForRustTest,
+		cc.PrepareForTestWithCcIncludeVndk,
+		rustMockedFiles.AddToFixture(),
+		android.FixtureModifyProductVariables(
+			func(variables android.FixtureProductVariables) {
+				variables.DeviceVndkVersion = StringPtr("current")
+				variables.Platform_vndk_version = StringPtr("29")
+			},
+		),
+	).RunTestWithBp(t, `
+			rust_ffi {
+				name: "libffi_vendor",
+				crate_name: "ffi_vendor",
+				srcs: ["foo.rs"],
+				include_dirs: ["src"],
+				shared_libs: ["libvendor_shared"],
+				static_libs: ["libvendor_static"],
+				vendor_available: true,
+			}
+			rust_ffi {
+				name: "libffi_noheaders",
+				crate_name: "ffi_noheaders",
+				srcs: ["foo.rs"],
+				vendor_available: true,
+			}
+			cc_library_shared {
+				name: "libvendor_shared",
+				vendor_available: true,
+				nocrt: true,
+				system_shared_libs: [],
+				stl: "none",
+			}
+			cc_library_static {
+				name: "libvendor_static",
+				vendor_available: true,
+				nocrt: true,
+				system_shared_libs: [],
+				stl: "none",
+			}
+		`)
+
+	snapshotSingleton := result.SingletonForTests("vendor-snapshot")
+	snapshotDir := "out/soong/vendor-snapshot/arm64"
+	for _, f := range []string{"shared/libffi_vendor.so", "static/libffi_vendor.a"} {
+		snapshotSingleton.Output(snapshotDir + "/arch-arm64-armv8-a/" + f)
+
+		var prop struct {
+			Rust         bool
+			ExportedDirs []string
+			SharedLibs   []string
+			StaticLibs   []string
+			Pic          bool
+		}
+		jsonFile := snapshotDir + "/arch-arm64-armv8-a/" + f + ".json"
+		content := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output(jsonFile))
+		if err := json.Unmarshal([]byte(content), &prop); err != nil {
+			t.Fatalf("failed to parse %q: %s", jsonFile, err)
+		}
+		android.AssertBoolEquals(t, "Rust of "+jsonFile, true, prop.Rust)
+		android.AssertDeepEquals(t, "ExportedDirs of "+jsonFile, []string{"include/src"}, prop.ExportedDirs)
+		android.AssertBoolEquals(t, "Pic of "+jsonFile, true, prop.Pic)
+		if strings.HasPrefix(f, "shared/") {
+			android.AssertStringListContains(t, "SharedLibs of "+jsonFile, prop.SharedLibs, "libvendor_shared")
+		} else {
+			android.AssertStringListContains(t, "StaticLibs of "+jsonFile, prop.StaticLibs, "libvendor_static")
+		}
+	}
+	snapshotSingleton.Output(snapshotDir + "/include/src/any.h")
+
+	// libraries without include_dirs export no headers
+	for _, f := range []string{"shared/libffi_noheaders.so", "static/libffi_noheaders.a"} {
+		var prop struct {
+			ExportedDirs []string
+		}
+		jsonFile := snapshotDir + "/arch-arm64-armv8-a/" + f + ".json"
+		content := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output(jsonFile))
+		if err := json.Unmarshal([]byte(content), &prop); err != nil {
+			t.Fatalf("failed to parse %q: %s", jsonFile, err)
+		}
+		android.AssertDeepEquals(t, "ExportedDirs of "+jsonFile, []string(nil), prop.ExportedDirs)
+	}
+}
+
 // Test that variants which use the vndk emit the appropriate cfg flag.
 func TestImageVndkCfgFlag(t *testing.T) {
 	ctx := testRustVndk(t, `
@@ -99,6 +99,9 @@ type libraryDecorator struct {
 	MutatedProperties LibraryMutatedProperties
 	includeDirs       android.Paths
 	sourceProvider    SourceProvider
+
+	// Exported C headers of FFI libraries, collected for vendor/recovery snapshots.
+	collectedSnapshotHeaders android.Paths
 }
 
 type libraryInterface interface {
@@ -412,6 +415,16 @@ func (library *libraryDecorator) compilerDeps(ctx DepsContext, deps Deps) Deps {
 	return deps
 }
 
+// collectHeadersForSnapshot globs the exported C headers of a shared or static FFI library for
+// the vendor/recovery snapshot.
+func (library *libraryDecorator) collectHeadersForSnapshot(ctx android.ModuleContext) {
+	library.collectedSnapshotHeaders = cc.GlobHeadersForSnapshot(ctx, library.includeDirs)
+}
+
+func (library *libraryDecorator) snapshotHeaders() android.Paths {
+	return library.collectedSnapshotHeaders
+}
+
 func (library *libraryDecorator) sharedLibFilename(ctx ModuleContext) string {
 	return library.getStem(ctx) + ctx.toolchain().SharedLibSuffix()
 }
@@ -71,6 +71,10 @@ type BaseProperties struct {
 	AndroidMkSharedLibs    []string
 	AndroidMkStaticLibs    []string
 
+	// Base names of the cc library dependencies, recorded for snapshots.
+	SnapshotSharedLibs []string `blueprint:"mutated"`
+	SnapshotStaticLibs []string `blueprint:"mutated"`
+
 	ImageVariationPrefix string `blueprint:"mutated"`
 	VndkVersion          string `blueprint:"mutated"`
 	SubName              string `blueprint:"mutated"`
@@ -129,6 +133,9 @@ type Module struct {
 	docTimestampFile     android.OptionalPath
 
 	hideApexVariantFromMake bool
+
+	// The partition this module is installed to, e.g. "vendor" or "odm".
+	installPartition string
 }
 
 func (mod *Module) Header() bool {
@@ -611,6 +618,13 @@ func (mod *Module) OutputFile() android.OptionalPath {
 	return mod.unstrippedOutputFile
 }
 
+func (mod *Module) UnstrippedOutputFile() android.Path {
+	if mod.unstrippedOutputFile.Valid() {
+		return mod.unstrippedOutputFile.Path()
+	}
+	return nil
+}
+
 func (mod *Module) CoverageFiles() android.Paths {
 	if mod.compiler != nil {
 		return android.Paths{}
@@ -759,6 +773,7 @@ func (mod *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	ctx := &moduleContext{
 		ModuleContext: actx,
 	}
+	mod.installPartition = android.ModuleInstallPartition(ctx)
 
 	apexInfo := actx.Provider(android.ApexInfoProvider).(android.ApexInfo)
 	if !apexInfo.IsForPlatform() {
@@ -826,6 +841,14 @@ func (mod *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		if mod.installable(apexInfo) {
 			mod.compiler.install(ctx)
 		}
+
+		// glob exported C headers of FFI libraries for snapshot, if BOARD_VNDK_VERSION is current
+		// or RECOVERY_SNAPSHOT_VERSION is current.
+		if library, ok := mod.compiler.(*libraryDecorator); ok && (library.shared() || library.static()) {
+			if cc.ShouldCollectHeadersForSnapshot(actx, mod, apexInfo) {
+				library.collectHeadersForSnapshot(actx)
+			}
+		}
 	}
 }
 
@@ -1043,6 +1066,7 @@ func (mod *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				depPaths.depGeneratedHeaders = append(depPaths.depGeneratedHeaders, exportedInfo.GeneratedHeaders...)
 				directStaticLibDeps = append(directStaticLibDeps, ccDep)
 				mod.Properties.AndroidMkStaticLibs = append(mod.Properties.AndroidMkStaticLibs, makeLibName)
+				mod.Properties.SnapshotStaticLibs = append(mod.Properties.SnapshotStaticLibs, cc.BaseLibName(depName))
 			case cc.IsSharedDepTag(depTag):
 				depPaths.linkDirs = append(depPaths.linkDirs, linkPath)
 				depPaths.linkObjects = append(depPaths.linkObjects, linkObject.String())
@@ -1053,6 +1077,7 @@ func (mod *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				depPaths.depGeneratedHeaders = append(depPaths.depGeneratedHeaders, exportedInfo.GeneratedHeaders...)
 				directSharedLibDeps = append(directSharedLibDeps, ccDep)
 				mod.Properties.AndroidMkSharedLibs = append(mod.Properties.AndroidMkSharedLibs, makeLibName)
+				mod.Properties.SnapshotSharedLibs = append(mod.Properties.SnapshotSharedLibs, cc.BaseLibName(depName))
 				exportDep = true
 			case cc.IsHeaderDepTag(depTag):
 				exportedInfo := ctx.OtherModuleProvider(dep, cc.FlagExporterInfoProvider).(cc.FlagExporterInfo)
@@ -16,39 +16,261 @@ package rust
 
 import (
 	"android/soong/android"
+	"android/soong/cc"
 )
 
 func (mod *Module) ExcludeFromVendorSnapshot() bool {
-	// TODO Rust does not yet support snapshotting
+	// Rust modules have no exclude_from_vendor_snapshot property.
 	return false
 }
 
 func (mod *Module) ExcludeFromRecoverySnapshot() bool {
-	// TODO Rust does not yet support snapshotting
+	// Rust modules have no exclude_from_recovery_snapshot property.
+	return false
+}
+
+func (mod *Module) ExcludeFromProductSnapshot() bool {
+	// Rust modules have no exclude_from_product_snapshot property.
+	return false
+}
+
+func (mod *Module) ExcludeFromArchSnapshot() bool {
+	// Rust modules have no exclude_from_arch_snapshot property.
 	return false
 }
 
 func (mod *Module) IsSnapshotLibrary() bool {
-	// TODO Rust does not yet support snapshotting
+	// Only FFI libraries, which expose a C ABI to cc modules, are captured.
+	if library, ok := mod.compiler.(*libraryDecorator); ok {
+		return library.shared() || library.static()
+	}
 	return false
 }
 
+func (mod *Module) SnapshotRust() bool {
+	return true
+}
+
 func (mod *Module) SnapshotRuntimeLibs() []string {
 	// TODO Rust does not yet support a runtime libs notion similar to CC
 	return []string{}
 }
 
 func (mod *Module) SnapshotSharedLibs() []string {
-	// TODO Rust does not yet support snapshotting
+	return mod.Properties.SnapshotSharedLibs
+}
+
+func (mod *Module) SnapshotStaticLibs() []string {
+	return mod.Properties.SnapshotStaticLibs
+}
+
+func (mod *Module) SnapshotHeaderLibs() []string {
+	// Headers of cc header_libs are passed to bindgen only, and aren't reexported.
+	return []string{}
+}
+
+func (mod *Module) SnapshotNeededLibs() []string {
+	// Rust FFI libraries have no libs that are only added to DT_NEEDED.
 	return []string{}
 }
 
+func (mod *Module) SnapshotSuffix() string {
+	// Rust modules have no snapshot_suffix property.
+	return ""
+}
+
+func (mod *Module) SnapshotExperimental() bool {
+	// Rust modules have no snapshot_experimental property.
+	return false
+}
+
+func (mod *Module) SnapshotSdkVersion() string {
+	// Rust modules have no sdk_version property.
+	return ""
+}
+
+func (mod *Module) SnapshotMetadata() []string {
+	// Rust modules have no snapshot_metadata property.
+	return nil
+}
+
+func (mod *Module) SnapshotExportHeaders() bool {
+	// Only FFI libraries listing include_dirs export headers to cc modules.
+	if library, ok := mod.compiler.(*libraryDecorator); ok && mod.IsSnapshotLibrary() {
+		return len(library.Properties.Include_dirs) > 0
+	}
+	return false
+}
+
+func (mod *Module) SnapshotDlopenLibs() []string {
+	// Rust modules have no snapshot_dlopen_libs property.
+	return nil
+}
+
+func (mod *Module) SnapshotAbiRelevantFlags() []string {
+	// Rust modules aren't compiled with C flags.
+	return nil
+}
+
+func (mod *Module) SnapshotCppFlags() []string {
+	// Rust modules aren't compiled with C flags.
+	return nil
+}
+
+func (mod *Module) SnapshotConlyFlags() []string {
+	// Rust modules aren't compiled with C flags.
+	return nil
+}
+
+func (mod *Module) SnapshotCfiAssemblySupport() bool {
+	// Rust modules aren't built with CFI.
+	return false
+}
+
+func (mod *Module) SnapshotCfiExportsMap() android.OptionalPath {
+	// Rust modules aren't built with CFI.
+	return android.OptionalPath{}
+}
+
+func (mod *Module) SnapshotCfiDiag() bool {
+	// Rust modules aren't built with CFI.
+	return false
+}
+
+func (mod *Module) SnapshotSanitizers() []string {
+	// Sanitized Rust variants aren't captured.
+	return nil
+}
+
+func (mod *Module) SnapshotDiagSanitizers() []string {
+	// Sanitized Rust variants aren't captured.
+	return nil
+}
+
+func (mod *Module) SnapshotSanitizeBlocklist() android.OptionalPath {
+	// Sanitized Rust variants aren't captured.
+	return android.OptionalPath{}
+}
+
+func (mod *Module) SnapshotVersionScript() android.OptionalPath {
+	// Rust FFI libraries have no version scripts.
+	return android.OptionalPath{}
+}
+
+func (mod *Module) SnapshotLinkFlags() []string {
+	// Link flags of Rust FFI libraries only affect the library itself.
+	return nil
+}
+
+func (mod *Module) SnapshotRunpaths() []string {
+	// Rust modules have no runpaths.
+	return nil
+}
+
+func (mod *Module) SnapshotData() android.Paths {
+	// Rust FFI libraries have no data files.
+	return nil
+}
+
+func (mod *Module) SnapshotAvailableImages() []string {
+	// Rust modules don't support the ramdisk and recovery images yet.
+	return cc.SnapshotAvailableImages(mod.Properties.CoreVariantNeeded, false,
+		mod.Properties.VendorRamdiskVariantNeeded, false, mod.Properties.ExtraVariants)
+}
+
+func (mod *Module) SnapshotNocrt() bool {
+	// Rust FFI libraries are linked by rustc against the default crt objects.
+	return false
+}
+
+func (mod *Module) SnapshotNoLibcrt() bool {
+	// Rust modules have no no_libcrt property.
+	return false
+}
+
+func (mod *Module) SnapshotVndkExtends() string {
+	// Rust modules can't be VNDK extensions.
+	return ""
+}
+
+func (mod *Module) SnapshotKernelModules() android.Paths {
+	// Rust modules have no kernel modules.
+	return nil
+}
+
+func (mod *Module) SnapshotCrt() bool {
+	// Rust modules can't be crt objects.
+	return false
+}
+
+func (mod *Module) SnapshotVendorPublic() bool {
+	// Rust modules can't be vendor public libraries.
+	return false
+}
+
+func (mod *Module) SnapshotStubsVersion() string {
+	// Rust modules have no stubs.
+	return ""
+}
+
+func (mod *Module) SnapshotStubsSymbolFile() android.OptionalPath {
+	// Rust modules have no stubs.
+	return android.OptionalPath{}
+}
+
+func (mod *Module) SnapshotLlndkSymbolFile() android.OptionalPath {
+	// Rust modules can't be LLNDK libraries.
+	return android.OptionalPath{}
+}
+
+func (mod *Module) SnapshotLlndkVersion() string {
+	// Rust modules can't be LLNDK libraries.
+	return ""
+}
+
+func (mod *Module) SnapshotFloatAbi() string {
+	// Rust modules have no float_abi property.
+	return ""
+}
+
+func (mod *Module) SnapshotInstructionSet() string {
+	// Rust modules have no instruction_set property.
+	return ""
+}
+
+func (mod *Module) SnapshotProfileData() android.OptionalPath {
+	// Rust modules aren't built with PGO.
+	return android.OptionalPath{}
+}
+
+func (mod *Module) SnapshotAfdoProfile() android.OptionalPath {
+	// Rust modules aren't built with AFDO.
+	return android.OptionalPath{}
+}
+
+func (mod *Module) SnapshotVisibility() string {
+	// Rust modules have no snapshot_visibility property.
+	return ""
+}
+
+func (mod *Module) SnapshotPic() bool {
+	// Rust libraries are always compiled with -C relocation-model=pic.
+	return mod.IsSnapshotLibrary()
+}
+
+func (mod *Module) SnapshotPie() bool {
+	// Rust binaries aren't captured.
+	return false
+}
+
 func (mod *Module) Symlinks() []string {
 	// TODO update this to return the list of symlinks when Rust supports defining symlinks
 	return nil
 }
 
 func (m *Module) SnapshotHeaders() android.Paths {
-	// TODO Rust does not yet support snapshotting
+	if library, ok := m.compiler.(*libraryDecorator); ok && m.SnapshotExportHeaders() {
+		return library.snapshotHeaders()
+	}
 	return android.Paths{}
 }
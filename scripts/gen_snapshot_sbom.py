@@ -0,0 +1,143 @@
+#!/usr/bin/env python3
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Generates an SPDX SBOM describing the files of a snapshot.
+
+The metadata file is a json list of the captured files, each with its path
+relative to the snapshot root, the module it is captured from and the license
+kinds of the module. Every module becomes an SPDX package containing its files,
+and files shared by modules, e.g. headers, are listed on their own.
+"""
+
+import argparse
+import hashlib
+import json
+import os
+import re
+
+SPDX_PREFIX = 'SPDX-license-identifier-'
+
+# The SBOM is part of the snapshot, which must be reproducible.
+CREATED = '1970-01-01T00:00:00Z'
+
+
+def spdx_id(kind, name):
+  """Returns an SPDX identifier, which may only contain letters, numbers, . and -."""
+  return 'SPDXRef-%s-%s' % (kind, re.sub(r'[^A-Za-z0-9.-]', '-', name))
+
+
+def license_expression(kinds):
+  """Returns the SPDX license expression of a list of license kinds."""
+  licenses = []
+  for kind in sorted(set(kinds or [])):
+    if kind.startswith(SPDX_PREFIX):
+      licenses.append(kind[len(SPDX_PREFIX):])
+    else:
+      licenses.append('LicenseRef-' + re.sub(r'[^A-Za-z0-9.-]', '-', kind))
+  if not licenses:
+    return 'NOASSERTION'
+  return ' AND '.join(licenses)
+
+
+def sha256(path):
+  h = hashlib.sha256()
+  with open(path, 'rb') as f:
+    for chunk in iter(lambda: f.read(1 << 20), b''):
+      h.update(chunk)
+  return h.hexdigest()
+
+
+def generate_sbom(name, root, entries):
+  """Returns the SPDX document of the given metadata entries."""
+  doc = {
+      'spdxVersion': 'SPDX-2.2',
+      'dataLicense': 'CC0-1.0',
+      'SPDXID': 'SPDXRef-DOCUMENT',
+      'name': name,
+      'documentNamespace': 'https://android.googlesource.com/snapshot/' + name,
+      'creationInfo': {
+          'created': CREATED,
+          'creators': ['Tool: gen_snapshot_sbom'],
+      },
+      'packages': [],
+      'files': [],
+      'relationships': [],
+  }
+  packages = {}
+  for entry in entries:
+    path = entry['Path']
+    module = entry.get('Module', '')
+    license = license_expression(entry.get('LicenseKinds'))
+    file_id = spdx_id('File', path)
+    doc['files'].append({
+        'fileName': './' + path,
+        'SPDXID': file_id,
+        'checksums': [{
+            'algorithm': 'SHA256',
+            'checksumValue': sha256(os.path.join(root, path)),
+        }],
+        'licenseConcluded': license,
+        'copyrightText': 'NOASSERTION',
+    })
+    if not module:
+      doc['relationships'].append({
+          'spdxElementId': 'SPDXRef-DOCUMENT',
+          'relationshipType': 'DESCRIBES',
+          'relatedSpdxElement': file_id,
+      })
+      continue
+    if module not in packages:
+      package_id = spdx_id('Package', module)
+      packages[module] = package_id
+      doc['packages'].append({
+          'name': module,
+          'SPDXID': package_id,
+          'downloadLocation': 'NOASSERTION',
+          'filesAnalyzed': False,
+          'licenseConcluded': license,
+          'licenseDeclared': license,
+          'copyrightText': 'NOASSERTION',
+      })
+      doc['relationships'].append({
+          'spdxElementId': 'SPDXRef-DOCUMENT',
+          'relationshipType': 'DESCRIBES',
+          'relatedSpdxElement': package_id,
+      })
+    doc['relationships'].append({
+        'spdxElementId': packages[module],
+        'relationshipType': 'CONTAINS',
+        'relatedSpdxElement': file_id,
+    })
+  return doc
+
+
+def main():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--name', required=True, help='name of the snapshot')
+  parser.add_argument('--root', required=True, help='root directory of the snapshot')
+  parser.add_argument('--metadata', required=True, help='path to the metadata of the captured files')
+  parser.add_argument('--output', required=True, help='path to the SBOM')
+  args = parser.parse_args()
+
+  with open(args.metadata) as f:
+    entries = json.load(f)
+  doc = generate_sbom(args.name, args.root, entries)
+  with open(args.output, 'w') as f:
+    json.dump(doc, f, indent=2, sort_keys=True)
+    f.write('\n')
+
+
+if __name__ == '__main__':
+  main()
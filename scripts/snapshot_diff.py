@@ -0,0 +1,76 @@
+#!/usr/bin/env python3
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Compares two snapshot zips and reports ABI-relevant differences.
+
+Each captured module of a snapshot zip has a json file next to its prebuilt
+describing how it was built. This tool matches the json files of two snapshots
+by path and reports added and removed modules, and modules whose metadata
+changed.
+"""
+
+import argparse
+import json
+import zipfile
+
+
+def load_metadata(path):
+  """Returns a dict from json path to parsed metadata of a snapshot zip."""
+  metadata = {}
+  with zipfile.ZipFile(path) as snapshot:
+    for name in snapshot.namelist():
+      if name.endswith('.json'):
+        metadata[name] = json.loads(snapshot.read(name))
+  return metadata
+
+
+def diff_module(base, target):
+  """Returns a list of (field, base value, target value) that differ."""
+  diffs = []
+  for field in sorted(set(base) | set(target)):
+    if base.get(field) != target.get(field):
+      diffs.append((field, base.get(field), target.get(field)))
+  return diffs
+
+
+def diff_snapshots(base, target):
+  """Returns the report lines comparing two snapshot metadata dicts."""
+  lines = []
+  for name in sorted(set(target) - set(base)):
+    lines.append('added: %s' % name)
+  for name in sorted(set(base) - set(target)):
+    lines.append('removed: %s' % name)
+  for name in sorted(set(base) & set(target)):
+    for field, old, new in diff_module(base[name], target[name]):
+      lines.append('changed: %s: %s: %s -> %s' %
+                   (name, field, json.dumps(old), json.dumps(new)))
+  return lines
+
+
+def main():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('base', help='path to the base snapshot zip')
+  parser.add_argument('target', help='path to the target snapshot zip')
+  parser.add_argument('--output', required=True, help='path to the report')
+  args = parser.parse_args()
+
+  lines = diff_snapshots(load_metadata(args.base), load_metadata(args.target))
+  with open(args.output, 'w') as f:
+    for line in lines:
+      f.write(line + '\n')
+
+
+if __name__ == '__main__':
+  main()
@@ -15,10 +15,13 @@
 package sdk
 
 import (
+	"encoding/json"
 	"testing"
 
 	"android/soong/android"
 	"android/soong/cc"
+
+	"github.com/google/blueprint/proptools"
 )
 
 var ccTestFs = android.MockFS{
@@ -2711,3 +2714,56 @@ arm64/include/Arm64Test.h -> arm64/include/arm64/include/Arm64Test.h
 		snapshotTestErrorHandler(checkSnapshotPreferredWithSource, snapshotWithSourceErrorHandler),
 	)
 }
+
+func TestSdkMemberInVendorSnapshot(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForSdkTest,
+		cc.PrepareForTestWithCcIncludeVndk,
+		android.FixtureMergeMockFs(ccTestFs),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.DeviceVndkVersion = proptools.StringPtr("current")
+			variables.Platform_vndk_version = proptools.StringPtr("29")
+		}),
+	).RunTestWithBp(t, `
+		sdk {
+			name: "mysdk",
+			native_shared_libs: ["sdkmember"],
+		}
+
+		cc_library_shared {
+			name: "sdkmember",
+			vendor_available: true,
+			srcs: ["Test.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			compile_multilib: "64",
+		}
+
+		cc_library_shared {
+			name: "libvendor_available",
+			vendor_available: true,
+			srcs: ["Test.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			compile_multilib: "64",
+		}
+	`)
+
+	snapshotSingleton := result.SingletonForTests("vendor-snapshot")
+	sharedDir := "out/soong/vendor-snapshot/arm64/arch-arm64-armv8-a/shared"
+	for _, test := range []struct {
+		name      string
+		sdkMember bool
+	}{
+		{"sdkmember", true},
+		{"libvendor_available", false},
+	} {
+		var prop map[string]interface{}
+		jsonFile := sharedDir + "/" + test.name + ".so.json"
+		content := android.ContentFromFileRuleForTests(t, snapshotSingleton.Output(jsonFile))
+		if err := json.Unmarshal([]byte(content), &prop); err != nil {
+			t.Fatalf("failed to parse %q: %s", jsonFile, err)
+		}
+		android.AssertBoolEquals(t, test.name+" SdkMember", test.sdkMember, prop["SdkMember"] == true)
+	}
+}